@@ -4,12 +4,14 @@ package admin
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"math/big"
 	"time"
 
 	"github.com/weijun-sh/gethscan-server/common"
 	"github.com/weijun-sh/gethscan-server/common/hexutil"
 	"github.com/weijun-sh/gethscan-server/log"
+	"github.com/weijun-sh/gethscan-server/params"
 	"github.com/weijun-sh/gethscan-server/tools"
 	"github.com/weijun-sh/gethscan-server/tools/keystore"
 	"github.com/weijun-sh/gethscan-server/tools/rlp"
@@ -124,6 +126,25 @@ func VerifyTransaction(tx *types.Transaction) (*common.Address, *CallArgs, error
 	return &sender, args, nil
 }
 
+// VerifyAdminCall decode and verify admin call signed by configed admin
+func VerifyAdminCall(rawTx string) (*CallArgs, error) {
+	if !params.HasAdmin() {
+		return nil, errors.New("no admin is configed")
+	}
+	tx, err := DecodeTransaction(rawTx)
+	if err != nil {
+		return nil, err
+	}
+	sender, args, err := VerifyTransaction(tx)
+	if err != nil {
+		return nil, err
+	}
+	if !params.IsAdmin(sender.String()) {
+		return nil, fmt.Errorf("sender %v is not admin", sender.String())
+	}
+	return args, nil
+}
+
 // DecodeTransaction decode tx from hex string
 func DecodeTransaction(rawTx string) (*types.Transaction, error) {
 	data, err := hexutil.Decode(rawTx)
@@ -11,6 +11,7 @@ import (
 	"github.com/weijun-sh/gethscan-server/mongodb"
 	"github.com/weijun-sh/gethscan-server/params"
 	rpcserver "github.com/weijun-sh/gethscan-server/rpc/server"
+	"github.com/weijun-sh/gethscan-server/storage"
 	//"github.com/weijun-sh/gethscan-server/tokens"
 	"github.com/weijun-sh/gethscan-server/worker"
 	"github.com/urfave/cli/v2"
@@ -67,8 +68,18 @@ func swapserver(ctx *cli.Context) error {
 
 	params.SetTokenPairsDir(utils.GetTokenPairsDir(ctx))
 
-	dbConfig := config.Server.MongoDB
-	mongodb.MongoServerInit([]string{dbConfig.DBURL}, dbConfig.DBName, dbConfig.UserName, dbConfig.Password)
+	switch config.Server.GetStorageType() {
+	case params.StorageLevelDB:
+		storageConfig := config.Server.Storage
+		if err := storage.InitLevelDBStorage(params.GetLevelDBPath(), storageConfig.Cache, storageConfig.Handles); err != nil {
+			log.Fatal("open leveldb storage failed", "err", err)
+		}
+	default:
+		dbConfig := config.Server.MongoDB
+		mongodb.MongoServerInit([]string{dbConfig.DBURL}, dbConfig.DBName, dbConfig.UserName, dbConfig.Password)
+	}
+	mongodb.SetInstanceID(config.Server.InstanceID)
+	log.Info("scan server instance", "id", mongodb.GetInstanceID())
 
 	worker.StartWork(true)
 	time.Sleep(100 * time.Millisecond)
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+	"github.com/weijun-sh/gethscan-server/cmd/utils"
+	"github.com/weijun-sh/gethscan-server/log"
+)
+
+var (
+	archiveCommand = &cli.Command{
+		Action:    archive,
+		Name:      "archive",
+		Usage:     "admin purge or restore archived swaps",
+		ArgsUsage: "<purge|restore> <txid|from,to> [exportFile]",
+		Description: `
+admin purge or restore archived swaps by txid or by time range 'from,to'
+time is unix seconds or date like '2006-01-02', empty 'to' means no end.
+restore puts archived swaps back from archive table, or from export file
+on the server if specified (file name in 'ArchiveDir', eg. swaps-20220101-000000.000000000.jsonl.gz).
+purge removes archived swaps permanently.
+`,
+		Flags: commonAdminFlags,
+	}
+)
+
+func archive(ctx *cli.Context) error {
+	utils.SetLogger(ctx)
+	method := "archive"
+	if !(ctx.NArg() == 2 || ctx.NArg() == 3) {
+		_ = cli.ShowCommandHelp(ctx, method)
+		fmt.Println()
+		return fmt.Errorf("invalid arguments: %q", ctx.Args())
+	}
+
+	err := prepare(ctx)
+	if err != nil {
+		return err
+	}
+
+	operation := ctx.Args().Get(0)
+
+	switch operation {
+	case "purge", "restore":
+	default:
+		return fmt.Errorf("unknown operation '%v'", operation)
+	}
+
+	params := ctx.Args().Slice()
+
+	log.Printf("admin archive: %v", params)
+
+	result, err := adminCall(method, params)
+
+	log.Printf("result is '%v'", result)
+	return err
+}
@@ -34,6 +34,10 @@ func initApp() {
 		manualCommand,
 		setnonceCommand,
 		addpairCommand,
+		registerswapCommand,
+		registerrouterswapCommand,
+		scantokensCommand,
+		archiveCommand,
 		utils.LicenseCommand,
 		utils.VersionCommand,
 	}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+	"github.com/weijun-sh/gethscan-server/cmd/utils"
+	"github.com/weijun-sh/gethscan-server/log"
+)
+
+var (
+	registerswapCommand = &cli.Command{
+		Action:    registerswap,
+		Name:      "registerswap",
+		Usage:     "admin register bridge swap",
+		ArgsUsage: "<method> <pairID> <txid> <swapServerName>",
+		Description: `
+admin register bridge swap to be posted to configed swap server
+`,
+		Flags: commonAdminFlags,
+	}
+
+	registerrouterswapCommand = &cli.Command{
+		Action:    registerrouterswap,
+		Name:      "registerrouterswap",
+		Usage:     "admin register router swap",
+		ArgsUsage: "<method> <chainID> <txid> <logIndex> <swapServerName>",
+		Description: `
+admin register router swap to be posted to configed swap server
+`,
+		Flags: commonAdminFlags,
+	}
+)
+
+func registerswap(ctx *cli.Context) error {
+	return doRegisterSwap(ctx, "registerswap", 4)
+}
+
+func registerrouterswap(ctx *cli.Context) error {
+	return doRegisterSwap(ctx, "registerrouterswap", 5)
+}
+
+func doRegisterSwap(ctx *cli.Context, method string, nargs int) error {
+	utils.SetLogger(ctx)
+	if ctx.NArg() != nargs {
+		_ = cli.ShowCommandHelp(ctx, method)
+		fmt.Println()
+		return fmt.Errorf("invalid arguments: %q", ctx.Args())
+	}
+
+	err := prepare(ctx)
+	if err != nil {
+		return err
+	}
+
+	params := ctx.Args().Slice()
+
+	log.Printf("admin %v: %v", method, params)
+
+	result, err := adminCall(method, params)
+
+	log.Printf("result is '%v'", result)
+	return err
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+	"github.com/weijun-sh/gethscan-server/cmd/utils"
+	"github.com/weijun-sh/gethscan-server/log"
+)
+
+var (
+	scantokensCommand = &cli.Command{
+		Action:    scantokens,
+		Name:      "scantokens",
+		Usage:     "manage scan tokens config",
+		ArgsUsage: "<list|add|enable|disable|remove> <chain> [tokenConfigJSON|tokenKey]",
+		Description: `
+manage scan tokens config of chain, changes are saved to '<config file>.admin' beside the config file of chain
+list: list token configs of chain by token key
+add: add token config in json format, eg. '{"TxType":"routerswap","ChainID":"1","RouterContract":"0x...","SwapServer":"http://..."}'
+enable/disable/remove: operate on token config by token key listed
+`,
+		Flags: commonAdminFlags,
+	}
+)
+
+func scantokens(ctx *cli.Context) error {
+	utils.SetLogger(ctx)
+	method := "scantokens"
+	operation := ctx.Args().Get(0)
+	nargs := 3
+	if operation == "list" {
+		nargs = 2
+	}
+	if ctx.NArg() != nargs {
+		_ = cli.ShowCommandHelp(ctx, method)
+		fmt.Println()
+		return fmt.Errorf("invalid arguments: %q", ctx.Args())
+	}
+
+	err := prepare(ctx)
+	if err != nil {
+		return err
+	}
+
+	params := ctx.Args().Slice()
+
+	log.Printf("admin scantokens: %v", params)
+
+	result, err := adminCall(method, params)
+
+	log.Printf("result is '%v'", result)
+	return err
+}
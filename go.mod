@@ -21,7 +21,7 @@ require (
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/rpc v1.2.0
 	github.com/jordan-wright/email v0.0.0-20200917010138-e1c00e156980
-	github.com/jowenshaw/gethclient v0.3.1 // indirect
+	github.com/jowenshaw/gethclient v0.3.1
 	github.com/lestrrat-go/file-rotatelogs v2.4.0+incompatible
 	github.com/lestrrat-go/strftime v1.0.5 // indirect
 	github.com/ltcsuite/ltcd v0.20.1-beta
@@ -30,12 +30,14 @@ require (
 	github.com/ltcsuite/ltcwallet/wallet/txrules v1.0.0
 	github.com/ltcsuite/ltcwallet/wallet/txsizes v1.0.0
 	github.com/pborman/uuid v1.2.1
+	github.com/prometheus/client_golang v1.11.1
 	github.com/shopspring/decimal v1.2.0
 	github.com/sirupsen/logrus v1.7.0
 	github.com/stretchr/testify v1.7.0
 	github.com/syndtr/goleveldb v1.0.1-0.20200815110645-5c35d600f0ca
 	github.com/tendermint/go-amino v0.16.0
 	github.com/urfave/cli/v2 v2.3.0
+	go.mongodb.org/mongo-driver v1.7.5
 	golang.org/x/crypto v0.0.0-20210711020723-a769d52b0f97
-	gopkg.in/mgo.v2 v2.0.0-20190816093944-a6b53ec6cb22
+	golang.org/x/time v0.0.0-20200416051211-89c76fbcd5d1
 )
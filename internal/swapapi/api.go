@@ -9,9 +9,12 @@ import (
 
 	"github.com/btcsuite/btcd/txscript"
 	rpcjson "github.com/gorilla/rpc/v2/json2"
+	"github.com/weijun-sh/gethscan-server/common"
 	"github.com/weijun-sh/gethscan-server/log"
+	"github.com/weijun-sh/gethscan-server/metrics"
 	"github.com/weijun-sh/gethscan-server/mongodb"
 	"github.com/weijun-sh/gethscan-server/params"
+	"github.com/weijun-sh/gethscan-server/storage"
 	"github.com/weijun-sh/gethscan-server/worker"
 	"github.com/weijun-sh/gethscan-server/tokens"
 	"github.com/weijun-sh/gethscan-server/tokens/eth"
@@ -387,18 +390,12 @@ func RegisterSwapPending(chain, txid string) (*PostResult, error) {
 	if !params.MustRegisterAccount() {
 		return &SuccessPostResult, nil
 	}
-	//chain = strings.ToLower(chain)
 	txid = strings.ToLower(txid)
-	ok := params.CheckChainSupport(chain)
-	if !ok {
-		supportErr := fmt.Sprintf("chain '%v' is not support want %v", chain, params.GetChainSupport())
-		return nil, errors.New(supportErr)
-	}
-	ok = params.CheckTxID(txid)
-	if !ok {
-		return nil, errors.New("tx format error")
+	if err := checkChainAndTxID(chain, txid); err != nil {
+		return nil, err
 	}
-	err := mongodb.AddRegisteredSwapPending(chain, txid)
+	metrics.AddRegistrationReceived(chain, "api")
+	err := storage.DB().AddRegisteredSwapPending(chain, txid)
 	if err != nil {
 		return nil, err
 	}
@@ -406,40 +403,177 @@ func RegisterSwapPending(chain, txid string) (*PostResult, error) {
 	return &SuccessPostResult, nil
 }
 
-func BuildRegisterSwap(chain, txid string) error {
-	//chain = strings.ToLower(chain)
-	txid = strings.ToLower(txid)
-	ok := params.CheckChainSupport(chain)
-	if !ok {
-		supportErr := fmt.Sprintf("chain '%v' is not support want %v", chain, params.GetChainSupport())
-		return errors.New(supportErr)
+func checkChainAndTxID(chain, txid string) error {
+	if !params.CheckChainSupport(chain) {
+		return fmt.Errorf("chain '%v' is not support want %v", chain, params.GetChainSupport())
 	}
-	ok = params.CheckTxID(txid)
-	if !ok {
+	if !params.CheckTxID(txid) {
 		return errors.New("tx format error")
 	}
-	post, err := mongodb.FindRegisterdSwapTxid(txid)
+	return nil
+}
+
+// RegisterSwapAsync validate and enqueue tx to be verified and posted in background,
+// return the register job (the existing one if tx is already enqueued).
+func RegisterSwapAsync(chain, txid string) (*RegisterJob, error) {
+	txid = strings.ToLower(txid)
+	if err := checkChainAndTxID(chain, txid); err != nil {
+		return nil, err
+	}
+	metrics.AddRegistrationReceived(chain, "api")
+	err := storage.DB().AddRegisteredSwapPending(chain, txid)
+	if err != nil && !errors.Is(err, mongodb.ErrItemIsDup) {
+		return nil, err
+	}
+	job, err := getRegisterJob(chain, txid)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("[api] register swap async", "chain", chain, "txid", txid, "jobID", job.JobID, "status", job.Status)
+	return job, nil
+}
+
+// getRegisterJob get register job of tx enqueued
+func getRegisterJob(chain, txid string) (*RegisterJob, error) {
+	pending, err := storage.DB().FindSwapPendingStatus(txid)
+	if err != nil {
+		return nil, err
+	}
+	if pending.Chain != chain {
+		return nil, fmt.Errorf("tx is already registered on chain '%v'", pending.Chain)
+	}
+	job := &RegisterJob{
+		JobID:  pending.JobID,
+		Chain:  pending.Chain,
+		Txid:   pending.Key,
+		Status: pending.Status,
+	}
+	if job.JobID == "" { // registered before job id is introduced
+		job.JobID = mongodb.GetRegisterJobID(chain, txid)
+		if err = storage.DB().UpdateSwapPendingJobID(txid, job.JobID); err != nil {
+			return nil, err
+		}
+	}
+	return job, nil
+}
+
+// RegisterSwapBatch validate and enqueue txs to be verified and posted in background
+// with one bulk write, return result of every item in order
+// (the existing job if tx is already enqueued, or the error if item is rejected).
+func RegisterSwapBatch(items []*RegisterItem) ([]*RegisterBatchResult, error) {
+	if len(items) == 0 {
+		return nil, errors.New("empty batch")
+	}
+	if limit := params.GetMaxBatchRegisterItems(); len(items) > limit {
+		return nil, fmt.Errorf("batch has %v items, exceeds limit %v", len(items), limit)
+	}
+	results := make([]*RegisterBatchResult, len(items))
+	valids := make([]*RegisterItem, 0, len(items))
+	indexes := make([]int, 0, len(items))
+	for i, item := range items {
+		if item == nil {
+			results[i] = &RegisterBatchResult{Error: "empty item"}
+			continue
+		}
+		chain, txid := item.Chain, strings.ToLower(item.TxID)
+		results[i] = &RegisterBatchResult{Chain: chain, Txid: txid}
+		if err := checkChainAndTxID(chain, txid); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		metrics.AddRegistrationReceived(chain, "api")
+		valids = append(valids, &RegisterItem{Chain: chain, TxID: txid})
+		indexes = append(indexes, i)
+	}
+	if len(valids) == 0 {
+		return results, nil
+	}
+	errs, err := storage.DB().AddRegisteredSwapPendings(valids)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range indexes {
+		result := results[i]
+		switch {
+		case errs[j] == nil:
+			result.JobID = mongodb.GetRegisterJobID(result.Chain, result.Txid)
+			result.Status = mongodb.StateSubmitted
+		case errors.Is(errs[j], mongodb.ErrItemIsDup):
+			job, errj := getRegisterJob(result.Chain, result.Txid)
+			if errj != nil {
+				result.Error = errj.Error()
+				continue
+			}
+			result.JobID = job.JobID
+			result.Status = job.Status
+		default:
+			result.Error = errs[j].Error()
+		}
+	}
+	log.Info("[api] register swap batch", "items", len(items), "valid", len(valids))
+	return results, nil
+}
+
+// GetRegisterJob get status of register job
+func GetRegisterJob(jobID string) (*SwapRegisterStatus, error) {
+	pending, err := storage.DB().FindSwapPendingByJobID(jobID)
+	if err != nil {
+		return nil, err
+	}
+	return RegisterSwapStatus(pending.Key)
+}
+
+func BuildRegisterSwap(chain, txid string) error {
+	txid = strings.ToLower(txid)
+	if err := checkChainAndTxID(chain, txid); err != nil {
+		return err
+	}
+	posts, err := storage.DB().FindRegisterdSwapTxid(txid)
 	if err == nil {
-		ret := fmt.Sprintf("%v", post.Status)
-		return errors.New(ret)
+		return errors.New(getRegisteredSwapsStatus(posts))
 	}
 	err = eth.ParseTx(chain, txid)
 	if err != nil {
 		return err
 	}
 	log.Info("[api] BuildRegisterSwap", "chain", chain, "txid", txid)
-	post, err = mongodb.FindRegisterdSwapTxid(txid)
+	posts, err = storage.DB().FindRegisterdSwapTxid(txid)
 	if err != nil {
 		return err
 	}
-	err1, err2 :=  worker.PostBridgeSwap(post)
-	if err1 != nil {
-		err = mongodb.AddRegisteredSwapPending(chain, txid)
-		//if err != nil {
-		//	return err
-		//}
+	var postErr error
+	for _, post := range posts {
+		if post.Status != mongodb.StateVerified {
+			if postErr == nil {
+				postErr = errors.New(getRegisteredSwapStatus(post)) // eg. waiting confirmations
+			}
+			continue
+		}
+		// post failed for transient reasons is retried by post retry job
+		_, err2 := worker.PostBridgeSwap(post)
+		if err2 != nil && postErr == nil {
+			postErr = err2
+		}
+	}
+	return postErr
+}
+
+func getRegisteredSwapStatus(post *mongodb.MgoRegisteredSwap) string {
+	if post.LastError != "" {
+		return fmt.Sprintf("%v (%v)", post.Status, post.LastError)
+	}
+	return string(post.Status)
+}
+
+func getRegisteredSwapsStatus(posts []*mongodb.MgoRegisteredSwap) string {
+	if len(posts) == 1 {
+		return getRegisteredSwapStatus(posts[0])
+	}
+	status := make([]string, 0, len(posts))
+	for _, post := range posts {
+		status = append(status, fmt.Sprintf("%v:%v", post.LogIndex, getRegisteredSwapStatus(post)))
 	}
-	return err2
+	return strings.Join(status, "; ")
 }
 
 // RegisterSwapStatus register Swap for ETH like chain
@@ -456,48 +590,108 @@ func RegisterSwapStatus(txid string) (*SwapRegisterStatus, error) {
 	var result SwapRegisterStatus
 	result.Txid = txid
 
-	//pStatus, err := mongodb.FindSwapPendingStatus(txid)
-	//if err == nil {
-	//	var submit submitStatus
-	//	if len(pStatus.Chain) != 0 {
-	//		result.Chain = pStatus.Chain
-	//	}
-	//	submit.Status = pStatus.Status
-	//	submit.Time = pStatus.Time
-	//	result.Submit = &submit
-	//}
-	rStatus, errR := mongodb.FindRegisteredSwapStatus(txid)
-	if errR == nil {
-		if len(rStatus.PairID) != 0 { // bridge
-			var post postBridgeStatus
-			post.Pairid = rStatus.PairID
-			post.RpcMethod = rStatus.Method
-			post.Status = rStatus.Status
-			post.Time = rStatus.Time
-			result.Register = &post
-			if len(rStatus.Chain) != 0 {
-				result.Chainid = rStatus.Chain
-			}
-		} else {
-			var post postRouterStatus
-			post.LogIndex = fmt.Sprintf("%v", rStatus.LogIndex)
-			post.RpcMethod = rStatus.Method
-			post.Status = rStatus.Status
-			post.Time = rStatus.Time
-			result.Register = &post
-			if rStatus.ChainID != 0 {
-				result.Chainid = fmt.Sprintf("%v", rStatus.ChainID)
+	pStatus, errP := storage.DB().FindSwapPendingStatus(txid)
+	rStatus, errR := storage.DB().FindRegisterdSwapTxid(txid)
+	result.Chains = getChainSwapStatus(pStatus, rStatus)
+	if errR == nil && len(rStatus) > 0 {
+		for _, rs := range rStatus {
+			if len(rs.PairID) != 0 { // bridge
+				var post postBridgeStatus
+				post.Pairid = rs.PairID
+				post.RpcMethod = rs.Method
+				post.LogIndex = fmt.Sprintf("%v", rs.LogIndex)
+				post.Status = getRegisteredSwapStatus(rs)
+				post.PostResult = rs.PostResult
+				post.Time = rs.Time
+				result.Registers = append(result.Registers, &post)
+				if len(rs.Chain) != 0 {
+					result.Chainid = rs.Chain
+				}
+			} else {
+				var post postRouterStatus
+				post.LogIndex = fmt.Sprintf("%v", rs.LogIndex)
+				post.RpcMethod = rs.Method
+				post.Event = rs.Event
+				post.Status = getRegisteredSwapStatus(rs)
+				post.PostResult = rs.PostResult
+				post.Time = rs.Time
+				result.Registers = append(result.Registers, &post)
+				if rs.ChainID != 0 {
+					result.Chainid = fmt.Sprintf("%v", rs.ChainID)
+				}
 			}
 		}
 	} else {
 		var post nullStatus
 		post.Status = "not register"
+		if errP == nil {
+			post.Status = fmt.Sprintf("pending %v", pStatus.Status)
+			result.Chainid = pStatus.Chain
+		}
 		result.Register = &post
 	}
+	if len(result.Registers) > 0 {
+		result.Register = result.Registers[0]
+	}
 	log.Info("[api] register swap status", "txid", txid, "result", result)
 	return &result, nil
 }
 
+// getChainSwapStatus group pending and registered swaps by chain
+func getChainSwapStatus(pending *mongodb.MgoRegisteredSwapPending, swaps []*mongodb.MgoRegisteredSwap) []*ChainSwapStatus {
+	var result []*ChainSwapStatus
+	getChainStatus := func(chain string) *ChainSwapStatus {
+		for _, cs := range result {
+			if cs.Chain == chain {
+				return cs
+			}
+		}
+		cs := &ChainSwapStatus{Chain: chain, Swaps: []*RegisteredSwapStatus{}}
+		result = append(result, cs)
+		return cs
+	}
+	if pending != nil {
+		getChainStatus(pending.Chain).Pending = ConvertMgoSwapPendingToStatus(pending)
+	}
+	for _, rs := range swaps {
+		cs := getChainStatus(rs.Chain)
+		cs.Swaps = append(cs.Swaps, ConvertMgoRegisteredSwapToStatus(rs))
+	}
+	return result
+}
+
+// GetRegisteredSwapsByPostResult get registered swaps with post result
+func GetRegisteredSwapsByPostResult(chain, postResult string, offset, limit int) ([]*RegisteredSwap, error) {
+	log.Debug("[api] receive GetRegisteredSwapsByPostResult", "chain", chain, "postResult", postResult, "offset", offset, "limit", limit)
+	if !mongodb.IsValidPostResult(postResult) {
+		return nil, fmt.Errorf("unknown post result '%v'", postResult)
+	}
+	limit = processHistoryLimit(limit)
+	return storage.DB().FindRegisteredSwapWithPostResult(chain, postResult, offset, limit)
+}
+
+// GetRegisteredSwapsByAddress get registered router swaps whose event sender or recipient is address
+func GetRegisteredSwapsByAddress(address string, offset, limit int) ([]*RegisteredSwap, error) {
+	log.Debug("[api] receive GetRegisteredSwapsByAddress", "address", address, "offset", offset, "limit", limit)
+	if !common.IsHexAddress(address) {
+		return nil, fmt.Errorf("wrong address '%v'", address)
+	}
+	limit = processHistoryLimit(limit)
+	return storage.DB().FindRegisteredSwapByAddress(address, offset, limit)
+}
+
+// getAllowedSwapServer get url of configed swap server by name
+func getAllowedSwapServer(name, method string) (string, error) {
+	server := params.GetSwapServer(name)
+	if server == nil {
+		return "", fmt.Errorf("swap server '%v' is not configed", name)
+	}
+	if !server.IsAllowedMethod(method) {
+		return "", fmt.Errorf("method '%v' is not allowed by swap server '%v'", method, name)
+	}
+	return server.URL, nil
+}
+
 // RegisterSwap register Swap for ETH like chain
 func RegisterSwap(chain, method, pairid, txid, swapServer string) (*PostResult, error) {
 	if !params.MustRegisterAccount() {
@@ -507,8 +701,12 @@ func RegisterSwap(chain, method, pairid, txid, swapServer string) (*PostResult,
 	//method = strings.ToLower(method)
 	pairid = strings.ToLower(pairid)
 	txid = strings.ToLower(txid)
-	swapServer = strings.ToLower(swapServer)
-	err := mongodb.AddRegisteredSwap(chain, method, pairid, txid, "0", "0", swapServer)
+	swapServer, err := getAllowedSwapServer(swapServer, method)
+	if err != nil {
+		return nil, err
+	}
+	metrics.AddRegistrationReceived(chain, "admin")
+	err = storage.AddRegisteredSwap(chain, method, pairid, txid, "0", "0", swapServer)
 	if err != nil {
 		return nil, err
 	}
@@ -526,8 +724,12 @@ func RegisterSwapRouter(chain, method, chainid, txid, logIndex, swapServer strin
 	chainid = strings.ToLower(chainid)
 	txid = strings.ToLower(txid)
 	logIndex = strings.ToLower(logIndex)
-	swapServer = strings.ToLower(swapServer)
-	err := mongodb.AddRegisteredSwap(chain, method, "", txid, chainid, logIndex, swapServer)
+	swapServer, err := getAllowedSwapServer(swapServer, method)
+	if err != nil {
+		return nil, err
+	}
+	metrics.AddRegistrationReceived(chain, "admin")
+	err = storage.AddRegisteredSwap(chain, method, "", txid, chainid, logIndex, swapServer)
 	if err != nil {
 		return nil, err
 	}
@@ -554,3 +756,41 @@ func GetRegisteredAddress(address string) (*RegisteredAddress, error) {
 	address = strings.ToLower(address)
 	return mongodb.FindRegisteredAddress(address)
 }
+
+var healthCheckTimeout = 3 * time.Second
+
+// GetHealth get health of server and its dependencies,
+// server is not ready if storage (mongodb or leveldb) is not available or rpcs of all chains are unreachable.
+func GetHealth() *HealthStatus {
+	health := &HealthStatus{
+		Storage: &StorageHealth{Type: storage.DB().Type()},
+		Chains:  eth.GetChainsHealth(),
+	}
+
+	if err := storage.DB().Ping(healthCheckTimeout); err != nil {
+		health.Storage.Error = err.Error()
+		health.Errors = append(health.Errors, health.Storage.Type+" is not connected")
+	} else {
+		health.Storage.Connected = true
+		if oldest, err := storage.DB().FindOldestSwapPending(); err == nil {
+			health.OldestPendingAge = time.Now().Unix() - oldest.Timestamp
+		}
+		health.Pending, health.PostBacklog, _ = storage.DB().CountSwapStates()
+	}
+
+	if len(health.Chains) > 0 {
+		reachable := false
+		for _, chain := range health.Chains {
+			if chain.Reachable {
+				reachable = true
+				break
+			}
+		}
+		if !reachable {
+			health.Errors = append(health.Errors, "rpcs of all chains are unreachable")
+		}
+	}
+
+	health.Ready = len(health.Errors) == 0
+	return health
+}
@@ -5,6 +5,39 @@ import (
 	"github.com/weijun-sh/gethscan-server/tokens"
 )
 
+// ConvertMgoRegisteredSwapToStatus convert
+func ConvertMgoRegisteredSwapToStatus(rs *mongodb.MgoRegisteredSwap) *RegisteredSwapStatus {
+	return &RegisteredSwapStatus{
+		PairID:        rs.PairID,
+		ChainID:       rs.ChainID,
+		LogIndex:      rs.LogIndex,
+		RpcMethod:     rs.Method,
+		SwapServer:    rs.SwapServer,
+		Status:        rs.Status,
+		Error:         rs.LastError,
+		BlockHeight:   rs.BlockHeight,
+		BlockHash:     rs.BlockHash,
+		Event:         rs.Event,
+		Attempts:      rs.Attempts,
+		NextAttempt:   rs.NextAttempt,
+		PostResult:    rs.PostResult,
+		PostErrorCode: rs.PostErrorCode,
+		LastResponse:  rs.LastResponse,
+		Time:          rs.Time,
+		History:       rs.History,
+	}
+}
+
+// ConvertMgoSwapPendingToStatus convert
+func ConvertMgoSwapPendingToStatus(ps *mongodb.MgoRegisteredSwapPending) *PendingSwapStatus {
+	return &PendingSwapStatus{
+		Status:  ps.Status,
+		Error:   ps.LastError,
+		Time:    ps.Time,
+		History: ps.History,
+	}
+}
+
 // ConvertMgoSwapToSwapInfo convert
 func ConvertMgoSwapToSwapInfo(ms *mongodb.MgoSwap) *SwapInfo {
 	return &SwapInfo{
@@ -3,6 +3,7 @@ package swapapi
 import (
 	"github.com/weijun-sh/gethscan-server/mongodb"
 	"github.com/weijun-sh/gethscan-server/tokens"
+	"github.com/weijun-sh/gethscan-server/tokens/eth"
 )
 
 // SwapStatus type alias
@@ -23,6 +24,9 @@ type LatestScanInfo = mongodb.MgoLatestScanInfo
 // RegisteredAddress type alias
 type RegisteredAddress = mongodb.MgoRegisteredAddress
 
+// RegisteredSwap type alias
+type RegisteredSwap = mongodb.MgoRegisteredSwap
+
 // ServerInfo server info
 type ServerInfo struct {
 	Identifier          string
@@ -37,7 +41,74 @@ type SwapRegisterStatus struct {
 	Chainid string
 	Txid string
 	//Submit *submitStatus
-	Register interface{}
+	Register interface{} // status of the first (or only) swap log
+	// status of every swap log of tx
+	Registers []interface{} `json:",omitempty"`
+
+	// status of the whole pipeline of every chain
+	Chains []*ChainSwapStatus `json:",omitempty"`
+}
+
+// RegisterJob async register job
+type RegisterJob struct {
+	JobID  string
+	Chain  string
+	Txid   string
+	Status mongodb.SwapState
+}
+
+// RegisterItem type alias
+type RegisterItem = mongodb.RegisterItem
+
+// RegisterBatchResult result of one item of batch registration,
+// Error is set if the item is rejected, otherwise the register job is returned.
+type RegisterBatchResult struct {
+	Chain  string
+	Txid   string
+	JobID  string            `json:",omitempty"`
+	Status mongodb.SwapState `json:",omitempty"`
+	Error  string            `json:",omitempty"`
+}
+
+// ChainSwapStatus pipeline status of tx on one chain
+type ChainSwapStatus struct {
+	Chain   string
+	Pending *PendingSwapStatus `json:",omitempty"`
+	Swaps   []*RegisteredSwapStatus
+}
+
+// PendingSwapStatus submit and verification result of tx
+type PendingSwapStatus struct {
+	Status  mongodb.SwapState
+	Error   string `json:",omitempty"`
+	Time    string
+	History []*mongodb.MgoStateHistory `json:",omitempty"`
+}
+
+// RegisteredSwapStatus status of registered swap (one per log or pair)
+type RegisteredSwapStatus struct {
+	PairID      string `json:",omitempty"`
+	ChainID     uint64 `json:",omitempty"`
+	LogIndex    uint64
+	RpcMethod   string
+	SwapServer  string
+	Status      mongodb.SwapState
+	Error       string `json:",omitempty"`
+	BlockHeight uint64 `json:",omitempty"`
+	BlockHash   string `json:",omitempty"`
+
+	// decoded router swap log
+	Event *mongodb.MgoRouterSwapEvent `json:",omitempty"`
+
+	// post attempts and latest response of swap server
+	Attempts      int
+	NextAttempt   int64  `json:",omitempty"`
+	PostResult    string `json:",omitempty"`
+	PostErrorCode int    `json:",omitempty"`
+	LastResponse  string `json:",omitempty"`
+
+	Time    string
+	History []*mongodb.MgoStateHistory `json:",omitempty"`
 }
 
 type submitStatus struct {
@@ -51,18 +122,44 @@ type nullStatus struct {
 
 type postBridgeStatus struct {
 	Status string
+	PostResult string `json:",omitempty"`
 	Pairid string
+	LogIndex string
 	RpcMethod string
 	Time string
 }
 
 type postRouterStatus struct {
 	Status string
+	PostResult string `json:",omitempty"`
 	LogIndex string
 	RpcMethod string
+	Event *mongodb.MgoRouterSwapEvent `json:",omitempty"`
 	Time string
 }
 
+// HealthStatus health of server and its dependencies
+type HealthStatus struct {
+	Ready  bool
+	Errors []string `json:",omitempty"` // reasons of not ready
+
+	Storage *StorageHealth
+	Chains  []*eth.ChainHealth
+
+	// seconds since the oldest swap pending not verified yet is registered
+	OldestPendingAge int64
+	// number of records in not final states
+	Pending     map[mongodb.SwapState]int `json:",omitempty"`
+	PostBacklog map[mongodb.SwapState]int `json:",omitempty"`
+}
+
+// StorageHealth storage backend state
+type StorageHealth struct {
+	Type      string // 'mongodb' or 'leveldb'
+	Connected bool
+	Error     string `json:",omitempty"`
+}
+
 // PostResult post result
 type PostResult string
 
@@ -0,0 +1,135 @@
+// Package metrics provides prometheus metrics of the scan and post pipeline.
+package metrics
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "scanserver"
+
+var (
+	registrationsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "registrations_received_total",
+		Help:      "Number of swap registrations received, by chain and source (api, admin, scan).",
+	}, []string{"chain", "source"})
+
+	verifyResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "verify_results_total",
+		Help:      "Number of verified registered txs, by chain and result (success or error).",
+	}, []string{"chain", "result"})
+
+	postDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "post_duration_seconds",
+		Help:      "Latency of posting swap to swap server, by swap server and post result.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"server", "result"})
+
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Number of records in swapPending and swapRegistered tables, by table and state.",
+	}, []string{"table", "state"})
+
+	gatewayCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "gateway_calls_total",
+		Help:      "Number of rpc calls to chain gateways, by chain and gateway host.",
+	}, []string{"chain", "gateway"})
+
+	gatewayErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "gateway_errors_total",
+		Help:      "Number of failed rpc calls to chain gateways, by chain and gateway host.",
+	}, []string{"chain", "gateway"})
+
+	postBreakerOpen = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "post_breaker_open",
+		Help:      "Whether circuit breaker of posting to swap server is open (1) or not (0), by swap server.",
+	}, []string{"server"})
+
+	mongoDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mongodb_duration_seconds",
+		Help:      "Latency of mongodb operations, by operation.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"op"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		registrationsReceived,
+		verifyResults,
+		postDuration,
+		queueDepth,
+		gatewayCalls,
+		gatewayErrors,
+		postBreakerOpen,
+		mongoDuration,
+	)
+}
+
+// Handler http handler of metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// AddRegistrationReceived count swap registration
+func AddRegistrationReceived(chain, source string) {
+	registrationsReceived.WithLabelValues(chain, source).Inc()
+}
+
+// AddVerifyResult count verify result
+func AddVerifyResult(chain, result string) {
+	verifyResults.WithLabelValues(chain, result).Inc()
+}
+
+// ObservePost observe post latency and result
+func ObservePost(server, result string, start time.Time) {
+	postDuration.WithLabelValues(GetHost(server), result).Observe(time.Since(start).Seconds())
+}
+
+// SetPostBreakerOpen set circuit breaker state of swap server
+func SetPostBreakerOpen(server string, open bool) {
+	value := 0.0
+	if open {
+		value = 1
+	}
+	postBreakerOpen.WithLabelValues(GetHost(server)).Set(value)
+}
+
+// SetQueueDepth set number of records of table in state
+func SetQueueDepth(table, state string, count int) {
+	queueDepth.WithLabelValues(table, state).Set(float64(count))
+}
+
+// AddGatewayCall count rpc call to gateway
+func AddGatewayCall(chain, gateway string, err error) {
+	host := GetHost(gateway)
+	gatewayCalls.WithLabelValues(chain, host).Inc()
+	if err != nil {
+		gatewayErrors.WithLabelValues(chain, host).Inc()
+	}
+}
+
+// ObserveMongoOp observe mongodb operation latency, call with defer
+func ObserveMongoOp(op string, start time.Time) {
+	mongoDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+}
+
+// GetHost use host as label, as url path or query may contain api keys
+func GetHost(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Host == "" {
+		return "unknown"
+	}
+	return u.Host
+}
@@ -8,7 +8,7 @@ import (
 
 	"github.com/weijun-sh/gethscan-server/log"
 	"github.com/weijun-sh/gethscan-server/tokens"
-	"gopkg.in/mgo.v2"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 const (
@@ -29,7 +29,7 @@ func AddToBlacklist(address, pairID string) error {
 		PairID:    strings.ToLower(pairID),
 		Timestamp: time.Now().Unix(),
 	}
-	err := collBlacklist.Insert(mb)
+	err := insert(collBlacklist, mb)
 	if err == nil {
 		log.Info("mongodb add to black list success", "address", address, "pairID", pairID)
 	} else {
@@ -40,7 +40,7 @@ func AddToBlacklist(address, pairID string) error {
 
 // RemoveFromBlacklist remove from blacklist
 func RemoveFromBlacklist(address, pairID string) error {
-	err := collBlacklist.RemoveId(getBlacklistKey(address, pairID))
+	err := removeID(collBlacklist, getBlacklistKey(address, pairID))
 	if err == nil {
 		log.Info("mongodb remove from black list success", "address", address, "pairID", pairID)
 	} else {
@@ -52,11 +52,11 @@ func RemoveFromBlacklist(address, pairID string) error {
 // QueryBlacklist query if is blacked
 func QueryBlacklist(address, pairID string) (isBlacked bool, err error) {
 	var result MgoBlackAccount
-	err = collBlacklist.FindId(getBlacklistKey(address, pairID)).One(&result)
+	err = findID(collBlacklist, getBlacklistKey(address, pairID)).One(&result)
 	if err == nil {
 		return true, nil
 	}
-	if errors.Is(err, mgo.ErrNotFound) {
+	if errors.Is(err, mongo.ErrNoDocuments) {
 		return false, nil
 	}
 	return false, err
@@ -1,6 +1,8 @@
 package mongodb
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"math/big"
 	"strings"
@@ -10,8 +12,10 @@ import (
 
 	"github.com/weijun-sh/gethscan-server/common"
 	"github.com/weijun-sh/gethscan-server/log"
-	"gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
+	"github.com/weijun-sh/gethscan-server/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 const (
@@ -20,13 +24,24 @@ const (
 	allAddresses      = "all"
 )
 
+// classified results of posting swap to swap server
 const (
-	SwapSuccess string = "success" //find and success post
-	NewRegister string = "new"// new
-	SwapError string = "failed"
-	SwapNotFound string = "swap not found"
+	PostResultSuccess   string = "success"   // accepted by swap server
+	PostResultDuplicate string = "duplicate" // already registered in swap server
+	PostResultRejected  string = "rejected"  // rejected by swap server permanently
+	PostResultTransient string = "transient" // failed for transient reasons, retry later
 )
 
+// IsValidPostResult is valid post result
+func IsValidPostResult(result string) bool {
+	switch result {
+	case PostResultSuccess, PostResultDuplicate, PostResultRejected, PostResultTransient:
+		return true
+	default:
+		return false
+	}
+}
+
 var (
 	retryLock        sync.Mutex
 	updateResultLock sync.Mutex
@@ -138,7 +153,7 @@ func GetCountOfSwapoutsWithStatus(pairID string, status SwapStatus) (int, error)
 
 // ------------------ swapin / swapout common ------------------------
 
-func addSwap(collection *mgo.Collection, ms *MgoSwap) error {
+func addSwap(collection *mongo.Collection, ms *MgoSwap) error {
 	if ms.TxID == "" || ms.PairID == "" || ms.Bind == "" {
 		log.Error("mongodb add swap with wrong key", "txid", ms.TxID, "pairID", ms.PairID, "bind", ms.Bind, "isSwapin", isSwapin(collection))
 		return ErrWrongKey
@@ -146,7 +161,7 @@ func addSwap(collection *mgo.Collection, ms *MgoSwap) error {
 	ms.PairID = strings.ToLower(ms.PairID)
 	ms.Key = GetSwapKey(ms.TxID, ms.PairID, ms.Bind)
 	ms.InitTime = common.NowMilli()
-	err := collection.Insert(ms)
+	err := insert(collection, ms)
 	if err == nil {
 		log.Info("mongodb add swap", "txid", ms.TxID, "pairID", ms.PairID, "bind", ms.Bind, "isSwapin", isSwapin(collection))
 	} else {
@@ -155,7 +170,7 @@ func addSwap(collection *mgo.Collection, ms *MgoSwap) error {
 	return mgoError(err)
 }
 
-func updateSwapStatus(collection *mgo.Collection, txid, pairID, bind string, status SwapStatus, timestamp int64, memo string) error {
+func updateSwapStatus(collection *mongo.Collection, txid, pairID, bind string, status SwapStatus, timestamp int64, memo string) error {
 	pairID = strings.ToLower(pairID)
 	updates := bson.M{"status": status, "timestamp": timestamp}
 	if memo != "" {
@@ -171,7 +186,7 @@ func updateSwapStatus(collection *mgo.Collection, txid, pairID, bind string, sta
 			return nil
 		}
 	}
-	err := collection.UpdateId(GetSwapKey(txid, pairID, bind), bson.M{"$set": updates})
+	err := updateID(collection, GetSwapKey(txid, pairID, bind), bson.M{"$set": updates})
 	if err == nil {
 		printLog := log.Info
 		switch status {
@@ -191,7 +206,7 @@ func GetSwapKey(txid, pairID, bind string) string {
 	return strings.ToLower(txid + ":" + pairID + ":" + bind)
 }
 
-func findSwap(collection *mgo.Collection, txid, pairID, bind string) (*MgoSwap, error) {
+func findSwap(collection *mongo.Collection, txid, pairID, bind string) (*MgoSwap, error) {
 	result := &MgoSwap{}
 	err := findSwapOrSwapResult(result, collection, txid, pairID, bind)
 	if err != nil {
@@ -200,43 +215,43 @@ func findSwap(collection *mgo.Collection, txid, pairID, bind string) (*MgoSwap,
 	return result, nil
 }
 
-func findSwapOrSwapResult(result interface{}, collection *mgo.Collection, txid, pairID, bind string) (err error) {
+func findSwapOrSwapResult(result interface{}, collection *mongo.Collection, txid, pairID, bind string) (err error) {
 	if bind != "" {
-		err = collection.FindId(GetSwapKey(txid, pairID, bind)).One(result)
+		err = findID(collection, GetSwapKey(txid, pairID, bind)).One(result)
 	} else {
 		qtxid := bson.M{"txid": txid}
 		qpair := bson.M{"pairid": strings.ToLower(pairID)}
 		queries := []bson.M{qtxid, qpair}
-		err = collection.Find(bson.M{"$and": queries}).One(result)
+		err = find(collection, bson.M{"$and": queries}).One(result)
 	}
 	return mgoError(err)
 }
 
-func findSwapsWithStatus(collection *mgo.Collection, status SwapStatus, septime int64) (result []*MgoSwap, err error) {
+func findSwapsWithStatus(collection *mongo.Collection, status SwapStatus, septime int64) (result []*MgoSwap, err error) {
 	err = findSwapsOrSwapResultsWithStatus(&result, collection, status, septime)
 	return result, err
 }
 
-func findSwapsOrSwapResultsWithStatus(result interface{}, collection *mgo.Collection, status SwapStatus, septime int64) error {
+func findSwapsOrSwapResultsWithStatus(result interface{}, collection *mongo.Collection, status SwapStatus, septime int64) error {
 	qtime := bson.M{"timestamp": bson.M{"$gte": septime}}
 	qstatus := bson.M{"status": status}
 	queries := []bson.M{qtime, qstatus}
-	q := collection.Find(bson.M{"$and": queries}).Sort("inittime").Limit(maxCountOfResults)
+	q := find(collection, bson.M{"$and": queries}).Sort("inittime").Limit(maxCountOfResults)
 	return mgoError(q.All(result))
 }
 
-func findSwapsWithPairIDAndStatus(pairID string, collection *mgo.Collection, status SwapStatus, septime int64) (result []*MgoSwap, err error) {
+func findSwapsWithPairIDAndStatus(pairID string, collection *mongo.Collection, status SwapStatus, septime int64) (result []*MgoSwap, err error) {
 	err = findSwapsOrSwapResultsWithPairIDAndStatus(&result, pairID, collection, status, septime)
 	return result, err
 }
 
-func findSwapsOrSwapResultsWithPairIDAndStatus(result interface{}, pairID string, collection *mgo.Collection, status SwapStatus, septime int64) error {
+func findSwapsOrSwapResultsWithPairIDAndStatus(result interface{}, pairID string, collection *mongo.Collection, status SwapStatus, septime int64) error {
 	pairID = strings.ToLower(pairID)
 	qpair := bson.M{"pairid": pairID}
 	qtime := bson.M{"timestamp": bson.M{"$gte": septime}}
 	qstatus := bson.M{"status": status}
 	queries := []bson.M{qpair, qtime, qstatus}
-	q := collection.Find(bson.M{"$and": queries}).Sort("inittime").Limit(maxCountOfResults)
+	q := find(collection, bson.M{"$and": queries}).Sort("inittime").Limit(maxCountOfResults)
 	return mgoError(q.All(result))
 }
 
@@ -278,14 +293,14 @@ func FindSwapResultsToReplace(status SwapStatus, septime int64, isSwapin bool) (
 	qheight := bson.M{"swapheight": 0}
 	qtime := bson.M{"timestamp": bson.M{"$gte": septime}}
 	queries := []bson.M{qstatus, qheight, qtime}
-	var collection *mgo.Collection
+	var collection *mongo.Collection
 	if isSwapin {
 		collection = collSwapinResult
 	} else {
 		collection = collSwapoutResult
 	}
 	result := make([]*MgoSwapResult, 0, 20)
-	q := collection.Find(bson.M{"$and": queries}).Sort("inittime").Limit(5)
+	q := find(collection, bson.M{"$and": queries}).Sort("inittime").Limit(5)
 	err := q.All(&result)
 	return result, mgoError(err)
 }
@@ -344,7 +359,7 @@ func GetCountOfSwapoutResultsWithStatus(pairID string, status SwapStatus) (int,
 
 // ------------------ swapin / swapout result common ------------------------
 
-func addSwapResult(collection *mgo.Collection, ms *MgoSwapResult) error {
+func addSwapResult(collection *mongo.Collection, ms *MgoSwapResult) error {
 	if ms.TxID == "" || ms.PairID == "" || ms.Bind == "" {
 		log.Error("mongodb add swap result with wrong key", "txid", ms.TxID, "pairID", ms.PairID, "bind", ms.Bind, "swaptype", ms.SwapType, "isSwapin", isSwapin(collection))
 		return ErrWrongKey
@@ -352,7 +367,7 @@ func addSwapResult(collection *mgo.Collection, ms *MgoSwapResult) error {
 	ms.PairID = strings.ToLower(ms.PairID)
 	ms.Key = GetSwapKey(ms.TxID, ms.PairID, ms.Bind)
 	ms.InitTime = common.NowMilli()
-	err := collection.Insert(ms)
+	err := insert(collection, ms)
 	if err == nil {
 		log.Info("mongodb add swap result", "txid", ms.TxID, "pairID", ms.PairID, "bind", ms.Bind, "swaptype", ms.SwapType, "value", ms.Value, "isSwapin", isSwapin(collection))
 	} else {
@@ -361,7 +376,7 @@ func addSwapResult(collection *mgo.Collection, ms *MgoSwapResult) error {
 	return mgoError(err)
 }
 
-func updateSwapResult(collection *mgo.Collection, txid, pairID, bind string, items *SwapResultUpdateItems) error {
+func updateSwapResult(collection *mongo.Collection, txid, pairID, bind string, items *SwapResultUpdateItems) error {
 	pairID = strings.ToLower(pairID)
 	updates := bson.M{
 		"timestamp": items.Timestamp,
@@ -414,7 +429,7 @@ func updateSwapResult(collection *mgo.Collection, txid, pairID, bind string, ite
 			updates["swapnonce"] = items.SwapNonce
 		}
 	}
-	err := collection.UpdateId(GetSwapKey(txid, pairID, bind), bson.M{"$set": updates})
+	err := updateID(collection, GetSwapKey(txid, pairID, bind), bson.M{"$set": updates})
 	if err == nil {
 		log.Info("mongodb update swap result", "txid", txid, "pairID", pairID, "bind", bind, "updates", updates, "isSwapin", isSwapin(collection))
 	} else {
@@ -423,7 +438,7 @@ func updateSwapResult(collection *mgo.Collection, txid, pairID, bind string, ite
 	return mgoError(err)
 }
 
-func updateSwapResultStatus(collection *mgo.Collection, txid, pairID, bind string, status SwapStatus, timestamp int64, memo string) error {
+func updateSwapResultStatus(collection *mongo.Collection, txid, pairID, bind string, status SwapStatus, timestamp int64, memo string) error {
 	pairID = strings.ToLower(pairID)
 	updates := bson.M{"status": status, "timestamp": timestamp}
 	if memo != "" {
@@ -437,7 +452,7 @@ func updateSwapResultStatus(collection *mgo.Collection, txid, pairID, bind strin
 		updates["swaptime"] = 0
 		updates["swapnonce"] = 0
 	}
-	err := collection.UpdateId(GetSwapKey(txid, pairID, bind), bson.M{"$set": updates})
+	err := updateID(collection, GetSwapKey(txid, pairID, bind), bson.M{"$set": updates})
 	isSwapin := isSwapin(collection)
 	if err == nil {
 		log.Info("mongodb update swap result status", "txid", txid, "pairID", pairID, "bind", bind, "status", status, "isSwapin", isSwapin)
@@ -452,7 +467,7 @@ func updateSwapResultStatus(collection *mgo.Collection, txid, pairID, bind strin
 	return mgoError(err)
 }
 
-func findSwapResult(collection *mgo.Collection, txid, pairID, bind string) (*MgoSwapResult, error) {
+func findSwapResult(collection *mongo.Collection, txid, pairID, bind string) (*MgoSwapResult, error) {
 	result := &MgoSwapResult{}
 	err := findSwapOrSwapResult(result, collection, txid, pairID, bind)
 	if err != nil {
@@ -461,7 +476,7 @@ func findSwapResult(collection *mgo.Collection, txid, pairID, bind string) (*Mgo
 	return result, nil
 }
 
-func findSwapResultsWithStatus(collection *mgo.Collection, status SwapStatus, septime int64) (result []*MgoSwapResult, err error) {
+func findSwapResultsWithStatus(collection *mongo.Collection, status SwapStatus, septime int64) (result []*MgoSwapResult, err error) {
 	err = findSwapsOrSwapResultsWithStatus(&result, collection, status, septime)
 	return result, err
 }
@@ -481,7 +496,7 @@ func getStatusesFromStr(status string) []SwapStatus {
 	return result
 }
 
-func findSwapResults(collection *mgo.Collection, address, pairID string, offset, limit int, status string) ([]*MgoSwapResult, error) {
+func findSwapResults(collection *mongo.Collection, address, pairID string, offset, limit int, status string) ([]*MgoSwapResult, error) {
 	pairID = strings.ToLower(pairID)
 	result := make([]*MgoSwapResult, 0, 20)
 
@@ -508,14 +523,14 @@ func findSwapResults(collection *mgo.Collection, address, pairID string, offset,
 		}
 	}
 
-	var q *mgo.Query
+	var q *query
 	switch len(queries) {
 	case 0:
-		q = collection.Find(nil)
+		q = find(collection, nil)
 	case 1:
-		q = collection.Find(queries[0])
+		q = find(collection, queries[0])
 	default:
-		q = collection.Find(bson.M{"$and": queries})
+		q = find(collection, bson.M{"$and": queries})
 	}
 	if limit >= 0 {
 		q = q.Skip(offset).Limit(limit)
@@ -529,17 +544,17 @@ func findSwapResults(collection *mgo.Collection, address, pairID string, offset,
 	return result, nil
 }
 
-func getCount(collection *mgo.Collection, pairID string) (int, error) {
+func getCount(collection *mongo.Collection, pairID string) (int, error) {
 	pairID = strings.ToLower(pairID)
-	return collection.Find(bson.M{"pairid": pairID}).Count()
+	return find(collection, bson.M{"pairid": pairID}).Count()
 }
 
-func getCountWithStatus(collection *mgo.Collection, pairID string, status SwapStatus) (int, error) {
+func getCountWithStatus(collection *mongo.Collection, pairID string, status SwapStatus) (int, error) {
 	pairID = strings.ToLower(pairID)
 	qpair := bson.M{"pairid": pairID}
 	qstatus := bson.M{"status": status}
 	queries := []bson.M{qpair, qstatus}
-	return collection.Find(bson.M{"$and": queries}).Count()
+	return find(collection, bson.M{"$and": queries}).Count()
 }
 
 // ------------------ statistics ------------------------
@@ -558,7 +573,7 @@ func updateSwapStatistics(pairID, value, swapValue string, isSwapin bool) error
 			TotalSwapoutValue:  "0",
 			TotalSwapoutFee:    "0",
 		}
-		_ = collSwapStatistics.Insert(curr)
+		_ = insert(collSwapStatistics, curr)
 	}
 
 	addVal, _ := new(big.Int).SetString(value, 0)
@@ -586,7 +601,7 @@ func updateSwapStatistics(pairID, value, swapValue string, isSwapin bool) error
 		updates["totalswapoutvalue"] = curVal.String()
 		updates["totalswapoutfee"] = curFee.String()
 	}
-	err := collSwapStatistics.UpdateId(pairID, bson.M{"$set": updates})
+	err := updateID(collSwapStatistics, pairID, bson.M{"$set": updates})
 	if err == nil {
 		log.Info("mongodb update swap statistics", "updates", updates)
 	} else {
@@ -599,7 +614,7 @@ func updateSwapStatistics(pairID, value, swapValue string, isSwapin bool) error
 func FindSwapStatistics(pairID string) (*MgoSwapStatistics, error) {
 	pairID = strings.ToLower(pairID)
 	var result MgoSwapStatistics
-	err := collSwapStatistics.FindId(pairID).One(&result)
+	err := findID(collSwapStatistics, pairID).One(&result)
 	return &result, mgoError(err)
 }
 
@@ -644,7 +659,7 @@ func GetSwapStatistics(pairID string) (*SwapStatistics, error) {
 
 // AddP2shAddress add p2sh address
 func AddP2shAddress(ma *MgoP2shAddress) error {
-	err := collP2shAddress.Insert(ma)
+	err := insert(collP2shAddress, ma)
 	if err == nil {
 		log.Info("mongodb add p2sh address", "key", ma.Key, "p2shaddress", ma.P2shAddress)
 	} else {
@@ -656,7 +671,7 @@ func AddP2shAddress(ma *MgoP2shAddress) error {
 // FindP2shAddress find p2sh addrss through bind address
 func FindP2shAddress(key string) (*MgoP2shAddress, error) {
 	var result MgoP2shAddress
-	err := collP2shAddress.FindId(key).One(&result)
+	err := findID(collP2shAddress, key).One(&result)
 	if err != nil {
 		return nil, mgoError(err)
 	}
@@ -666,7 +681,7 @@ func FindP2shAddress(key string) (*MgoP2shAddress, error) {
 // FindP2shBindAddress find bind address through p2sh address
 func FindP2shBindAddress(p2shAddress string) (string, error) {
 	var result MgoP2shAddress
-	err := collP2shAddress.Find(bson.M{"p2shaddress": p2shAddress}).One(&result)
+	err := find(collP2shAddress, bson.M{"p2shaddress": p2shAddress}).One(&result)
 	if err != nil {
 		return "", mgoError(err)
 	}
@@ -676,7 +691,7 @@ func FindP2shBindAddress(p2shAddress string) (string, error) {
 // FindP2shAddresses find p2sh address
 func FindP2shAddresses(offset, limit int) ([]*MgoP2shAddress, error) {
 	result := make([]*MgoP2shAddress, 0, limit)
-	q := collP2shAddress.Find(nil).Skip(offset).Limit(limit)
+	q := find(collP2shAddress, nil).Skip(offset).Limit(limit)
 	err := q.All(&result)
 	if err != nil {
 		return nil, mgoError(err)
@@ -684,15 +699,51 @@ func FindP2shAddresses(offset, limit int) ([]*MgoP2shAddress, error) {
 	return result, nil
 }
 
-func FindRegisterdSwap(chain string, offset, limit int) ([]*MgoRegisteredSwap, error) {
+// SwapCursor position of scanning records ordered by timestamp and key.
+// records changing status while scanning do not shift the position (unlike offset),
+// so no record is skipped, records entering the status behind the cursor are found in the next round.
+type SwapCursor struct {
+	Timestamp int64
+	Key       string
+}
+
+// query records after cursor, nil cursor means from the start
+func (cursor *SwapCursor) query() bson.M {
+	if cursor == nil {
+		return bson.M{}
+	}
+	return bson.M{"$or": []bson.M{
+		{"timestamp": bson.M{"$gt": cursor.Timestamp}},
+		{"timestamp": cursor.Timestamp, "_id": bson.M{"$gt": cursor.Key}},
+	}}
+}
+
+// Cursor get cursor positioned at this record
+func (s *MgoRegisteredSwap) Cursor() *SwapCursor {
+	return &SwapCursor{Timestamp: s.Timestamp, Key: s.Key}
+}
+
+// Cursor get cursor positioned at this record
+func (s *MgoRegisteredSwapPending) Cursor() *SwapCursor {
+	return &SwapCursor{Timestamp: s.Timestamp, Key: s.Key}
+}
+
+// FindRegisterdSwap find verified registered swaps to post
+func FindRegisterdSwap(chain string, cursor *SwapCursor, limit int) ([]*MgoRegisteredSwap, error) {
+	return FindRegisteredSwapWithStatus(chain, StateVerified, cursor, limit)
+}
+
+// FindRegisteredSwapWithStatus find registered swaps with status after cursor
+func FindRegisteredSwapWithStatus(chain string, status SwapState, cursor *SwapCursor, limit int) ([]*MgoRegisteredSwap, error) {
+	defer metrics.ObserveMongoOp("findRegisteredSwap", time.Now())
 	result := make([]*MgoRegisteredSwap, 0, limit)
 	qchain := bson.M{"chain": chain}
-	qstatus := bson.M{"status": NewRegister}
-	queries := []bson.M{qchain, qstatus}
+	qstatus := bson.M{"status": status}
+	queries := []bson.M{qchain, qstatus, cursor.query(), notLeasedQuery()}
 	if chain == "" {
-		queries = []bson.M{qstatus}
+		queries = []bson.M{qstatus, cursor.query(), notLeasedQuery()}
 	}
-	q := collRegisteredSwap.Find(bson.M{"$and": queries}).Skip(offset).Limit(limit)
+	q := find(collRegisteredSwap, bson.M{"$and": queries}).Sort("timestamp", "_id").Limit(limit)
 	err := q.All(&result)
 	if err != nil {
 		return nil, mgoError(err)
@@ -700,24 +751,41 @@ func FindRegisterdSwap(chain string, offset, limit int) ([]*MgoRegisteredSwap, e
 	return result, nil
 }
 
-func FindRegisterdSwapTxid(txid string) (*MgoRegisteredSwap, error) {
-	var result MgoRegisteredSwap
-	err := collRegisteredSwap.Find(bson.M{"_id": txid}).One(&result)
+// FindRegisterdSwapTxid find all registered swaps of txid
+func FindRegisterdSwapTxid(txid string) ([]*MgoRegisteredSwap, error) {
+	defer metrics.ObserveMongoOp("findRegisteredSwapTxid", time.Now())
+	result := make([]*MgoRegisteredSwap, 0, 1)
+	err := find(collRegisteredSwap, getRegisteredSwapTxidQuery(txid)).All(&result)
 	if err != nil {
 		return nil, mgoError(err)
 	}
-	return &result, nil
+	if len(result) == 0 {
+		return nil, ErrItemNotFound
+	}
+	return result, nil
+}
+
+// records before keyed by txid + logindex + swapserver has no txid field
+func getRegisteredSwapTxidQuery(txid string) bson.M {
+	return bson.M{"$or": []bson.M{{"txid": txid}, {"_id": txid}}}
+}
+
+// GetRegisteredSwapKey get registered swap key
+func GetRegisteredSwapKey(txid, logIndex, swapServer string) string {
+	return strings.ToLower(fmt.Sprintf("%v:%v:%v", txid, logIndex, swapServer))
 }
 
-func FindSwapPending(chain string, offset, limit int) ([]*MgoRegisteredSwapPending, error) {
+// FindSwapPending find swap pending not verified yet after cursor
+func FindSwapPending(chain string, cursor *SwapCursor, limit int) ([]*MgoRegisteredSwapPending, error) {
+	defer metrics.ObserveMongoOp("findSwapPending", time.Now())
 	result := make([]*MgoRegisteredSwapPending, 0, limit)
 	qchain := bson.M{"chain": chain}
-	qstatus := bson.M{"status": NewRegister}
-	queries := []bson.M{qchain, qstatus}
+	qstatus := bson.M{"status": bson.M{"$in": []SwapState{StateSubmitted, StateVerifying}}}
+	queries := []bson.M{qchain, qstatus, cursor.query(), notLeasedQuery()}
 	if chain == "" {
-		queries = []bson.M{qstatus}
+		queries = []bson.M{qstatus, cursor.query(), notLeasedQuery()}
 	}
-	q := collRegisteredSwapPending.Find(bson.M{"$and": queries}).Skip(offset).Limit(limit)
+	q := find(collRegisteredSwapPending, bson.M{"$and": queries}).Sort("timestamp", "_id").Limit(limit)
 	err := q.All(&result)
 	if err != nil {
 		return nil, mgoError(err)
@@ -725,9 +793,22 @@ func FindSwapPending(chain string, offset, limit int) ([]*MgoRegisteredSwapPendi
 	return result, nil
 }
 
+// FindOldestSwapPending find the oldest swap pending not verified yet
+func FindOldestSwapPending() (*MgoRegisteredSwapPending, error) {
+	defer metrics.ObserveMongoOp("findOldestSwapPending", time.Now())
+	var result MgoRegisteredSwapPending
+	qstatus := bson.M{"status": bson.M{"$in": []SwapState{StateSubmitted, StateVerifying}}}
+	err := find(collRegisteredSwapPending, qstatus).Sort("timestamp").One(&result)
+	if err != nil {
+		return nil, mgoError(err)
+	}
+	return &result, nil
+}
+
 func FindSwapPendingTxid(txid string) (*MgoRegisteredSwapPending, error) {
+	defer metrics.ObserveMongoOp("findSwapPendingTxid", time.Now())
 	var result MgoRegisteredSwapPending
-	err := collRegisteredSwapPending.Find(bson.M{"_id": txid}).One(&result)
+	err := find(collRegisteredSwapPending, bson.M{"_id": txid}).One(&result)
 	if err != nil {
 		return nil, mgoError(err)
 	}
@@ -755,7 +836,7 @@ func UpdateLatestScanInfo(isSrc bool, blockHeight uint64) error {
 		"blockheight": blockHeight,
 		"timestamp":   time.Now().Unix(),
 	}
-	err := collLatestScanInfo.UpdateId(key, bson.M{"$set": updates})
+	err := updateID(collLatestScanInfo, key, bson.M{"$set": updates})
 	if err == nil {
 		log.Info("mongodb update lastest scan info", "isSrc", isSrc, "updates", updates)
 	} else {
@@ -773,15 +854,38 @@ func FindLatestScanInfo(isSrc bool) (*MgoLatestScanInfo, error) {
 	} else {
 		key = keyOfDstLatestScanInfo
 	}
-	err := collLatestScanInfo.FindId(key).One(&result)
+	err := findID(collLatestScanInfo, key).One(&result)
 	return &result, mgoError(err)
 }
 
-// FindRegisteredSwapStatus get register swap status
-func FindSwapPendingStatus(txid string) (*MgoRegisteredSwapPending, error) {
-	var result MgoRegisteredSwapPending
-	qTxid := bson.M{"_id": txid}
-	err := collRegisteredSwapPending.Find(qTxid).One(&result)
+func getChainScanInfoKey(chain string) string {
+	return strings.ToLower("scan:" + chain)
+}
+
+// UpdateChainScanInfo update latest scanned block height of chain,
+// return ErrItemIsLeased if scanning of chain is leased by another instance
+func UpdateChainScanInfo(chain string, blockHeight uint64) error {
+	defer metrics.ObserveMongoOp("updateChainScanInfo", time.Now())
+	updates := bson.M{
+		"blockheight": blockHeight,
+		"timestamp":   time.Now().Unix(),
+	}
+	selector := bson.M{"$and": []bson.M{{"_id": getChainScanInfoKey(chain)}, notLeasedQuery()}}
+	err := upsertOne(collLatestScanInfo, selector, bson.M{"$set": updates})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrItemIsLeased
+	}
+	if err != nil {
+		log.Debug("mongodb update chain scan info failed", "chain", chain, "updates", updates, "err", err)
+	}
+	return mgoError(err)
+}
+
+// FindChainScanInfo find latest scanned block height of chain
+func FindChainScanInfo(chain string) (*MgoLatestScanInfo, error) {
+	defer metrics.ObserveMongoOp("findChainScanInfo", time.Now())
+	var result MgoLatestScanInfo
+	err := findID(collLatestScanInfo, getChainScanInfoKey(chain)).One(&result)
 	if err != nil {
 		return nil, mgoError(err)
 	}
@@ -789,110 +893,269 @@ func FindSwapPendingStatus(txid string) (*MgoRegisteredSwapPending, error) {
 }
 
 // FindRegisteredSwapStatus get register swap status
-func FindRegisteredSwapStatus(txid string) (*MgoRegisteredSwap, error) {
-	var result MgoRegisteredSwap
+func FindSwapPendingStatus(txid string) (*MgoRegisteredSwapPending, error) {
+	defer metrics.ObserveMongoOp("findSwapPendingTxid", time.Now())
+	var result MgoRegisteredSwapPending
 	qTxid := bson.M{"_id": txid}
-	err := collRegisteredSwap.Find(qTxid).One(&result)
+	err := find(collRegisteredSwapPending, qTxid).One(&result)
 	if err != nil {
 		return nil, mgoError(err)
 	}
 	return &result, nil
 }
 
-// AddRegisteredSwapPending add register swap tx
-func AddRegisteredSwapPending(chain, txid string) error {
-	now := time.Now()
-	ma := &MgoRegisteredSwapPending{
+// FindRegisteredSwapStatus get register swap status
+func FindRegisteredSwapStatus(txid string) ([]*MgoRegisteredSwap, error) {
+	return FindRegisterdSwapTxid(txid)
+}
+
+// NewRegisteredSwapPending new submitted swap pending of register swap tx
+func NewRegisteredSwapPending(chain, txid string, now time.Time) *MgoRegisteredSwapPending {
+	return &MgoRegisteredSwapPending{
 		Key:       txid,
+		JobID:     GetRegisterJobID(chain, txid),
 		Chain:     chain,
-		Status:    NewRegister,
+		Status:    StateSubmitted,
 		Timestamp: now.Unix(),
-		Time:      fmt.Sprintf(now.Format("2006-01-02 15:04:05")),
+		Time:      now.Format("2006-01-02 15:04:05"),
+		History:   newStateHistory(StateSubmitted, "", now),
 	}
-	err := collRegisteredSwapPending.Insert(ma)
+}
+
+// AddRegisteredSwapPending add register swap tx
+func AddRegisteredSwapPending(chain, txid string) error {
+	defer metrics.ObserveMongoOp("addSwapPending", time.Now())
+	ma := NewRegisteredSwapPending(chain, txid, time.Now())
+	err := insert(collRegisteredSwapPending, ma)
 	if err == nil {
 		log.Info("mongodb add register swap pending", "txid", ma.Key, "chain", chain)
 	} else {
 		log.Debug("mongodb add register swap pending", "txid", ma.Key, "chain", chain, "err", err)
 	}
-	return err
+	return mgoError(err)
+}
+
+// RegisterItem tx to register on chain
+type RegisterItem struct {
+	Chain string `json:"chain"`
+	TxID  string `json:"txid"`
+}
+
+// AddRegisteredSwapPendings add register swap txs in one bulk write,
+// return error of every item (nil if added, ErrItemIsDup if registered before).
+func AddRegisteredSwapPendings(items []*RegisterItem) ([]error, error) {
+	defer metrics.ObserveMongoOp("addSwapPendings", time.Now())
+	now := time.Now()
+	docs := make([]interface{}, len(items))
+	for i, item := range items {
+		docs[i] = NewRegisteredSwapPending(item.Chain, item.TxID, now)
+	}
+	errs, err := insertUnordered(collRegisteredSwapPending, docs)
+	if err != nil {
+		log.Warn("mongodb add register swap pendings failed", "count", len(items), "err", err)
+		return nil, mgoError(err)
+	}
+	added := 0
+	for i, item := range items {
+		if errs[i] == nil {
+			added++
+			continue
+		}
+		log.Debug("mongodb add register swap pending", "txid", item.TxID, "chain", item.Chain, "err", errs[i])
+		errs[i] = mgoError(errs[i])
+	}
+	log.Info("mongodb add register swap pendings", "count", len(items), "added", added)
+	return errs, nil
+}
+
+// GetRegisterJobID get job id of registering swap tx
+func GetRegisterJobID(chain, txid string) string {
+	hash := sha256.Sum256([]byte(strings.ToLower(chain + ":" + txid)))
+	return hex.EncodeToString(hash[:16])
+}
+
+// UpdateSwapPendingJobID set job id of swap pending registered before job id is introduced
+func UpdateSwapPendingJobID(txid, jobID string) error {
+	err := updateID(collRegisteredSwapPending, txid, bson.M{"$set": bson.M{"jobid": jobID}})
+	return mgoError(err)
+}
+
+// FindSwapPendingByJobID find swap pending by job id
+func FindSwapPendingByJobID(jobID string) (*MgoRegisteredSwapPending, error) {
+	defer metrics.ObserveMongoOp("findSwapPendingByJobID", time.Now())
+	var result MgoRegisteredSwapPending
+	err := find(collRegisteredSwapPending, bson.M{"jobid": strings.ToLower(jobID)}).One(&result)
+	if err != nil {
+		return nil, mgoError(err)
+	}
+	return &result, nil
 }
 
 // AddRegisteredSwap add register swap
 func AddRegisteredSwap(chain, method, pairid, txid, chainid, logIndex, swapServer string) error {
+	return AddRegisteredSwapItem(NewRegisteredSwap(chain, method, pairid, txid, chainid, logIndex, swapServer))
+}
+
+// NewRegisteredSwap new register swap with state Verified
+func NewRegisteredSwap(chain, method, pairid, txid, chainid, logIndex, swapServer string) *MgoRegisteredSwap {
 	now := time.Now()
 	i64, _ := strconv.ParseInt(logIndex, 10, 64)
 	c64, _ := strconv.ParseInt(chainid, 10, 64)
-	ma := &MgoRegisteredSwap{
-		Key:        txid,
+	return &MgoRegisteredSwap{
+		Key:        GetRegisteredSwapKey(txid, logIndex, swapServer),
+		TxID:       txid,
 		PairID:     pairid,
 		Method:     method,
 		LogIndex:   uint64(i64),
 		SwapServer: swapServer,
 		Chain:      chain,
 		ChainID:    uint64(c64),
-		Status:     NewRegister,
+		Status:     StateVerified,
 		Timestamp:  now.Unix(),
 		Time:       fmt.Sprintf(now.Format("2006-01-02 15:04:05")),
 	}
-	err := collRegisteredSwap.Insert(ma)
+}
+
+// AddRegisteredSwapItem add register swap
+func AddRegisteredSwapItem(ma *MgoRegisteredSwap) error {
+	defer metrics.ObserveMongoOp("addRegisteredSwap", time.Now())
+	if len(ma.History) == 0 {
+		ma.History = newStateHistory(ma.Status, "", time.Now())
+	}
+	err := insert(collRegisteredSwap, ma)
 	if err == nil {
-		log.Info("mongodb add register swap success", "txid", ma.Key, "chain", chain)
+		log.Info("mongodb add register swap success", "key", ma.Key, "chain", ma.Chain, "status", ma.Status)
 	} else {
-		log.Info("mongodb add register swap failed", "txid", ma.Key, "chain", chain, "err", err)
+		log.Info("mongodb add register swap failed", "key", ma.Key, "chain", ma.Chain, "err", err)
 	}
 	return mgoError(err)
 }
 
-// UpdateRegisteredSwapStatus update register swap status
-func UpdateRegisteredSwapStatusSuccess(txid string) error {
-	status := "success"
-	return UpdateRegisteredSwapStatus(txid, status)
+// FindRegisteredSwapToRetry find registered swaps whose post retry time is due
+func FindRegisteredSwapToRetry(limit int) ([]*MgoRegisteredSwap, error) {
+	defer metrics.ObserveMongoOp("findRegisteredSwapToRetry", time.Now())
+	result := make([]*MgoRegisteredSwap, 0, limit)
+	qstatus := bson.M{"status": StatePosting}
+	qtime := bson.M{"nextattempt": bson.M{"$lte": time.Now().Unix()}}
+	q := find(collRegisteredSwap, bson.M{"$and": []bson.M{qstatus, qtime, notLeasedQuery()}}).Sort("nextattempt").Limit(limit)
+	err := q.All(&result)
+	if err != nil {
+		return nil, mgoError(err)
+	}
+	return result, nil
 }
 
-func UpdateRegisteredSwapStatusFailed(txid string) error {
-	status := SwapError
-	return UpdateRegisteredSwapStatus(txid, status)
+// FindRegisteredSwapWithPostResult find registered swaps with post result
+func FindRegisteredSwapWithPostResult(chain, postResult string, offset, limit int) ([]*MgoRegisteredSwap, error) {
+	defer metrics.ObserveMongoOp("findRegisteredSwapWithPostResult", time.Now())
+	result := make([]*MgoRegisteredSwap, 0, 20)
+	queries := []bson.M{{"postresult": postResult}}
+	if chain != "" {
+		queries = append(queries, bson.M{"chain": chain})
+	}
+	q := find(collRegisteredSwap, bson.M{"$and": queries})
+	if limit >= 0 {
+		q = q.Sort("timestamp").Skip(offset).Limit(limit)
+	} else {
+		q = q.Sort("-timestamp").Skip(offset).Limit(-limit)
+	}
+	err := q.All(&result)
+	if err != nil {
+		return nil, mgoError(err)
+	}
+	return result, nil
 }
 
-func UpdateRegisteredSwapStatus(txid string, status string) error {
-	now := time.Now()
-	Time := fmt.Sprintf(now.Format("2006-01-02 15:04:05"))
-	selector := bson.M{"_id": txid}
-	data := bson.M{"$set": bson.M{"status": status, "time": Time}}
-	err := collRegisteredSwap.Update(selector, data)
-	return err
+// FindRegisteredSwapByAddress find registered router swaps whose event sender or recipient is address
+func FindRegisteredSwapByAddress(address string, offset, limit int) ([]*MgoRegisteredSwap, error) {
+	defer metrics.ObserveMongoOp("findRegisteredSwapByAddress", time.Now())
+	result := make([]*MgoRegisteredSwap, 0, 20)
+	address = strings.ToLower(address)
+	q := find(collRegisteredSwap, bson.M{"$or": []bson.M{{"event.from": address}, {"event.to": address}}})
+	if limit >= 0 {
+		q = q.Sort("timestamp").Skip(offset).Limit(limit)
+	} else {
+		q = q.Sort("-timestamp").Skip(offset).Limit(-limit)
+	}
+	err := q.All(&result)
+	if err != nil {
+		return nil, mgoError(err)
+	}
+	return result, nil
 }
 
-// UpdateSwapPending update register swap status
-func UpdateSwapPendingSuccess(txid string) error {
-	status := SwapSuccess
-	return UpdateSwapPendingStatus(txid, status)
+// UpdateRegisteredSwapPosting update state of register swap to Posting,
+// the swap is picked up by post retry job if not finished until nextAttempt.
+func UpdateRegisteredSwapPosting(key string, nextAttempt int64) error {
+	return updateRegisteredSwapState(key, StatePosting, "", bson.M{"nextattempt": nextAttempt})
 }
 
-// UpdateSwapPendingStatus update register swap status
-func UpdateSwapPendingStatus(txid string, status string) error {
-	selector := bson.M{"_id": txid}
-	data := bson.M{"$set": bson.M{"status": status}}
-	err := collRegisteredSwapPending.Update(selector, data)
-	return err
+// UpdateRegisteredSwapPostResult update state, post result and swap server response of register swap
+func UpdateRegisteredSwapPostResult(key string, state SwapState, postResult string, errCode int, response string) error {
+	lastError := response
+	if state == StatePosted {
+		lastError = ""
+	}
+	updates := bson.M{
+		"postresult":    postResult,
+		"posterrorcode": errCode,
+		"lasterror":     lastError,
+		"lastresponse":  response,
+	}
+	return updateRegisteredSwapState(key, state, lastError, updates)
 }
 
-// UpdateSwapPending update register swap status
-func UpdateSwapPendingFailed(txid string) error {
-	status := SwapError
-	return UpdateSwapPendingStatus(txid, status)
+// UpdateRegisteredSwapRetry update post retry info and state of register swap
+func UpdateRegisteredSwapRetry(key string, attempts int, lastError string, nextAttempt int64, state SwapState) error {
+	updates := bson.M{
+		"attempts":     attempts,
+		"lasterror":    lastError,
+		"nextattempt":  nextAttempt,
+		"postresult":   PostResultTransient,
+		"lastresponse": lastError,
+	}
+	return updateRegisteredSwapState(key, state, lastError, updates)
 }
 
-// UpdateSwapPending update register swap status
-func UpdateSwapPendingNotFound(txid string) error {
-	status := SwapNotFound
-	return UpdateSwapPendingStatus(txid, status)
+// UpdateRegisteredSwapBlock update block info and state of register swap
+func UpdateRegisteredSwapBlock(key string, blockHeight uint64, blockHash string, state SwapState) error {
+	updates := bson.M{
+		"blockheight":   blockHeight,
+		"blockhash":     blockHash,
+		"missingchecks": 0,
+		"missingsince":  int64(0),
+	}
+	return updateRegisteredSwapState(key, state, "block "+blockHash, updates)
+}
+
+// UpdateRegisteredSwapTxMissing clear block info of register swap whose tx is not found on chain,
+// and wait confirmations again in case the tx is packed in another block.
+func UpdateRegisteredSwapTxMissing(key string, missingChecks int, missingSince int64, message string) error {
+	updates := bson.M{
+		"blockheight":   uint64(0),
+		"blockhash":     "",
+		"missingchecks": missingChecks,
+		"missingsince":  missingSince,
+	}
+	return updateRegisteredSwapState(key, StateVerifying, message, updates)
 }
 
-// RemoveRegisteredSwap remove register swap
+// RemoveRegisteredSwap remove all register swaps of txid, removed swaps are kept in swapDeleted
 func RemoveRegisteredSwap(txid string) error {
-	err := collRegisteredSwap.Remove(bson.M{"_id": txid})
+	var swaps []*MgoRegisteredSwap
+	err := find(collRegisteredSwap, getRegisteredSwapTxidQuery(txid)).All(&swaps)
+	if err == nil {
+		now := time.Now()
+		for _, swap := range swaps {
+			MarkSwapDeleted(swap, now)
+			if err = replaceID(collSwapDelete, swap.Key, swap); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		_, err = removeAll(collRegisteredSwap, getRegisteredSwapTxidQuery(txid))
+	}
 	if err == nil {
 		log.Info("mongodb remove register swap", "txid", txid)
 	} else {
@@ -903,17 +1166,22 @@ func RemoveRegisteredSwap(txid string) error {
 
 // AddSwapPost add swap post success
 func AddSwapPost(post *MgoRegisteredSwap) error {
+	defer metrics.ObserveMongoOp("addSwapPost", time.Now())
 	now := time.Now()
 	ma := &MgoRegisteredSwap{
 		Key:        post.Key,
+		TxID:       post.TxID,
 		PairID:     post.PairID,
 		Method:     post.Method,
+		LogIndex:   post.LogIndex,
 		SwapServer: post.SwapServer,
-		Status:     SwapSuccess,
+		Chain:      post.Chain,
+		ChainID:    post.ChainID,
+		Status:     StatePosted,
 		Timestamp:  now.Unix(),
 		Time:       fmt.Sprintf(now.Format("2006-01-02 15:04:05")),
 	}
-	err := collSwapPost.Insert(ma)
+	err := insert(collSwapPost, ma)
 	if err == nil {
 		log.Info("mongodb add swap post", "txid", ma.Key)
 	} else {
@@ -930,7 +1198,7 @@ func AddRegisteredAddress(address string) error {
 		Key:       address,
 		Timestamp: time.Now().Unix(),
 	}
-	err := collRegisteredAddress.Insert(ma)
+	err := insert(collRegisteredAddress, ma)
 	if err == nil {
 		log.Info("mongodb add register address", "key", ma.Key)
 	} else {
@@ -942,7 +1210,7 @@ func AddRegisteredAddress(address string) error {
 // FindRegisteredAddress find register address
 func FindRegisteredAddress(key string) (*MgoRegisteredAddress, error) {
 	var result MgoRegisteredAddress
-	err := collRegisteredAddress.FindId(key).One(&result)
+	err := findID(collRegisteredAddress, key).One(&result)
 	if err != nil {
 		return nil, mgoError(err)
 	}
@@ -980,7 +1248,7 @@ func UpdateLatestSwapNonce(address string, isSwapin bool, nonce uint64) (err err
 			SwapNonce: nonce,
 			Timestamp: time.Now().Unix(),
 		}
-		err = collLatestSwapNonces.Insert(ma)
+		err = insert(collLatestSwapNonces, ma)
 	} else {
 		updates := bson.M{
 			"address":   strings.ToLower(address),
@@ -988,7 +1256,7 @@ func UpdateLatestSwapNonce(address string, isSwapin bool, nonce uint64) (err err
 			"swapnonce": nonce,
 			"timestamp": time.Now().Unix(),
 		}
-		err = collLatestSwapNonces.UpdateId(key, bson.M{"$set": updates})
+		err = updateID(collLatestSwapNonces, key, bson.M{"$set": updates})
 	}
 	if err == nil {
 		log.Info("mongodb update swap nonce success", "address", address, "nonce", nonce, "isSwapin", isSwapin)
@@ -1001,7 +1269,7 @@ func UpdateLatestSwapNonce(address string, isSwapin bool, nonce uint64) (err err
 // FindLatestSwapNonce find
 func FindLatestSwapNonce(key string) (*MgoLatestSwapNonce, error) {
 	var result MgoLatestSwapNonce
-	err := collLatestSwapNonces.FindId(key).One(&result)
+	err := findID(collLatestSwapNonces, key).One(&result)
 	if err != nil {
 		return nil, mgoError(err)
 	}
@@ -1012,9 +1280,9 @@ func FindLatestSwapNonce(key string) (*MgoLatestSwapNonce, error) {
 func LoadAllSwapNonces() (swapinNonces, swapoutNonces map[string]uint64) {
 	swapinNonces = make(map[string]uint64)
 	swapoutNonces = make(map[string]uint64)
-	var result MgoLatestSwapNonce
-	iter := collLatestSwapNonces.Find(nil).Iter()
-	for iter.Next(&result) {
+	var nonces []*MgoLatestSwapNonce
+	_ = find(collLatestSwapNonces, nil).All(&nonces)
+	for _, result := range nonces {
 		address := result.Address
 		if address == "" {
 			continue
@@ -1034,13 +1302,13 @@ func LoadAllSwapNonces() (swapinNonces, swapoutNonces map[string]uint64) {
 // AddSwapHistory add
 func AddSwapHistory(isSwapin bool, txid, bind, swaptx string) error {
 	item := &MgoSwapHistory{
-		Key:      bson.NewObjectId(),
+		Key:      primitive.NewObjectID(),
 		IsSwapin: isSwapin,
 		TxID:     txid,
 		Bind:     bind,
 		SwapTx:   swaptx,
 	}
-	err := collSwapHistory.Insert(item)
+	err := insert(collSwapHistory, item)
 	if err == nil {
 		log.Info("mongodb add swap history success", "txid", txid, "bind", bind, "isSwapin", isSwapin)
 	} else {
@@ -1056,7 +1324,7 @@ func GetSwapHistory(isSwapin bool, txid, bind string) ([]*MgoSwapHistory, error)
 	qisswapin := bson.M{"isswapin": isSwapin}
 	queries := []bson.M{qtxid, qbind, qisswapin}
 	result := make([]*MgoSwapHistory, 0, 20)
-	err := collSwapHistory.Find(bson.M{"$and": queries}).All(&result)
+	err := find(collSwapHistory, bson.M{"$and": queries}).All(&result)
 	return result, mgoError(err)
 }
 
@@ -1069,25 +1337,83 @@ func AddUsedRValue(pubkey, r string) error {
 		Key:       key,
 		Timestamp: common.NowMilli(),
 	}
-	err := collUsedRValue.Insert(mr)
+	err := insert(collUsedRValue, mr)
 	switch {
 	case err == nil:
 		log.Info("mongodb add used r success", "pubkey", pubkey, "r", r)
 		return nil
-	case mgo.IsDup(err):
+	case mongo.IsDuplicateKeyError(err):
 		log.Warn("mongodb add used r failed", "pubkey", pubkey, "r", r, "err", err)
 		return ErrItemIsDup
 	default:
 		old := &MgoUsedRValue{}
-		if collUsedRValue.FindId(key).One(old) == nil {
+		if findID(collUsedRValue, key).One(old) == nil {
 			log.Warn("mongodb add used r failed", "pubkey", pubkey, "r", r, "err", ErrItemIsDup)
 			return ErrItemIsDup
 		}
 
-		err = collUsedRValue.Insert(mr) // retry once
+		err = insert(collUsedRValue, mr) // retry once
 		if err != nil {
 			log.Warn("mongodb add used r failed in retry", "pubkey", pubkey, "r", r, "err", err)
 		}
 		return mgoError(err)
 	}
 }
+
+// ------------------------ webhook events ------------------------------
+
+// webhook event delivery status
+const (
+	WebhookEventPending   = "pending"
+	WebhookEventDelivered = "delivered"
+	WebhookEventFailed    = "failed"
+)
+
+// AddWebhookEvent add webhook event to deliver, duplicate event is ignored
+func AddWebhookEvent(ev *MgoWebhookEvent) error {
+	defer metrics.ObserveMongoOp("addWebhookEvent", time.Now())
+	err := insert(collWebhookEvent, ev)
+	switch {
+	case err == nil:
+		log.Info("mongodb add webhook event", "key", ev.Key)
+		return nil
+	case mongo.IsDuplicateKeyError(err):
+		return nil
+	default:
+		log.Warn("mongodb add webhook event failed", "key", ev.Key, "err", err)
+		return mgoError(err)
+	}
+}
+
+// FindWebhookEventsToDeliver find pending webhook events whose delivery time is due
+func FindWebhookEventsToDeliver(limit int) ([]*MgoWebhookEvent, error) {
+	defer metrics.ObserveMongoOp("findWebhookEventsToDeliver", time.Now())
+	result := make([]*MgoWebhookEvent, 0, limit)
+	qstatus := bson.M{"status": WebhookEventPending}
+	qtime := bson.M{"nextattempt": bson.M{"$lte": time.Now().Unix()}}
+	q := find(collWebhookEvent, bson.M{"$and": []bson.M{qstatus, qtime, notLeasedQuery()}}).Sort("nextattempt").Limit(limit)
+	err := q.All(&result)
+	if err != nil {
+		return nil, mgoError(err)
+	}
+	return result, nil
+}
+
+// UpdateWebhookEventDelivery update delivery status of webhook event
+func UpdateWebhookEventDelivery(key, status string, attempts int, lastError string, nextAttempt int64) error {
+	defer metrics.ObserveMongoOp("updateWebhookEventDelivery", time.Now())
+	updates := bson.M{
+		"status":      status,
+		"attempts":    attempts,
+		"lasterror":   lastError,
+		"nextattempt": nextAttempt,
+	}
+	if status != WebhookEventPending && webhookEventTTL > 0 {
+		updates["expireat"] = time.Now().Add(webhookEventTTL)
+	}
+	err := updateID(collWebhookEvent, key, bson.M{"$set": updates})
+	if err != nil {
+		log.Warn("mongodb update webhook event failed", "key", key, "updates", updates, "err", err)
+	}
+	return mgoError(err)
+}
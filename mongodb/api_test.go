@@ -0,0 +1,288 @@
+package mongodb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// tests using database are skipped if MONGODB_TEST_URI is not set,
+// eg. MONGODB_TEST_URI=mongodb://127.0.0.1:27017 go test ./mongodb
+// or 'make test-mongodb' to run them with a mongodb docker container.
+// they fail instead in CI, where the test job starts mongodb and sets it.
+const testDBURIEnv = "MONGODB_TEST_URI"
+
+func setupTestDB(t *testing.T) {
+	uri := os.Getenv(testDBURIEnv)
+	if uri == "" {
+		if os.Getenv("CI") != "" {
+			t.Fatalf("%v is not set in CI", testDBURIEnv)
+		}
+		t.Skipf("%v is not set", testDBURIEnv)
+	}
+	dbname := fmt.Sprintf("scanserver_test_%v", time.Now().UnixNano())
+	opts := getClientOptions([]string{uri}, dbname, "", "")
+	opts.SetServerSelectionTimeout(5 * time.Second)
+	c, err := connect(opts)
+	if err != nil {
+		t.Fatalf("connect %v failed: %v", uri, err)
+	}
+	client = c
+	database = client.Database(dbname)
+	initCollections()
+	t.Cleanup(func() {
+		ctx, cancel := newContext()
+		defer cancel()
+		_ = database.Drop(ctx)
+		_ = client.Disconnect(ctx)
+		client = nil
+	})
+}
+
+func TestGetIndexKeys(t *testing.T) {
+	keys := getIndexKeys("status", "-timestamp")
+	want := bson.D{{Key: "status", Value: 1}, {Key: "timestamp", Value: -1}}
+	if fmt.Sprint(keys) != fmt.Sprint(want) {
+		t.Errorf("index keys mismatch, have %v, want %v", keys, want)
+	}
+}
+
+func TestRegisteredSwapLifecycle(t *testing.T) {
+	setupTestDB(t)
+
+	swap := NewRegisteredSwap("eth", "swap.RegisterRouterSwap", "", "0x1234", "56", "3", "http://127.0.0.1:11556/rpc")
+	if err := AddRegisteredSwapItem(swap); err != nil {
+		t.Fatalf("add registered swap failed: %v", err)
+	}
+	if err := AddRegisteredSwapItem(swap); !errors.Is(err, ErrItemIsDup) {
+		t.Errorf("add duplicate registered swap, have error %v, want %v", err, ErrItemIsDup)
+	}
+	if _, err := FindRegisterdSwapTxid("0x5678"); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("find not exist registered swap, have error %v, want %v", err, ErrItemNotFound)
+	}
+
+	if err := UpdateRegisteredSwapPosting(swap.Key, time.Now().Unix()); err != nil {
+		t.Fatalf("update registered swap to posting failed: %v", err)
+	}
+	if err := UpdateRegisteredSwapState(swap.Key, StateVerified, ""); !errors.Is(err, ErrStateTransition) {
+		t.Errorf("update registered swap from posting to verified, have error %v, want %v", err, ErrStateTransition)
+	}
+	if err := UpdateRegisteredSwapPostResult(swap.Key, StatePosted, PostResultSuccess, 0, "ok"); err != nil {
+		t.Fatalf("update registered swap post result failed: %v", err)
+	}
+
+	swaps, err := FindRegisterdSwapTxid("0x1234")
+	if err != nil || len(swaps) != 1 {
+		t.Fatalf("find registered swap failed: %v", err)
+	}
+	if swaps[0].Status != StatePosted || swaps[0].PostResult != PostResultSuccess || swaps[0].ChainID != 56 || swaps[0].LogIndex != 3 {
+		t.Errorf("registered swap mismatch: %+v", swaps[0])
+	}
+	if len(swaps[0].History) != 3 {
+		t.Errorf("registered swap history length mismatch, have %v, want 3", len(swaps[0].History))
+	}
+}
+
+func TestFindRegisteredSwapByCursor(t *testing.T) {
+	setupTestDB(t)
+
+	const count = 5
+	for i := 0; i < count; i++ {
+		swap := NewRegisteredSwap("eth", "swap.Swapin", "usdt", fmt.Sprintf("0x%02d", i), "", "0", "http://127.0.0.1:11557/rpc")
+		swap.Timestamp = 1000 + int64(i/2) // records with the same timestamp are ordered by key
+		if err := AddRegisteredSwapItem(swap); err != nil {
+			t.Fatalf("add registered swap failed: %v", err)
+		}
+	}
+
+	var cursor *SwapCursor
+	var found []string
+	for {
+		swaps, err := FindRegisterdSwap("eth", cursor, 2)
+		if err != nil {
+			t.Fatalf("find registered swap failed: %v", err)
+		}
+		for _, swap := range swaps {
+			found = append(found, swap.TxID)
+			// records leaving the status do not shift the position of cursor
+			_ = UpdateRegisteredSwapPosting(swap.Key, 0)
+		}
+		if len(swaps) < 2 {
+			break
+		}
+		cursor = swaps[len(swaps)-1].Cursor()
+	}
+	if len(found) != count {
+		t.Fatalf("find registered swaps by cursor, have %v, want %v records", found, count)
+	}
+	for i, txid := range found {
+		if want := fmt.Sprintf("0x%02d", i); txid != want {
+			t.Errorf("registered swap %v mismatch, have %v, want %v", i, txid, want)
+		}
+	}
+}
+
+func TestLeaseRegisteredSwap(t *testing.T) {
+	setupTestDB(t)
+
+	swap := NewRegisteredSwap("eth", "swap.Swapin", "usdt", "0xabcd", "", "0", "http://127.0.0.1:11557/rpc")
+	if err := AddRegisteredSwapItem(swap); err != nil {
+		t.Fatalf("add registered swap failed: %v", err)
+	}
+	if _, err := LeaseRegisteredSwap("0xnotexist", StateVerified, time.Minute); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("lease not exist swap, have error %v, want %v", err, ErrItemNotFound)
+	}
+
+	oldID := GetInstanceID()
+	defer SetInstanceID(oldID)
+
+	SetInstanceID("instance-1")
+	if _, err := LeaseRegisteredSwap(swap.Key, StateVerified, time.Minute); err != nil {
+		t.Fatalf("lease swap failed: %v", err)
+	}
+	SetInstanceID("instance-2")
+	if _, err := LeaseRegisteredSwap(swap.Key, StateVerified, time.Minute); !errors.Is(err, ErrItemIsLeased) {
+		t.Errorf("lease swap leased by others, have error %v, want %v", err, ErrItemIsLeased)
+	}
+	if swaps, _ := FindRegisterdSwap("", nil, 10); len(swaps) != 0 {
+		t.Errorf("find swap leased by others, have %v records, want none", len(swaps))
+	}
+	SetInstanceID("instance-1")
+	if err := ReleaseRegisteredSwap(swap.Key); err != nil {
+		t.Fatalf("release swap failed: %v", err)
+	}
+	SetInstanceID("instance-2")
+	if _, err := LeaseRegisteredSwap(swap.Key, StateVerified, time.Minute); err != nil {
+		t.Errorf("lease released swap failed: %v", err)
+	}
+
+	// posted by instance-2, the stale copy of instance-1 is not leased
+	if err := UpdateRegisteredSwapPosting(swap.Key, time.Now().Unix()+60); err != nil {
+		t.Fatalf("update swap posting failed: %v", err)
+	}
+	if err := ReleaseRegisteredSwap(swap.Key); err != nil {
+		t.Fatalf("release swap failed: %v", err)
+	}
+	SetInstanceID("instance-1")
+	if _, err := LeaseRegisteredSwap(swap.Key, StateVerified, time.Minute); !errors.Is(err, ErrItemIsChanged) {
+		t.Errorf("lease swap in stale status, have error %v, want %v", err, ErrItemIsChanged)
+	}
+	if _, err := LeaseRegisteredSwap(swap.Key, StatePosting, time.Minute); !errors.Is(err, ErrItemIsChanged) {
+		t.Errorf("lease swap before next attempt, have error %v, want %v", err, ErrItemIsChanged)
+	}
+	if err := UpdateRegisteredSwapRetry(swap.Key, 1, "timeout", time.Now().Unix(), StatePosting); err != nil {
+		t.Fatalf("update swap retry failed: %v", err)
+	}
+	leased, err := LeaseRegisteredSwap(swap.Key, StatePosting, time.Minute)
+	if err != nil {
+		t.Fatalf("lease swap to retry failed: %v", err)
+	}
+	if leased.Status != StatePosting || leased.Attempts != 1 || leased.LockedBy != "instance-1" {
+		t.Errorf("leased swap mismatch, have status %v attempts %v lockedby %v", leased.Status, leased.Attempts, leased.LockedBy)
+	}
+}
+
+func TestLeaseChainScan(t *testing.T) {
+	setupTestDB(t)
+
+	oldID := GetInstanceID()
+	defer SetInstanceID(oldID)
+
+	SetInstanceID("instance-1")
+	if err := LeaseChainScan("eth", time.Minute); err != nil {
+		t.Fatalf("lease chain scan failed: %v", err)
+	}
+	if err := UpdateChainScanInfo("eth", 100); err != nil {
+		t.Fatalf("update chain scan info failed: %v", err)
+	}
+	SetInstanceID("instance-2")
+	if err := LeaseChainScan("eth", time.Minute); !errors.Is(err, ErrItemIsLeased) {
+		t.Errorf("lease chain scan leased by others, have error %v, want %v", err, ErrItemIsLeased)
+	}
+	if err := UpdateChainScanInfo("eth", 200); !errors.Is(err, ErrItemIsLeased) {
+		t.Errorf("update chain scan info leased by others, have error %v, want %v", err, ErrItemIsLeased)
+	}
+	SetInstanceID("instance-1")
+	if err := ReleaseChainScan("eth"); err != nil {
+		t.Fatalf("release chain scan failed: %v", err)
+	}
+	SetInstanceID("instance-2")
+	if err := LeaseChainScan("eth", time.Minute); err != nil {
+		t.Errorf("lease released chain scan failed: %v", err)
+	}
+	info, err := FindChainScanInfo("eth")
+	if err != nil || info.BlockHeight != 100 || info.LockedBy != "instance-2" {
+		t.Errorf("chain scan info mismatch, have %+v, err %v", info, err)
+	}
+}
+
+func TestParseArchiveFilter(t *testing.T) {
+	tests := []struct {
+		arg     string
+		want    ArchiveFilter
+		wantErr bool
+	}{
+		{arg: "0x1234", want: ArchiveFilter{TxID: "0x1234"}},
+		{arg: "1000,2000", want: ArchiveFilter{From: 1000, To: 2000}},
+		{arg: "2022-01-01,", want: ArchiveFilter{From: 1640995200}},
+		{arg: "2022-01-01,2022-01-02", want: ArchiveFilter{From: 1640995200, To: 1641081600}},
+		{arg: "", wantErr: true},
+		{arg: "2000,1000", wantErr: true},
+		{arg: "yesterday,", wantErr: true},
+	}
+	for _, test := range tests {
+		filter, err := ParseArchiveFilter(test.arg)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parse archive filter '%v', have no error", test.arg)
+			}
+			continue
+		}
+		if err != nil || *filter != test.want {
+			t.Errorf("parse archive filter '%v' mismatch, have %+v (%v), want %+v", test.arg, filter, err, test.want)
+		}
+	}
+}
+
+func TestArchiveSwaps(t *testing.T) {
+	setupTestDB(t)
+
+	swap := NewRegisteredSwap("eth", "swap.Swapin", "usdt", "0xabcd", "", "0", "http://127.0.0.1:11557/rpc")
+	swap.Status = StatePosted
+	if err := AddRegisteredSwapItem(swap); err != nil {
+		t.Fatalf("add registered swap failed: %v", err)
+	}
+	before := time.Now().Unix() + 1
+	swaps, err := FindSwapsToArchive(before, 10)
+	if err != nil || len(swaps) != 1 {
+		t.Fatalf("find swaps to archive, have %v records, want 1, err %v", len(swaps), err)
+	}
+	if err = AddArchivedSwaps(swaps); err != nil {
+		t.Fatalf("add archived swaps failed: %v", err)
+	}
+	if err = RemoveArchivedSources(swaps); err != nil {
+		t.Fatalf("remove archived sources failed: %v", err)
+	}
+	if _, err = FindRegisterdSwapTxid("0xabcd"); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("find archived registered swap, have error %v, want %v", err, ErrItemNotFound)
+	}
+
+	filter := &ArchiveFilter{TxID: "0xabcd"}
+	archived, err := FindArchivedSwaps(filter, 0)
+	if err != nil || len(archived) != 1 {
+		t.Fatalf("find archived swaps, have %v records, want 1, err %v", len(archived), err)
+	}
+	if err = RestoreArchivedSwaps(archived); err != nil {
+		t.Fatalf("restore archived swaps failed: %v", err)
+	}
+	if restored, err := FindRegisterdSwapTxid("0xabcd"); err != nil || restored[0].Status != StatePosted {
+		t.Errorf("find restored registered swap failed: %v", err)
+	}
+	if count, _ := PurgeArchivedSwaps(filter); count != 0 {
+		t.Errorf("purge restored archived swaps, have %v records, want none", count)
+	}
+}
@@ -0,0 +1,318 @@
+package mongodb
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/weijun-sh/gethscan-server/log"
+	"github.com/weijun-sh/gethscan-server/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// -----------------------------------------------
+// retention of finished swap records
+//
+// finished records (swap pending verified or failed, registered swaps
+// in final states, posted and deleted swaps) registered more than
+// retention days ago are moved to the archive table 'swapArchive'
+// (or to export files), and can be restored or purged by txid or time range.
+// delivered and failed webhook events are removed by ttl index.
+// -----------------------------------------------
+
+// archived record kinds, the table records are moved from
+const (
+	ArchiveKindPending    = "pending"
+	ArchiveKindRegistered = "registered"
+	ArchiveKindPost       = "posted"
+	ArchiveKindDeleted    = "deleted"
+)
+
+// ArchiveKinds all archived record kinds
+var ArchiveKinds = []string{ArchiveKindPending, ArchiveKindRegistered, ArchiveKindPost, ArchiveKindDeleted}
+
+var webhookEventTTL time.Duration
+
+// SetWebhookEventTTL set how long delivered and failed webhook events are kept, 0 keeps them
+func SetWebhookEventTTL(ttl time.Duration) {
+	webhookEventTTL = ttl
+}
+
+// IsSwapFinished is record of kind finished (no more processing)
+func IsSwapFinished(kind string, state SwapState) bool {
+	switch kind {
+	case ArchiveKindPending:
+		return state == StateVerified || state == StateFailed
+	case ArchiveKindRegistered:
+		return state.IsFinal()
+	default:
+		return true
+	}
+}
+
+func getFinishedStates(kind string) []SwapState {
+	var states []SwapState
+	for _, state := range AllSwapStates {
+		if IsSwapFinished(kind, state) {
+			states = append(states, state)
+		}
+	}
+	return states
+}
+
+// MarkSwapDeleted set deleting time and history of registered swap removed
+func MarkSwapDeleted(swap *MgoRegisteredSwap, now time.Time) {
+	swap.Timestamp = now.Unix()
+	swap.Time = now.Format("2006-01-02 15:04:05")
+	swap.History = append(swap.History, &MgoStateHistory{State: swap.Status, Message: "deleted", Timestamp: now.Unix()})
+	swap.LockedBy = ""
+	swap.LockedUntil = 0
+}
+
+func getArchiveKey(kind, key string) string {
+	return kind + ":" + key
+}
+
+// NewArchivedPending new archived record of swap pending
+func NewArchivedPending(pending *MgoRegisteredSwapPending, now time.Time) *MgoArchivedSwap {
+	return &MgoArchivedSwap{
+		Key:        getArchiveKey(ArchiveKindPending, pending.Key),
+		Kind:       ArchiveKindPending,
+		TxID:       pending.Key,
+		Timestamp:  pending.Timestamp,
+		ArchivedAt: now.Unix(),
+		Pending:    pending,
+	}
+}
+
+// NewArchivedSwap new archived record of registered, posted or deleted swap
+func NewArchivedSwap(kind string, swap *MgoRegisteredSwap, now time.Time) *MgoArchivedSwap {
+	txid := swap.TxID
+	if txid == "" {
+		txid = swap.Key // old records are keyed by txid
+	}
+	return &MgoArchivedSwap{
+		Key:        getArchiveKey(kind, swap.Key),
+		Kind:       kind,
+		TxID:       txid,
+		Timestamp:  swap.Timestamp,
+		ArchivedAt: now.Unix(),
+		Swap:       swap,
+	}
+}
+
+// GetRecordKey get key of archived record in its table
+func (a *MgoArchivedSwap) GetRecordKey() string {
+	if a.Pending != nil {
+		return a.Pending.Key
+	}
+	if a.Swap != nil {
+		return a.Swap.Key
+	}
+	return ""
+}
+
+// GetRecord get archived record
+func (a *MgoArchivedSwap) GetRecord() interface{} {
+	if a.Pending != nil {
+		return a.Pending
+	}
+	return a.Swap
+}
+
+// ArchiveFilter select archived records by txid, or by timestamp in range [From, To)
+type ArchiveFilter struct {
+	TxID string
+	From int64 // unix seconds
+	To   int64 // unix seconds, 0 means no end
+}
+
+// ParseArchiveFilter parse txid or time range 'from,to',
+// time is unix seconds or date like '2006-01-02', empty 'to' means no end.
+func ParseArchiveFilter(arg string) (*ArchiveFilter, error) {
+	if !strings.Contains(arg, ",") {
+		if arg == "" {
+			return nil, errors.New("empty txid")
+		}
+		return &ArchiveFilter{TxID: arg}, nil
+	}
+	parts := strings.SplitN(arg, ",", 2)
+	from, err := parseArchiveTime(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	var to int64
+	if parts[1] != "" {
+		if to, err = parseArchiveTime(parts[1]); err != nil {
+			return nil, err
+		}
+		if to <= from {
+			return nil, fmt.Errorf("wrong time range '%v'", arg)
+		}
+	}
+	return &ArchiveFilter{From: from, To: to}, nil
+}
+
+func parseArchiveTime(s string) (int64, error) {
+	if timestamp, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return timestamp, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.UTC)
+	if err != nil {
+		return 0, fmt.Errorf("wrong time '%v', should be unix seconds or date like '2006-01-02'", s)
+	}
+	return t.Unix(), nil
+}
+
+// IsMatch is archived record matching filter
+func (f *ArchiveFilter) IsMatch(a *MgoArchivedSwap) bool {
+	if f.TxID != "" {
+		return a.TxID == f.TxID
+	}
+	return a.Timestamp >= f.From && (f.To == 0 || a.Timestamp < f.To)
+}
+
+func (f *ArchiveFilter) query() bson.M {
+	if f.TxID != "" {
+		return bson.M{"txid": f.TxID}
+	}
+	qtime := bson.M{"$gte": f.From}
+	if f.To != 0 {
+		qtime["$lt"] = f.To
+	}
+	return bson.M{"timestamp": qtime}
+}
+
+func getArchiveSourceCollection(kind string) *mongo.Collection {
+	switch kind {
+	case ArchiveKindPending:
+		return collRegisteredSwapPending
+	case ArchiveKindRegistered:
+		return collRegisteredSwap
+	case ArchiveKindPost:
+		return collSwapPost
+	case ArchiveKindDeleted:
+		return collSwapDelete
+	default:
+		return nil
+	}
+}
+
+func getArchivableQuery(kind string, before int64) bson.M {
+	queries := []bson.M{{"timestamp": bson.M{"$lt": before}}}
+	switch kind {
+	case ArchiveKindPending, ArchiveKindRegistered:
+		queries = append(queries, bson.M{"status": bson.M{"$in": getFinishedStates(kind)}}, notLeasedQuery())
+	}
+	return bson.M{"$and": queries}
+}
+
+// FindSwapsToArchive find finished records of all tables registered before 'before' (unix seconds),
+// at most limit records in total.
+func FindSwapsToArchive(before int64, limit int) ([]*MgoArchivedSwap, error) {
+	defer metrics.ObserveMongoOp("findSwapsToArchive", time.Now())
+	now := time.Now()
+	result := make([]*MgoArchivedSwap, 0, limit)
+
+	var pendings []*MgoRegisteredSwapPending
+	err := find(collRegisteredSwapPending, getArchivableQuery(ArchiveKindPending, before)).Limit(limit).All(&pendings)
+	if err != nil {
+		return nil, mgoError(err)
+	}
+	for _, pending := range pendings {
+		result = append(result, NewArchivedPending(pending, now))
+	}
+
+	for _, kind := range []string{ArchiveKindRegistered, ArchiveKindPost, ArchiveKindDeleted} {
+		remain := limit - len(result)
+		if remain <= 0 {
+			break
+		}
+		var swaps []*MgoRegisteredSwap
+		err = find(getArchiveSourceCollection(kind), getArchivableQuery(kind, before)).Limit(remain).All(&swaps)
+		if err != nil {
+			return nil, mgoError(err)
+		}
+		for _, swap := range swaps {
+			result = append(result, NewArchivedSwap(kind, swap, now))
+		}
+	}
+	return result, nil
+}
+
+// AddArchivedSwaps add records to archive table, existing records are replaced
+func AddArchivedSwaps(swaps []*MgoArchivedSwap) error {
+	defer metrics.ObserveMongoOp("addArchivedSwaps", time.Now())
+	for _, a := range swaps {
+		if err := replaceID(collSwapArchive, a.Key, a); err != nil {
+			log.Warn("mongodb add archived swap failed", "key", a.Key, "err", err)
+			return mgoError(err)
+		}
+	}
+	return nil
+}
+
+// RemoveArchivedSources remove archived records from their tables
+func RemoveArchivedSources(swaps []*MgoArchivedSwap) error {
+	defer metrics.ObserveMongoOp("removeArchivedSources", time.Now())
+	for _, a := range swaps {
+		err := removeID(getArchiveSourceCollection(a.Kind), a.GetRecordKey())
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Warn("mongodb remove archived swap source failed", "key", a.Key, "err", err)
+			return mgoError(err)
+		}
+	}
+	return nil
+}
+
+// FindArchivedSwaps find archived records matching filter, limit <= 0 means no limit
+func FindArchivedSwaps(filter *ArchiveFilter, limit int) ([]*MgoArchivedSwap, error) {
+	defer metrics.ObserveMongoOp("findArchivedSwaps", time.Now())
+	var result []*MgoArchivedSwap
+	q := find(collSwapArchive, filter.query()).Sort("timestamp")
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	err := q.All(&result)
+	if err != nil {
+		return nil, mgoError(err)
+	}
+	return result, nil
+}
+
+// RestoreArchivedSwaps put archived records back to their tables and remove them from archive table,
+// records already exist in their tables are not overwritten.
+func RestoreArchivedSwaps(swaps []*MgoArchivedSwap) error {
+	defer metrics.ObserveMongoOp("restoreArchivedSwaps", time.Now())
+	for _, a := range swaps {
+		collection := getArchiveSourceCollection(a.Kind)
+		if collection == nil || a.GetRecordKey() == "" {
+			return fmt.Errorf("wrong archived swap '%v'", a.Key)
+		}
+		err := insert(collection, a.GetRecord())
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			log.Warn("mongodb restore archived swap failed", "key", a.Key, "err", err)
+			return mgoError(err)
+		}
+		err = removeID(collSwapArchive, a.Key)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return mgoError(err)
+		}
+		log.Info("mongodb restore archived swap", "key", a.Key)
+	}
+	return nil
+}
+
+// PurgeArchivedSwaps remove archived records matching filter permanently
+func PurgeArchivedSwaps(filter *ArchiveFilter) (int, error) {
+	defer metrics.ObserveMongoOp("purgeArchivedSwaps", time.Now())
+	removed, err := removeAll(collSwapArchive, filter.query())
+	if err != nil {
+		return 0, mgoError(err)
+	}
+	log.Info("mongodb purge archived swaps", "filter", filter, "count", removed)
+	return int(removed), nil
+}
@@ -1,23 +1,31 @@
-// Package mongodb is a wrapper of mgo.v2 that
+// Package mongodb is a wrapper of the official mongo go driver that
 // defines the collections and CRUD apis on them.
 package mongodb
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/weijun-sh/gethscan-server/cmd/utils"
 	"github.com/weijun-sh/gethscan-server/log"
-	"gopkg.in/mgo.v2"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
 var (
-	database *mgo.Database
-	session  *mgo.Session
+	database *mongo.Database
+	client   *mongo.Client
 
-	dialInfo *mgo.DialInfo
+	// all database operations are cancelled when program exits
+	dbCtx, dbCancel = context.WithCancel(context.Background())
+
+	// timeout of every database operation
+	opTimeout = 60 * time.Second
 
 	// MgoWaitGroup wait all mongodb related task done
 	MgoWaitGroup = new(sync.WaitGroup)
@@ -27,27 +35,42 @@ var (
 
 // HasSession has session connected
 func HasSession() bool {
-	return session != nil
+	return client != nil
 }
 
-// MongoServerInit int mongodb server session
+// MongoServerInit int mongodb server session,
+// addrs are 'host:port' or a 'mongodb://' connection string.
+// the driver keeps a connection pool and reconnects by itself.
 func MongoServerInit(addrs []string, dbname, user, pass string) {
-	initDialInfo(addrs, dbname, user, pass)
-	mongoConnect()
+	utils.TopWaitGroup.Add(1)
+	go utils.WaitAndCleanup(doCleanup)
+
+	mongoConnect(getClientOptions(addrs, dbname, user, pass), dbname)
 	initCollections()
-	go checkMongoSession()
 }
 
-func initDialInfo(addrs []string, db, user, pass string) {
-	dialInfo = &mgo.DialInfo{
-		Addrs:    addrs,
-		Database: db,
-		Username: user,
-		Password: pass,
+func getClientOptions(addrs []string, dbname, user, pass string) *options.ClientOptions {
+	opts := options.Client()
+	if len(addrs) == 1 && strings.HasPrefix(addrs[0], "mongodb") {
+		opts.ApplyURI(addrs[0]) // supports all auth mechanisms and options of connection string
+	} else {
+		opts.SetHosts(addrs)
+	}
+	if user != "" {
+		opts.SetAuth(options.Credential{
+			AuthSource: dbname,
+			Username:   user,
+			Password:   pass,
+		})
 	}
+	opts.SetReadPreference(readpref.Primary())
+	opts.SetWriteConcern(writeconcern.New(writeconcern.J(true)))
+	return opts
+}
 
-	utils.TopWaitGroup.Add(1)
-	go utils.WaitAndCleanup(doCleanup)
+// newContext new context of database operation
+func newContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(dbCtx, opTimeout)
 }
 
 func doCleanup() {
@@ -55,84 +78,55 @@ func doCleanup() {
 	if !HasSession() {
 		return
 	}
-	err := session.Fsync(false)
+	MgoWaitGroup.Wait()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := client.Disconnect(ctx)
+	dbCancel()
+	client = nil
 	if err != nil {
-		log.Warn("[mongodb] session flush failed", "err", err)
-	} else {
-		log.Info("[mongodb] session flush success")
+		log.Warn("[mongodb] session close failed", "err", err)
+		return
 	}
-	MgoWaitGroup.Wait()
-	session.Close()
-	session = nil
 	log.Info("[mongodb] session close success")
 }
 
-func mongoConnect() {
-	defer func(oldSession *mgo.Session) {
-		if oldSession != nil { // when reconnect
-			oldSession.Close()
-		}
-	}(session)
-	log.Info("[mongodb] connect database start.", "addrs", dialInfo.Addrs, "dbName", dialInfo.Database)
-	var err error
+func mongoConnect(opts *options.ClientOptions, dbname string) {
+	log.Info("[mongodb] connect database start.", "hosts", opts.Hosts, "dbName", dbname)
 	for {
-		session, err = mgo.DialWithInfo(dialInfo)
+		c, err := connect(opts)
 		if err == nil {
+			client = c
 			break
 		}
 		log.Warn("[mongodb] dial error", "err", err)
 		time.Sleep(1 * time.Second)
 	}
-	session.SetMode(mgo.Monotonic, true)
-	session.SetSafe(&mgo.Safe{FSync: true})
-	database = session.DB(dialInfo.Database)
-	deinintCollections()
-	log.Info("[mongodb] connect database finished.", "dbName", dialInfo.Database)
+	database = client.Database(dbname)
+	log.Info("[mongodb] connect database finished.", "dbName", dbname)
 }
 
-// fix 'read tcp 127.0.0.1:43502->127.0.0.1:27917: i/o timeout'
-func checkMongoSession() {
-	for {
-		time.Sleep(60 * time.Second)
-		if err := ensureMongoConnected(); err != nil {
-			if !HasSession() {
-				return
-			}
-			log.Info("[mongodb] check session error", "err", err)
-			log.Info("[mongodb] reconnect database", "dbName", dialInfo.Database)
-			mongoConnect()
-		}
+func connect(opts *options.ClientOptions) (*mongo.Client, error) {
+	ctx, cancel := newContext()
+	defer cancel()
+	c, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, err
 	}
-}
-
-func sessionPing() (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			err = fmt.Errorf("recover from error %v", r)
-		}
-	}()
-	for i := 0; i < 6; i++ {
-		err = session.Ping()
-		if err == nil {
-			break
-		}
-		time.Sleep(10 * time.Second)
+	if err = c.Ping(ctx, readpref.Primary()); err != nil {
+		_ = c.Disconnect(ctx)
+		return nil, err
 	}
-	return err
+	return c, nil
 }
 
-func ensureMongoConnected() (err error) {
-	err = sessionPing()
-	if err != nil {
-		if !HasSession() {
-			return errSessionIsClosed
-		}
-		log.Error("[mongodb] session ping error", "err", err)
-		log.Info("[mongodb] refresh session.", "dbName", dialInfo.Database)
-		session.Refresh()
-		database = session.DB(dialInfo.Database)
-		deinintCollections()
-		err = sessionPing()
+// PingSession ping database once with a short timeout (eg. for health check),
+// reconnecting is done by the driver.
+func PingSession(timeout time.Duration) error {
+	if !HasSession() {
+		return errSessionIsClosed
 	}
-	return err
+	ctx, cancel := context.WithTimeout(dbCtx, timeout)
+	defer cancel()
+	return client.Ping(ctx, readpref.Primary())
 }
@@ -4,7 +4,7 @@ import (
 	"errors"
 
 	rpcjson "github.com/gorilla/rpc/v2/json2"
-	"gopkg.in/mgo.v2"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func newError(ec rpcjson.ErrorCode, message string) error {
@@ -16,10 +16,10 @@ func newError(ec rpcjson.ErrorCode, message string) error {
 
 func mgoError(err error) error {
 	if err != nil {
-		if errors.Is(err, mgo.ErrNotFound) {
+		if errors.Is(err, mongo.ErrNoDocuments) {
 			return ErrItemNotFound
 		}
-		if mgo.IsDup(err) {
+		if mongo.IsDuplicateKeyError(err) {
 			return ErrItemIsDup
 		}
 		return newError(-32001, "mgoError: "+err.Error())
@@ -35,4 +35,7 @@ var (
 	ErrWrongKey           = newError(-32012, "mgoError: Wrong key")
 	ErrForbidUpdateNonce  = newError(-32013, "mgoError: Forbid update swap nonce")
 	ErrForbidUpdateSwapTx = newError(-32014, "mgoError: Forbid update swap tx")
+	ErrStateTransition    = newError(-32015, "mgoError: Invalid state transition")
+	ErrItemIsLeased       = newError(-32016, "mgoError: Item is leased by another instance")
+	ErrItemIsChanged      = newError(-32017, "mgoError: Item is changed by another instance")
 )
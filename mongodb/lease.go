@@ -0,0 +1,144 @@
+package mongodb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/weijun-sh/gethscan-server/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// -----------------------------------------------
+// work leases of records shared by several scan server instances
+//
+// an instance processes a record only after it leases the record,
+// by setting 'lockedby' to its instance id and 'lockeduntil' to the
+// lease expire time. leases of crashed instances expire by time,
+// then other instances can lease the records and process them.
+// -----------------------------------------------
+
+var instanceID = getDefaultInstanceID()
+
+func getDefaultInstanceID() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%v:%v", hostname, os.Getpid())
+}
+
+// SetInstanceID set id of this instance, must be unique among instances
+func SetInstanceID(id string) {
+	if id != "" {
+		instanceID = id
+	}
+}
+
+// GetInstanceID get id of this instance
+func GetInstanceID() string {
+	return instanceID
+}
+
+// notLeasedQuery query records not leased by other instances
+func notLeasedQuery() bson.M {
+	return bson.M{"$or": []bson.M{
+		{"lockeduntil": bson.M{"$exists": false}},
+		{"lockeduntil": bson.M{"$lte": time.Now().Unix()}},
+		{"lockedby": instanceID},
+	}}
+}
+
+func acquireLease(collection *mongo.Collection, key string, duration time.Duration) error {
+	defer metrics.ObserveMongoOp("acquireLease:"+collection.Name(), time.Now())
+	selector := bson.M{"$and": []bson.M{{"_id": key}, notLeasedQuery()}}
+	updates := bson.M{"lockedby": instanceID, "lockeduntil": time.Now().Add(duration).Unix()}
+	err := updateOne(collection, selector, bson.M{"$set": updates})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if n, _ := findID(collection, key).Count(); n > 0 {
+			return ErrItemIsLeased
+		}
+	}
+	return mgoError(err)
+}
+
+func releaseLease(collection *mongo.Collection, key string) error {
+	selector := bson.M{"_id": key, "lockedby": instanceID}
+	err := updateOne(collection, selector, bson.M{"$unset": bson.M{"lockedby": "", "lockeduntil": ""}})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil // lease expired and taken by others, or record removed
+	}
+	return mgoError(err)
+}
+
+// LeaseRegisteredSwap lease registered swap before posting it, the swap is leased only
+// if it is still in status (and its next attempt is due if posting), so a stale copy
+// read before another instance posted the swap is not posted again.
+// return the leased swap, or ErrItemIsChanged if the swap is changed by others.
+func LeaseRegisteredSwap(key string, status SwapState, duration time.Duration) (*MgoRegisteredSwap, error) {
+	defer metrics.ObserveMongoOp("acquireLease:"+collRegisteredSwap.Name(), time.Now())
+	queries := []bson.M{{"_id": key}, {"status": status}, notLeasedQuery()}
+	if status == StatePosting {
+		queries = append(queries, bson.M{"nextattempt": bson.M{"$lte": time.Now().Unix()}})
+	}
+	updates := bson.M{"lockedby": instanceID, "lockeduntil": time.Now().Add(duration).Unix()}
+	var result MgoRegisteredSwap
+	err := findAndUpdate(collRegisteredSwap, bson.M{"$and": queries}, bson.M{"$set": updates}, &result)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		var swap MgoRegisteredSwap
+		if findID(collRegisteredSwap, key).One(&swap) != nil {
+			return nil, ErrItemNotFound
+		}
+		if swap.LockedBy != instanceID && swap.LockedUntil > time.Now().Unix() {
+			return nil, ErrItemIsLeased
+		}
+		return nil, ErrItemIsChanged
+	}
+	if err != nil {
+		return nil, mgoError(err)
+	}
+	return &result, nil
+}
+
+// ReleaseRegisteredSwap release lease of registered swap
+func ReleaseRegisteredSwap(key string) error {
+	return releaseLease(collRegisteredSwap, key)
+}
+
+// LeaseSwapPending lease swap pending before verifying it
+func LeaseSwapPending(txid string, duration time.Duration) error {
+	return acquireLease(collRegisteredSwapPending, txid, duration)
+}
+
+// ReleaseSwapPending release lease of swap pending
+func ReleaseSwapPending(txid string) error {
+	return releaseLease(collRegisteredSwapPending, txid)
+}
+
+// LeaseWebhookEvent lease webhook event before delivering it
+func LeaseWebhookEvent(key string, duration time.Duration) error {
+	return acquireLease(collWebhookEvent, key, duration)
+}
+
+// ReleaseWebhookEvent release lease of webhook event
+func ReleaseWebhookEvent(key string) error {
+	return releaseLease(collWebhookEvent, key)
+}
+
+// LeaseChainScan lease scanning of chain, only the lease holder scans the chain
+// and updates its scan info
+func LeaseChainScan(chain string, duration time.Duration) error {
+	defer metrics.ObserveMongoOp("acquireLease:"+collLatestScanInfo.Name(), time.Now())
+	selector := bson.M{"$and": []bson.M{{"_id": getChainScanInfoKey(chain)}, notLeasedQuery()}}
+	updates := bson.M{"lockedby": instanceID, "lockeduntil": time.Now().Add(duration).Unix()}
+	err := upsertOne(collLatestScanInfo, selector, bson.M{"$set": updates})
+	if mongo.IsDuplicateKeyError(err) {
+		// not matched as leased by others, and failed to insert the existing record
+		return ErrItemIsLeased
+	}
+	return mgoError(err)
+}
+
+// ReleaseChainScan release lease of scanning chain
+func ReleaseChainScan(chain string) error {
+	return releaseLease(collLatestScanInfo, getChainScanInfoKey(chain))
+}
@@ -0,0 +1,277 @@
+package mongodb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/weijun-sh/gethscan-server/log"
+	"github.com/weijun-sh/gethscan-server/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// -----------------------------------------------
+// scan server record lifecycle
+// every state change is validated and appended to the 'history' array
+//
+// -----------------------------------------------
+// 1. swap pending state change graph (swapPending, registered by chain and txid)
+//
+// Submitted -> Verifying -> |- Verified (swaps of tx are registered, see 2.)
+//                           |- Failed   (tx not found, or no swap in tx)
+//                           |- Verifying (retry later, eg. receipt not found)
+// -----------------------------------------------
+// 2. registered swap state change graph (swapRegistered, one per swap log)
+//
+// Verifying (wait confirmations) -> |- Verified
+//                                   |- Verifying (tx not found, wait it packed again)
+//                                   |- Failed (tx still not found after several checks)
+// Verified -> |- Posting -> |- Posted
+//             |             |- Duplicate
+//             |             |- Rejected
+//             |             |- Posting   (transient failure, retry later)
+//             |             |- Failed    (retry exhausted)
+//             |- Verified  (tx is packed in another confirmed block)
+//             |- Verifying (block changed or tx not found, wait confirmations again)
+//
+// Posting swaps can also go back to Verifying when the block changed or tx is not found.
+// Posted, Duplicate, Rejected and Failed are final states.
+// -----------------------------------------------
+
+// SwapState lifecycle state of scan server records
+type SwapState string
+
+// swap state values
+const (
+	StateSubmitted SwapState = "submitted"
+	StateVerifying SwapState = "verifying"
+	StateVerified  SwapState = "verified"
+	StatePosting   SwapState = "posting"
+	StatePosted    SwapState = "posted"
+	StateDuplicate SwapState = "duplicate"
+	StateRejected  SwapState = "rejected"
+	StateFailed    SwapState = "failed"
+)
+
+// AllSwapStates all lifecycle states
+var AllSwapStates = []SwapState{StateSubmitted, StateVerifying, StateVerified, StatePosting, StatePosted, StateDuplicate, StateRejected, StateFailed}
+
+// IsFinal is final state
+func (state SwapState) IsFinal() bool {
+	switch state {
+	case StatePosted, StateDuplicate, StateRejected, StateFailed:
+		return true
+	default:
+		return false
+	}
+}
+
+// stateTransitions map from state to its allowed next states
+type stateTransitions map[SwapState][]SwapState
+
+var swapPendingTransitions = stateTransitions{
+	StateSubmitted: {StateVerifying},
+	StateVerifying: {StateVerifying, StateVerified, StateFailed},
+}
+
+var registeredSwapTransitions = stateTransitions{
+	StateVerifying: {StateVerifying, StateVerified, StateFailed},
+	StateVerified:  {StatePosting, StateVerified, StateVerifying, StateFailed},
+	StatePosting:   {StatePosting, StatePosted, StateDuplicate, StateRejected, StateFailed, StateVerifying},
+}
+
+// getStatesTransferTo get states which are allowed to transfer to state 'to'
+func (transitions stateTransitions) getStatesTransferTo(to SwapState) []SwapState {
+	var froms []SwapState
+	for from, tos := range transitions {
+		for _, state := range tos {
+			if state == to {
+				froms = append(froms, from)
+				break
+			}
+		}
+	}
+	return froms
+}
+
+// CheckStateTransition check if transition is allowed
+func (transitions stateTransitions) CheckStateTransition(from, to SwapState) error {
+	for _, state := range transitions[from] {
+		if state == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w from '%v' to '%v'", ErrStateTransition, from, to)
+}
+
+// CheckSwapPendingTransition check if transition of swap pending state is allowed
+func CheckSwapPendingTransition(from, to SwapState) error {
+	return swapPendingTransitions.CheckStateTransition(from, to)
+}
+
+// CheckRegisteredSwapTransition check if transition of registered swap state is allowed
+func CheckRegisteredSwapTransition(from, to SwapState) error {
+	return registeredSwapTransitions.CheckStateTransition(from, to)
+}
+
+// MgoStateHistory state change history item
+type MgoStateHistory struct {
+	State     SwapState `bson:"state"`
+	Message   string    `bson:"message,omitempty"`
+	Timestamp int64     `bson:"timestamp"`
+}
+
+func newStateHistory(state SwapState, message string, now time.Time) []*MgoStateHistory {
+	return []*MgoStateHistory{{State: state, Message: message, Timestamp: now.Unix()}}
+}
+
+// updateSwapState update state of record if transition is allowed,
+// other fields in 'updates' are set along with the state.
+func updateSwapState(collection *mongo.Collection, transitions stateTransitions, key string, to SwapState, message string, updates bson.M) error {
+	defer metrics.ObserveMongoOp("updateState:"+collection.Name(), time.Now())
+	now := time.Now()
+	if updates == nil {
+		updates = bson.M{}
+	}
+	updates["status"] = to
+	updates["time"] = now.Format("2006-01-02 15:04:05")
+	history := &MgoStateHistory{State: to, Message: message, Timestamp: now.Unix()}
+
+	selector := bson.M{"_id": key, "status": bson.M{"$in": transitions.getStatesTransferTo(to)}}
+	err := updateOne(collection, selector, bson.M{"$set": updates, "$push": bson.M{"history": history}})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		var old struct {
+			Status SwapState `bson:"status"`
+		}
+		if findID(collection, key).One(&old) == nil {
+			if checkErr := transitions.CheckStateTransition(old.Status, to); checkErr != nil {
+				err = checkErr
+			}
+		}
+	}
+	if err != nil {
+		log.Info("mongodb update swap state failed", "table", collection.Name(), "key", key, "to", to, "message", message, "err", err)
+		if errors.Is(err, ErrStateTransition) {
+			return err
+		}
+		return mgoError(err)
+	}
+	log.Info("mongodb update swap state", "table", collection.Name(), "key", key, "to", to, "message", message)
+	return nil
+}
+
+// UpdateSwapPendingState update state of swap pending
+func UpdateSwapPendingState(txid string, to SwapState, message string) error {
+	updates := bson.M{"lasterror": message}
+	return updateSwapState(collRegisteredSwapPending, swapPendingTransitions, txid, to, message, updates)
+}
+
+// UpdateRegisteredSwapState update state of registered swap
+func UpdateRegisteredSwapState(key string, to SwapState, message string) error {
+	return updateRegisteredSwapState(key, to, message, nil)
+}
+
+func updateRegisteredSwapState(key string, to SwapState, message string, updates bson.M) error {
+	return updateSwapState(collRegisteredSwap, registeredSwapTransitions, key, to, message, updates)
+}
+
+// legacy free-form status values before lifecycle states are defined
+var (
+	legacyPendingStates = map[string]SwapState{
+		"new":            StateSubmitted,
+		"success":        StateVerified,
+		"failed":         StateFailed,
+		"swap not found": StateFailed,
+	}
+	legacyRegisteredStates = map[string]SwapState{
+		"new":               StateVerified,
+		"success":           StatePosted,
+		"failed":            StateFailed,
+		"waitconfirmations": StateVerifying,
+		"reorged":           StateFailed,
+		"postretry":         StatePosting,
+		"deadletter":        StateFailed,
+	}
+)
+
+// migrateLegacyStates convert legacy status values to lifecycle states.
+// other legacy values are error messages returned by swap server.
+func migrateLegacyStates() {
+	for legacy, state := range legacyPendingStates {
+		updated, err := updateAll(collRegisteredSwapPending, bson.M{"status": legacy}, bson.M{"$set": bson.M{"status": state}})
+		if err == nil && updated > 0 {
+			log.Info("migrate legacy swap pending status", "from", legacy, "to", state, "count", updated)
+		}
+	}
+	for legacy, state := range legacyRegisteredStates {
+		updated, err := updateAll(collRegisteredSwap, bson.M{"status": legacy}, bson.M{"$set": bson.M{"status": state}})
+		if err == nil && updated > 0 {
+			log.Info("migrate legacy registered swap status", "from", legacy, "to", state, "count", updated)
+		}
+	}
+
+	var legacySwaps []*MgoRegisteredSwap
+	_ = find(collRegisteredSwap, bson.M{"status": bson.M{"$nin": AllSwapStates}}).All(&legacySwaps)
+	for _, swap := range legacySwaps {
+		legacy := string(swap.Status)
+		state := StateRejected
+		if isDuplicatePostError(legacy) {
+			state = StateDuplicate
+		}
+		updates := bson.M{"status": state, "lasterror": legacy}
+		if err := updateID(collRegisteredSwap, swap.Key, bson.M{"$set": updates}); err == nil {
+			log.Info("migrate legacy registered swap status", "key", swap.Key, "from", legacy, "to", state)
+		}
+	}
+}
+
+func isDuplicatePostError(message string) bool {
+	for _, keyword := range []string{"already registered", "alreday registered", "Item is duplicate"} {
+		if strings.Contains(message, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// CountSwapStates count records of swap pending and registered swap in not final states
+func CountSwapStates() (pending, registered map[SwapState]int, err error) {
+	defer metrics.ObserveMongoOp("countSwapStates", time.Now())
+	pending, err = countStates(collRegisteredSwapPending)
+	if err != nil {
+		return nil, nil, err
+	}
+	registered, err = countStates(collRegisteredSwap)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pending, registered, nil
+}
+
+func countStates(collection *mongo.Collection) (map[SwapState]int, error) {
+	var result []struct {
+		State SwapState `bson:"_id"`
+		Count int       `bson:"count"`
+	}
+	var states []SwapState
+	for _, state := range AllSwapStates {
+		if !state.IsFinal() {
+			states = append(states, state)
+		}
+	}
+	pipeline := []bson.M{
+		{"$match": bson.M{"status": bson.M{"$in": states}}},
+		{"$group": bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}},
+	}
+	err := aggregate(collection, pipeline, &result)
+	if err != nil {
+		return nil, mgoError(err)
+	}
+	counts := make(map[SwapState]int, len(result))
+	for _, item := range result {
+		counts[item.State] = item.Count
+	}
+	return counts, nil
+}
@@ -0,0 +1,299 @@
+package mongodb
+
+import (
+	"errors"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// query find query of collection, executed by One, All or Count.
+// operations on collections return errSessionIsClosed if mongodb is not initialized
+// (eg. records are stored in another storage).
+type query struct {
+	collection *mongo.Collection
+	filter     interface{}
+	sort       bson.D
+	skip       int64
+	limit      int64
+}
+
+func find(collection *mongo.Collection, filter interface{}) *query {
+	if filter == nil {
+		filter = bson.M{}
+	}
+	return &query{collection: collection, filter: filter}
+}
+
+func findID(collection *mongo.Collection, id interface{}) *query {
+	return find(collection, bson.M{"_id": id})
+}
+
+// Sort sort by fields, prefix field with '-' to sort in descending order
+func (q *query) Sort(fields ...string) *query {
+	q.sort = getIndexKeys(fields...)
+	return q
+}
+
+// Skip skip n records
+func (q *query) Skip(n int) *query {
+	q.skip = int64(n)
+	return q
+}
+
+// Limit return at most n records
+func (q *query) Limit(n int) *query {
+	q.limit = int64(n)
+	return q
+}
+
+// One find one record, return mongo.ErrNoDocuments if not found
+func (q *query) One(result interface{}) error {
+	if q.collection == nil {
+		return errSessionIsClosed
+	}
+	ctx, cancel := newContext()
+	defer cancel()
+	opts := options.FindOne()
+	if q.sort != nil {
+		opts.SetSort(q.sort)
+	}
+	if q.skip > 0 {
+		opts.SetSkip(q.skip)
+	}
+	return q.collection.FindOne(ctx, q.filter, opts).Decode(result)
+}
+
+// All find all records, result is pointer to slice
+func (q *query) All(result interface{}) error {
+	if q.collection == nil {
+		return errSessionIsClosed
+	}
+	ctx, cancel := newContext()
+	defer cancel()
+	opts := options.Find()
+	if q.sort != nil {
+		opts.SetSort(q.sort)
+	}
+	if q.skip > 0 {
+		opts.SetSkip(q.skip)
+	}
+	if q.limit > 0 {
+		opts.SetLimit(q.limit)
+	}
+	cursor, err := q.collection.Find(ctx, q.filter, opts)
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, result)
+}
+
+// Count count records
+func (q *query) Count() (int, error) {
+	if q.collection == nil {
+		return 0, errSessionIsClosed
+	}
+	ctx, cancel := newContext()
+	defer cancel()
+	opts := options.Count()
+	if q.skip > 0 {
+		opts.SetSkip(q.skip)
+	}
+	if q.limit > 0 {
+		opts.SetLimit(q.limit)
+	}
+	count, err := q.collection.CountDocuments(ctx, q.filter, opts)
+	return int(count), err
+}
+
+func insert(collection *mongo.Collection, docs ...interface{}) (err error) {
+	if collection == nil {
+		return errSessionIsClosed
+	}
+	ctx, cancel := newContext()
+	defer cancel()
+	if len(docs) == 1 {
+		_, err = collection.InsertOne(ctx, docs[0])
+	} else {
+		_, err = collection.InsertMany(ctx, docs)
+	}
+	return err
+}
+
+// insertUnordered insert records in one bulk write, failed records (eg. duplicate)
+// do not stop inserting the others. return error of every record (nil if inserted),
+// or err if the bulk write failed as a whole.
+func insertUnordered(collection *mongo.Collection, docs []interface{}) (errs []error, err error) {
+	if collection == nil {
+		return nil, errSessionIsClosed
+	}
+	errs = make([]error, len(docs))
+	if len(docs) == 0 {
+		return errs, nil
+	}
+	ctx, cancel := newContext()
+	defer cancel()
+	_, err = collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil {
+		for _, we := range bulkErr.WriteErrors {
+			if we.Index >= 0 && we.Index < len(errs) {
+				errs[we.Index] = mongo.WriteException{WriteErrors: mongo.WriteErrors{we.WriteError}}
+			}
+		}
+		return errs, nil
+	}
+	return errs, err
+}
+
+// updateOne update one record, return mongo.ErrNoDocuments if not matched
+func updateOne(collection *mongo.Collection, selector, update interface{}) error {
+	if collection == nil {
+		return errSessionIsClosed
+	}
+	ctx, cancel := newContext()
+	defer cancel()
+	res, err := collection.UpdateOne(ctx, selector, update)
+	if err == nil && res.MatchedCount == 0 {
+		err = mongo.ErrNoDocuments
+	}
+	return err
+}
+
+func updateID(collection *mongo.Collection, id, update interface{}) error {
+	return updateOne(collection, bson.M{"_id": id}, update)
+}
+
+// updateAll update all matched records, return count of updated records
+func updateAll(collection *mongo.Collection, selector, update interface{}) (int64, error) {
+	if collection == nil {
+		return 0, errSessionIsClosed
+	}
+	ctx, cancel := newContext()
+	defer cancel()
+	res, err := collection.UpdateMany(ctx, selector, update)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+// findAndUpdate update one record and decode the updated record into result,
+// return mongo.ErrNoDocuments if not matched
+func findAndUpdate(collection *mongo.Collection, selector, update, result interface{}) error {
+	if collection == nil {
+		return errSessionIsClosed
+	}
+	ctx, cancel := newContext()
+	defer cancel()
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	return collection.FindOneAndUpdate(ctx, selector, update, opts).Decode(result)
+}
+
+func upsertID(collection *mongo.Collection, id, update interface{}) error {
+	return upsertOne(collection, bson.M{"_id": id}, update)
+}
+
+// upsertOne update one record, insert if not matched
+func upsertOne(collection *mongo.Collection, selector, update interface{}) error {
+	if collection == nil {
+		return errSessionIsClosed
+	}
+	ctx, cancel := newContext()
+	defer cancel()
+	_, err := collection.UpdateOne(ctx, selector, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// replaceID replace record, insert if not exist
+func replaceID(collection *mongo.Collection, id, doc interface{}) error {
+	if collection == nil {
+		return errSessionIsClosed
+	}
+	ctx, cancel := newContext()
+	defer cancel()
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": id}, doc, options.Replace().SetUpsert(true))
+	return err
+}
+
+// removeID remove record, return mongo.ErrNoDocuments if not exist
+func removeID(collection *mongo.Collection, id interface{}) error {
+	if collection == nil {
+		return errSessionIsClosed
+	}
+	ctx, cancel := newContext()
+	defer cancel()
+	res, err := collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err == nil && res.DeletedCount == 0 {
+		err = mongo.ErrNoDocuments
+	}
+	return err
+}
+
+// removeAll remove all matched records, return count of removed records
+func removeAll(collection *mongo.Collection, selector interface{}) (int64, error) {
+	if collection == nil {
+		return 0, errSessionIsClosed
+	}
+	ctx, cancel := newContext()
+	defer cancel()
+	res, err := collection.DeleteMany(ctx, selector)
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+// aggregate run pipeline, result is pointer to slice
+func aggregate(collection *mongo.Collection, pipeline, result interface{}) error {
+	if collection == nil {
+		return errSessionIsClosed
+	}
+	ctx, cancel := newContext()
+	defer cancel()
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, result)
+}
+
+// ensureIndex create index of keys if not exist, the index is named like mgo.v2
+// (eg. 'status_1_timestamp_1'), so indexes created before are reused.
+func ensureIndex(collection *mongo.Collection, keys ...string) error {
+	if collection == nil {
+		return errSessionIsClosed
+	}
+	ctx, cancel := newContext()
+	defer cancel()
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: getIndexKeys(keys...)})
+	return err
+}
+
+// ensureTTLIndex create ttl index of date field, records are removed when the time is reached
+func ensureTTLIndex(collection *mongo.Collection, key string) error {
+	if collection == nil {
+		return errSessionIsClosed
+	}
+	ctx, cancel := newContext()
+	defer cancel()
+	opts := options.Index().SetExpireAfterSeconds(0)
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: getIndexKeys(key), Options: opts})
+	return err
+}
+
+// getIndexKeys convert fields to index keys, prefix field with '-' for descending order
+func getIndexKeys(fields ...string) bson.D {
+	keys := make(bson.D, 0, len(fields))
+	for _, field := range fields {
+		order := 1
+		if strings.HasPrefix(field, "-") {
+			order = -1
+			field = field[1:]
+		}
+		keys = append(keys, bson.E{Key: field, Value: order})
+	}
+	return keys
+}
@@ -1,57 +1,36 @@
 package mongodb
 
 import (
-	"gopkg.in/mgo.v2"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var (
-	collSwapin            *mgo.Collection
-	collSwapout           *mgo.Collection
-	collSwapinResult      *mgo.Collection
-	collSwapoutResult     *mgo.Collection
-	collP2shAddress       *mgo.Collection
-	collSwapStatistics    *mgo.Collection
-	collLatestScanInfo    *mgo.Collection
-	collRegisteredAddress *mgo.Collection
-	collBlacklist         *mgo.Collection
-	collLatestSwapNonces  *mgo.Collection
-	collSwapHistory       *mgo.Collection
-	collUsedRValue        *mgo.Collection
+	collSwapin            *mongo.Collection
+	collSwapout           *mongo.Collection
+	collSwapinResult      *mongo.Collection
+	collSwapoutResult     *mongo.Collection
+	collP2shAddress       *mongo.Collection
+	collSwapStatistics    *mongo.Collection
+	collLatestScanInfo    *mongo.Collection
+	collRegisteredAddress *mongo.Collection
+	collBlacklist         *mongo.Collection
+	collLatestSwapNonces  *mongo.Collection
+	collSwapHistory       *mongo.Collection
+	collUsedRValue        *mongo.Collection
 
-	collRegisteredSwap        *mgo.Collection
-	//collRegisteredSwapRouter  *mgo.Collection
-	collRegisteredSwapPending *mgo.Collection
-	collSwapPost              *mgo.Collection
-	collSwapDelete            *mgo.Collection
+	collRegisteredSwap        *mongo.Collection
+	//collRegisteredSwapRouter  *mongo.Collection
+	collRegisteredSwapPending *mongo.Collection
+	collSwapPost              *mongo.Collection
+	collSwapDelete            *mongo.Collection
+	collWebhookEvent          *mongo.Collection
+	collSwapArchive           *mongo.Collection
 )
 
-func isSwapin(collection *mgo.Collection) bool {
+func isSwapin(collection *mongo.Collection) bool {
 	return collection == collSwapin || collection == collSwapinResult
 }
 
-// do this when reconnect to the database
-func deinintCollections() {
-	//collSwapin = database.C(tbSwapins)
-	//collSwapout = database.C(tbSwapouts)
-	//collSwapinResult = database.C(tbSwapinResults)
-	//collSwapoutResult = database.C(tbSwapoutResults)
-	//collP2shAddress = database.C(tbP2shAddresses)
-	//collSwapStatistics = database.C(tbSwapStatistics)
-	//collLatestScanInfo = database.C(tbLatestScanInfo)
-	//collRegisteredAddress = database.C(tbRegisteredAddress)
-	//collBlacklist = database.C(tbBlacklist)
-	//collLatestSwapNonces = database.C(tbLatestSwapNonces)
-	//collSwapHistory = database.C(tbSwapHistory)
-	//collUsedRValue = database.C(tbUsedRValues)
-
-	collRegisteredSwap = database.C(tbRegisteredSwap)
-	//collRegisteredSwapRouter = database.C(tbRegisteredSwapRouter)
-	collRegisteredSwapPending = database.C(tbRegisteredSwapPending)
-
-	collSwapPost = database.C(tbSwapPost)
-	collSwapDelete = database.C(tbSwapDelete)
-}
-
 func initCollections() {
 	//initCollection(tbSwapins, &collSwapin, "inittime", "status")
 	//initCollection(tbSwapouts, &collSwapout, "inittime", "status")
@@ -59,7 +38,7 @@ func initCollections() {
 	//initCollection(tbSwapoutResults, &collSwapoutResult, "from", "inittime")
 	//initCollection(tbP2shAddresses, &collP2shAddress, "p2shaddress")
 	//initCollection(tbSwapStatistics, &collSwapStatistics)
-	//initCollection(tbLatestScanInfo, &collLatestScanInfo)
+	initCollection(tbLatestScanInfo, &collLatestScanInfo)
 	//initCollection(tbRegisteredAddress, &collRegisteredAddress)
 	//initCollection(tbBlacklist, &collBlacklist)
 	//initCollection(tbLatestSwapNonces, &collLatestSwapNonces, "address")
@@ -67,23 +46,38 @@ func initCollections() {
 	//initCollection(tbUsedRValues, &collUsedRValue)
 
 	initCollection(tbRegisteredSwap, &collRegisteredSwap, "txid")
+	_ = ensureIndex(collRegisteredSwap, "status", "nextattempt")
+	_ = ensureIndex(collRegisteredSwap, "postresult")
+	_ = ensureIndex(collRegisteredSwap, "status", "timestamp")
+	_ = ensureIndex(collRegisteredSwap, "event.from")
+	_ = ensureIndex(collRegisteredSwap, "event.to")
 	//initCollection(tbRegisteredSwapRouter, &collRegisteredSwapRouter, "txid")
 	initCollection(tbRegisteredSwapPending, &collRegisteredSwapPending, "txid")
+	_ = ensureIndex(collRegisteredSwapPending, "jobid")
+	_ = ensureIndex(collRegisteredSwapPending, "status", "timestamp")
 	initCollection(tbSwapPost, &collSwapPost, "txid")
 	initCollection(tbSwapDelete, &collSwapDelete, "txid")
+	_ = ensureIndex(collSwapDelete, "timestamp")
+	_ = ensureIndex(collSwapPost, "timestamp")
+	initCollection(tbWebhookEvent, &collWebhookEvent, "status", "nextattempt")
+	_ = ensureTTLIndex(collWebhookEvent, "expireat")
+	initCollection(tbSwapArchive, &collSwapArchive, "txid")
+	_ = ensureIndex(collSwapArchive, "timestamp")
+
+	migrateLegacyStates()
 
 	//initDefaultValue()
 }
 
-func initCollection(table string, collection **mgo.Collection, indexKey ...string) {
-	*collection = database.C(table)
+func initCollection(table string, collection **mongo.Collection, indexKey ...string) {
+	*collection = database.Collection(table)
 	if len(indexKey) != 0 && indexKey[0] != "" {
-		_ = (*collection).EnsureIndexKey(indexKey...)
+		_ = ensureIndex(*collection, indexKey...)
 	}
 }
 
 func initDefaultValue() {
-	_ = collLatestScanInfo.Insert(
+	_ = insert(collLatestScanInfo, 
 		&MgoLatestScanInfo{
 			Key: keyOfSrcLatestScanInfo,
 		},
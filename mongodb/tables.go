@@ -1,7 +1,9 @@
 package mongodb
 
 import (
-	"gopkg.in/mgo.v2/bson"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 const (
@@ -26,6 +28,8 @@ const (
 	tbRegisteredSwapRouter  string = "swapRegisteredRouter"
 	tbRegisteredSwapPending string = "swapPending"
 	tbSwapDelete            string = "swapDeleted"
+	tbWebhookEvent          string = "webhookEvents"
+	tbSwapArchive           string = "swapArchive"
 )
 
 // MgoSwap registered swap
@@ -89,27 +93,109 @@ type MgoP2shAddress struct {
 	P2shAddress string `bson:"p2shaddress"`
 }
 
-// MgoRegisteredSwap key is address (in whitelist)
+// MgoRegisteredSwap key is txid + logindex + swapserver
 type MgoRegisteredSwap struct {
 	Key        string `bson:"_id"`
+	TxID       string `bson:"txid"`
 	PairID     string `bson:"pairid"`
 	Method     string `bson:"rpcmethod"`
 	LogIndex   uint64 `bson:"logindex"`
 	SwapServer string `bson:"swapserver"`
 	Chain      string `bson:"chain"`
 	ChainID    uint64 `bson:"chainid"`
-	Status     string `bson:"status"`
+	Status     SwapState `bson:"status"`
 	Timestamp  int64  `bson:"timestamp"`
 	Time       string `bson:"time"`
+
+	History []*MgoStateHistory `bson:"history,omitempty"`
+
+	BlockHeight uint64 `bson:"blockheight,omitempty"`
+	BlockHash   string `bson:"blockhash,omitempty"`
+
+	// tx is not found on chain since registered (eg. reorged)
+	MissingChecks int   `bson:"missingchecks,omitempty"`
+	MissingSince  int64 `bson:"missingsince,omitempty"`
+
+	// decoded router swap log, nil for bridge swaps
+	Event *MgoRouterSwapEvent `bson:"event,omitempty"`
+
+	// post retry
+	Attempts    int    `bson:"attempts,omitempty"`
+	LastError   string `bson:"lasterror,omitempty"`
+	NextAttempt int64  `bson:"nextattempt,omitempty"`
+
+	// classified result of the last post
+	PostResult    string `bson:"postresult,omitempty"`
+	PostErrorCode int    `bson:"posterrorcode,omitempty"`
+	LastResponse  string `bson:"lastresponse,omitempty"` // latest response of swap server
+
+	// lease of instance processing it
+	LockedBy    string `bson:"lockedby,omitempty"`
+	LockedUntil int64  `bson:"lockeduntil,omitempty"`
+}
+
+// MgoRouterSwapEvent decoded router swap log,
+// addresses are lower case hex and numbers are decimal strings.
+type MgoRouterSwapEvent struct {
+	Name        string   `bson:"name"`
+	Token       string   `bson:"token,omitempty"`
+	From        string   `bson:"from,omitempty"`
+	To          string   `bson:"to,omitempty"`
+	Amount      string   `bson:"amount,omitempty"`
+	FromChainID string   `bson:"fromchainid,omitempty"`
+	ToChainID   string   `bson:"tochainid,omitempty"`
+	TokenID     string   `bson:"tokenid,omitempty"`  // nft 721 and 1155
+	TokenIDs    []string `bson:"tokenids,omitempty"` // nft 1155 batch
+	Amounts     []string `bson:"amounts,omitempty"`  // nft 1155 batch
 }
 
 // MgoRegisteredSwapPending key is address (in whitelist)
 type MgoRegisteredSwapPending struct {
 	Key        string `bson:"_id"`
+	JobID      string `bson:"jobid,omitempty"`
 	Chain      string `bson:"chain"`
-	Status     string `bson:"status"`
+	Status     SwapState `bson:"status"`
 	Timestamp  int64  `bson:"timestamp"`
 	Time       string `bson:"time"`
+	LastError  string `bson:"lasterror,omitempty"`
+
+	History []*MgoStateHistory `bson:"history,omitempty"`
+
+	// lease of instance processing it
+	LockedBy    string `bson:"lockedby,omitempty"`
+	LockedUntil int64  `bson:"lockeduntil,omitempty"`
+}
+
+// MgoWebhookEvent webhook notification to deliver, key is swap key + event + webhook name
+type MgoWebhookEvent struct {
+	Key         string `bson:"_id"`
+	Webhook     string `bson:"webhook"`
+	Event       string `bson:"event"`
+	Payload     string `bson:"payload"` // signed json body
+	Status      string `bson:"status"`
+	Attempts    int    `bson:"attempts,omitempty"`
+	NextAttempt int64  `bson:"nextattempt"`
+	LastError   string `bson:"lasterror,omitempty"`
+	Timestamp   int64  `bson:"timestamp"`
+
+	// removed by ttl index after delivered or failed
+	ExpireAt *time.Time `bson:"expireat,omitempty"`
+
+	// lease of instance delivering it
+	LockedBy    string `bson:"lockedby,omitempty"`
+	LockedUntil int64  `bson:"lockeduntil,omitempty"`
+}
+
+// MgoArchivedSwap finished swap record moved from table of kind, key is kind + record key
+type MgoArchivedSwap struct {
+	Key        string `bson:"_id"`
+	Kind       string `bson:"kind"`
+	TxID       string `bson:"txid"`
+	Timestamp  int64  `bson:"timestamp"` // timestamp of the record
+	ArchivedAt int64  `bson:"archivedat"`
+
+	Pending *MgoRegisteredSwapPending `bson:"pending,omitempty"` // kind is pending
+	Swap    *MgoRegisteredSwap        `bson:"swap,omitempty"`    // other kinds
 }
 
 // MgoRegisteredAddress key is address (in whitelist)
@@ -135,6 +221,10 @@ type MgoLatestScanInfo struct {
 	Key         string `bson:"_id"`
 	BlockHeight uint64 `bson:"blockheight"`
 	Timestamp   int64  `bson:"timestamp"`
+
+	// lease of instance scanning the chain
+	LockedBy    string `bson:"lockedby,omitempty"`
+	LockedUntil int64  `bson:"lockeduntil,omitempty"`
 }
 
 // MgoBlackAccount key is address
@@ -156,11 +246,11 @@ type MgoLatestSwapNonce struct {
 
 // MgoSwapHistory swap history
 type MgoSwapHistory struct {
-	Key      bson.ObjectId `bson:"_id"`
-	IsSwapin bool          `bson:"isswapin"`
-	TxID     string        `bson:"txid"`
-	Bind     string        `bson:"bind"`
-	SwapTx   string        `bson:"swaptx"`
+	Key      primitive.ObjectID `bson:"_id"`
+	IsSwapin bool               `bson:"isswapin"`
+	TxID     string             `bson:"txid"`
+	Bind     string             `bson:"bind"`
+	SwapTx   string             `bson:"swaptx"`
 }
 
 // MgoUsedRValue security enhancement
@@ -2,6 +2,8 @@ package params
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/weijun-sh/gethscan-server/log"
@@ -76,12 +78,77 @@ func checkChainAndGatewayConfig(isServer bool) (err error) {
 
 // CheckConfig check swap server config
 func (c *ServerConfig) CheckConfig() error {
-	if c.MongoDB == nil {
-		return errors.New("server must config 'Server.MongoDB'")
+	switch storageType := c.GetStorageType(); storageType {
+	case StorageMongoDB:
+		if c.MongoDB == nil {
+			return errors.New("server must config 'Server.MongoDB'")
+		}
+	case StorageLevelDB:
+	default:
+		return fmt.Errorf("unknown storage type '%v'", storageType)
 	}
 	if c.APIServer == nil {
 		return errors.New("server must config 'Server.APIServer'")
 	}
+	if err := c.Retention.CheckConfig(); err != nil {
+		return err
+	}
+	return c.checkWebhooks()
+}
+
+// CheckConfig check retention config
+func (c *RetentionConfig) CheckConfig() error {
+	if c == nil {
+		return nil
+	}
+	if c.ArchiveAfterDays < 0 || c.WebhookEventTTLDays < 0 {
+		return errors.New("retention days must not be negative")
+	}
+	switch strings.ToLower(c.ArchiveTo) {
+	case "", ArchiveToCollection, ArchiveToFile:
+	default:
+		return fmt.Errorf("unknown archive target '%v'", c.ArchiveTo)
+	}
+	return nil
+}
+
+// CheckWebhooksConfig check webhooks config
+func CheckWebhooksConfig() error {
+	return GetServerConfig().checkWebhooks()
+}
+
+func (c *ServerConfig) checkWebhooks() error {
+	webhookNames := make(map[string]struct{})
+	for _, webhook := range c.Webhooks {
+		if err := webhook.CheckConfig(); err != nil {
+			return err
+		}
+		if _, exist := webhookNames[webhook.Name]; exist {
+			return fmt.Errorf("duplicate webhook name '%v'", webhook.Name)
+		}
+		webhookNames[webhook.Name] = struct{}{}
+	}
+	return nil
+}
+
+// CheckConfig check webhook config
+func (c *WebhookConfig) CheckConfig() error {
+	if c.Name == "" {
+		return errors.New("webhook must config 'Name'")
+	}
+	if c.URL == "" {
+		return fmt.Errorf("webhook '%v' must config 'URL'", c.Name)
+	}
+	if c.Secret == "" {
+		return fmt.Errorf("webhook '%v' must config 'Secret'", c.Name)
+	}
+	for _, event := range c.Events {
+		switch event {
+		case WebhookEventVerified, WebhookEventPosted, WebhookEventRejected, WebhookEventFailed:
+		default:
+			return fmt.Errorf("webhook '%v' has unknown event '%v'", c.Name, event)
+		}
+	}
 	return nil
 }
 
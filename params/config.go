@@ -2,6 +2,7 @@ package params
 
 import (
 	"encoding/json"
+	"path/filepath"
 	"strings"
 	"sync"
 
@@ -13,6 +14,34 @@ import (
 
 const (
 	defaultAPIPort = 11556
+
+	defaultPostRetryMaxAttempts  = 10
+	defaultPostRetryBaseInterval = 30   // seconds
+	defaultPostRetryMaxInterval  = 3600 // seconds
+
+	defaultWebhookRetryMaxAttempts  = 10
+	defaultWebhookRetryBaseInterval = 10  // seconds
+	defaultWebhookRetryMaxInterval  = 600 // seconds
+
+	defaultPostDispatchWorkers          = 2
+	defaultPostDispatchQueueSize        = 100
+	defaultPostDispatchRateLimit        = 5  // posts per second
+	defaultPostDispatchRateBurst        = 10 // posts
+	defaultPostDispatchBreakerThreshold = 5  // consecutive transient failures
+	defaultPostDispatchBreakerCooldown  = 60 // seconds
+
+	defaultArchiveInterval  = 3600 // seconds
+	defaultArchiveBatchSize = 500
+
+	defaultMaxBatchRegisterItems = 1000
+)
+
+// webhook events of swap registration outcomes
+const (
+	WebhookEventVerified = "verified"
+	WebhookEventPosted   = "posted"
+	WebhookEventRejected = "rejected"
+	WebhookEventFailed   = "failed"
 )
 
 var (
@@ -26,7 +55,7 @@ var (
 	// ServerAPIAddress server api address
 	ServerAPIAddress string
 
-	chainRpc map[string]string = make(map[string]string)
+	chainRpc map[string][]string = make(map[string][]string)
 	chainSupport []string
 )
 
@@ -51,9 +80,121 @@ type blockChainConfig struct {
 
 // ServerConfig swap server config
 type ServerConfig struct {
+	// unique id of instances sharing one database, default is 'hostname:pid'
+	InstanceID string `toml:",omitempty" json:",omitempty"`
+
+	Storage   *StorageConfig   `toml:",omitempty" json:",omitempty"`
 	MongoDB   *MongoDBConfig   `toml:",omitempty" json:",omitempty"`
 	APIServer *APIServerConfig `toml:",omitempty" json:",omitempty"`
+	PostRetry *PostRetryConfig `toml:",omitempty" json:",omitempty"`
+
+	PostDispatch *PostDispatchConfig `toml:",omitempty" json:",omitempty"`
+
+	PostResultRules []*PostResultRule `toml:",omitempty" json:",omitempty"`
+
+	SwapServers []*SwapServerConfig `toml:",omitempty" json:",omitempty"`
 	Admins    []string         `toml:",omitempty" json:",omitempty"`
+
+	Webhooks     []*WebhookConfig `toml:",omitempty" json:",omitempty"`
+	WebhookRetry *PostRetryConfig `toml:",omitempty" json:",omitempty"`
+
+	Retention *RetentionConfig `toml:",omitempty" json:",omitempty"`
+}
+
+// WebhookConfig webhook subscription of swap registration outcomes
+type WebhookConfig struct {
+	Name   string // identify webhook of queued notifications
+	URL    string
+	Secret string   `json:"-"`                          // key of hmac-sha256 signature
+	Events []string `toml:",omitempty" json:",omitempty"` // empty means all events
+
+	// filters, empty matches all
+	Chains   []string `toml:",omitempty" json:",omitempty"`
+	PairIDs  []string `toml:",omitempty" json:",omitempty"` // bridge swaps
+	ChainIDs []string `toml:",omitempty" json:",omitempty"` // router swaps
+}
+
+// IsSubscribed is event of swap subscribed
+func (c *WebhookConfig) IsSubscribed(event, chain, pairID, chainID string) bool {
+	return matchFilter(c.Events, event) &&
+		matchFilter(c.Chains, chain) &&
+		matchFilter(c.PairIDs, pairID) &&
+		matchFilter(c.ChainIDs, chainID)
+}
+
+func matchFilter(filter []string, value string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, item := range filter {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// SwapServerConfig swap server allowed to post swaps to
+type SwapServerConfig struct {
+	Name    string   // manual registration refers swap server by name
+	URL     string
+	Methods []string // allowed rpc methods
+
+	PostDispatch *PostDispatchConfig `toml:",omitempty" json:",omitempty"` // overrides Server.PostDispatch
+}
+
+// IsAllowedMethod is allowed rpc method
+func (c *SwapServerConfig) IsAllowedMethod(method string) bool {
+	for _, m := range c.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// PostResultRule classify swap server response by json-rpc error code and message
+// rules are matched in order, and before the builtin rules
+type PostResultRule struct {
+	Code    int    // json-rpc error code, 0 matches any
+	Keyword string // keyword of error message or router swap status, empty matches any
+	Result  string // one of 'success', 'duplicate', 'rejected', 'transient'
+}
+
+// PostRetryConfig retry config of failed swap posts
+type PostRetryConfig struct {
+	MaxAttempts  int   // swap fails after so many attempts
+	BaseInterval int64 // seconds, doubled after every attempt
+	MaxInterval  int64 // seconds, cap of retry interval
+}
+
+// PostDispatchConfig concurrency, rate limit and circuit breaker of posting to a swap server
+type PostDispatchConfig struct {
+	Workers          int     // concurrent posts
+	QueueSize        int     // queued swaps waiting for workers
+	RateLimit        float64 // posts per second
+	RateBurst        int     // posts allowed at once
+	BreakerThreshold int     // stop posting after so many consecutive transient failures
+	BreakerCooldown  int64   // seconds, try again after cooldown
+}
+
+// archive targets of finished swap records
+const (
+	ArchiveToCollection = "collection"
+	ArchiveToFile       = "file"
+)
+
+// RetentionConfig retention of finished swap records
+type RetentionConfig struct {
+	// finished records registered more than so many days ago are archived, 0 disables archiving
+	ArchiveAfterDays int
+	ArchiveInterval  int64  `toml:",omitempty" json:",omitempty"` // seconds between archive runs
+	ArchiveBatchSize int    `toml:",omitempty" json:",omitempty"` // records moved at once
+	ArchiveTo        string `toml:",omitempty" json:",omitempty"` // 'collection' (default) or 'file'
+	ArchiveDir       string `toml:",omitempty" json:",omitempty"` // dir of export files, default is 'archive' in data dir
+
+	// delivered and failed webhook events are removed after so many days, 0 keeps them (mongodb only)
+	WebhookEventTTLDays int `toml:",omitempty" json:",omitempty"`
 }
 
 // DcrmConfig dcrm related config
@@ -91,6 +232,46 @@ type APIServerConfig struct {
 	AllowedOrigins []string
 	MaxParseRegisteredLimit int
 	MaxRequestsLimit int
+	AsyncRegister bool // register swap returns job at once, and verify and post in background
+	MaxBatchRegisterItems int // maximum number of items of one batch registration request
+}
+
+// storage types of scan server records
+const (
+	StorageMongoDB = "mongodb"
+	StorageLevelDB = "leveldb"
+)
+
+// StorageConfig storage of scan server records
+type StorageConfig struct {
+	Type string // 'mongodb' (default) or 'leveldb'
+
+	// embedded leveldb config, default path is 'scanserver' in data dir
+	Path    string `toml:",omitempty" json:",omitempty"`
+	Cache   int    `toml:",omitempty" json:",omitempty"` // in MB
+	Handles int    `toml:",omitempty" json:",omitempty"`
+}
+
+// GetStorageType get storage type of scan server records
+func (c *ServerConfig) GetStorageType() string {
+	if c.Storage == nil || c.Storage.Type == "" {
+		return StorageMongoDB
+	}
+	return strings.ToLower(c.Storage.Type)
+}
+
+// GetStorageType get storage type of scan server records
+func GetStorageType() string {
+	return GetServerConfig().GetStorageType()
+}
+
+// GetLevelDBPath get path of embedded leveldb storage
+func GetLevelDBPath() string {
+	storage := GetServerConfig().Storage
+	if storage != nil && storage.Path != "" {
+		return storage.Path
+	}
+	return filepath.Join(GetDataDir(), "scanserver")
 }
 
 // MongoDBConfig mongodb config
@@ -236,17 +417,25 @@ func LoadConfig(configFile string, isServer bool) *BridgeConfig {
 func initChain(config *BridgeConfig) {
        blockChain := config.BlockChain
        for _, r := range blockChain.RPC {
+               // format is 'chain,rpc1,rpc2,...'
                slice := strings.Split(r, ",")
-               if len(slice) != 2 {
+               if len(slice) < 2 {
                        log.Fatalf("LoadConfig initChain rpc: %v error", r)
                }
-               chainRpc[slice[0]] = slice[1]
-		chainSupport = append(chainSupport, slice[0])
+               chain := slice[0]
+               if _, exist := chainRpc[chain]; !exist {
+                       chainSupport = append(chainSupport, chain)
+               }
+               for _, url := range slice[1:] {
+                       if url = strings.TrimSpace(url); url != "" {
+                               chainRpc[chain] = append(chainRpc[chain], url)
+                       }
+               }
        }
 }
 
 func CheckChainSupport(eth string) bool {
-       if chainRpc[strings.ToLower(eth)] != "" {
+       if len(chainRpc[strings.ToLower(eth)]) != 0 {
                return true
        }
        return false
@@ -262,6 +451,34 @@ func CheckTxID(txid string) bool {
        return false
 }
 
+// GetSwapServer get configed swap server by name
+func GetSwapServer(name string) *SwapServerConfig {
+	for _, server := range GetServerConfig().SwapServers {
+		if strings.EqualFold(server.Name, name) {
+			return server
+		}
+	}
+	return nil
+}
+
+// IsAllowedSwapServer is allowed to post swap to url with method,
+// swap servers configed in scan tokens config are always allowed.
+func IsAllowedSwapServer(url, method string) bool {
+	for _, server := range GetServerConfig().SwapServers {
+		if strings.EqualFold(server.URL, url) && server.IsAllowedMethod(method) {
+			return true
+		}
+	}
+	for _, tokensConfig := range GetScanTokensConfig() {
+		for _, tokenCfg := range tokensConfig.Tokens {
+			if strings.EqualFold(tokenCfg.SwapServer, url) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // HasAdmin has admin
 func HasAdmin() bool {
 	return len(GetServerConfig().Admins) != 0
@@ -298,8 +515,17 @@ func GetDataDir() string {
 	return locDataDir
 }
 
-// GetChainRpc get chain rpc
+// GetChainRPC get the first rpc of chain
 func GetChainRPC(chain string) string {
+	rpcs := chainRpc[chain]
+	if len(rpcs) == 0 {
+		return ""
+	}
+	return rpcs[0]
+}
+
+// GetChainRPCs get all rpcs of chain
+func GetChainRPCs(chain string) []string {
 	return chainRpc[chain]
 }
 
@@ -308,8 +534,172 @@ func GetChainSupport() []string {
 	return chainSupport
 }
 
+// GetPostResultRules get configed post result rules
+func GetPostResultRules() []*PostResultRule {
+	return GetServerConfig().PostResultRules
+}
+
+// GetWebhook get configed webhook by name
+func GetWebhook(name string) *WebhookConfig {
+	for _, webhook := range GetServerConfig().Webhooks {
+		if webhook.Name == name {
+			return webhook
+		}
+	}
+	return nil
+}
+
+// GetWebhooks get configed webhooks
+func GetWebhooks() []*WebhookConfig {
+	return GetServerConfig().Webhooks
+}
+
+// GetWebhookRetryConfig get webhook delivery retry config (with default values)
+func GetWebhookRetryConfig() *PostRetryConfig {
+	config := &PostRetryConfig{
+		MaxAttempts:  defaultWebhookRetryMaxAttempts,
+		BaseInterval: defaultWebhookRetryBaseInterval,
+		MaxInterval:  defaultWebhookRetryMaxInterval,
+	}
+	return config.merge(GetServerConfig().WebhookRetry)
+}
+
+// GetPostRetryConfig get post retry config (with default values)
+func GetPostRetryConfig() *PostRetryConfig {
+	config := &PostRetryConfig{
+		MaxAttempts:  defaultPostRetryMaxAttempts,
+		BaseInterval: defaultPostRetryBaseInterval,
+		MaxInterval:  defaultPostRetryMaxInterval,
+	}
+	return config.merge(GetServerConfig().PostRetry)
+}
+
+// GetRetryInterval get retry interval (seconds) after so many attempts,
+// doubled after every attempt and capped by MaxInterval.
+func (config *PostRetryConfig) GetRetryInterval(attempts int) int64 {
+	interval := config.MaxInterval
+	if attempts-1 < 32 { // avoid overflow
+		if backoff := config.BaseInterval << uint(attempts-1); backoff > 0 && backoff < interval {
+			interval = backoff
+		}
+	}
+	return interval
+}
+
+// merge configed non zero values into default config
+func (config *PostRetryConfig) merge(retryCfg *PostRetryConfig) *PostRetryConfig {
+	if retryCfg == nil {
+		return config
+	}
+	if retryCfg.MaxAttempts > 0 {
+		config.MaxAttempts = retryCfg.MaxAttempts
+	}
+	if retryCfg.BaseInterval > 0 {
+		config.BaseInterval = retryCfg.BaseInterval
+	}
+	if retryCfg.MaxInterval > 0 {
+		config.MaxInterval = retryCfg.MaxInterval
+	}
+	return config
+}
+
+// GetPostDispatchConfig get post dispatch config of swap server url (with default values),
+// config of swap server in 'Server.SwapServers' overrides config of 'Server.PostDispatch'.
+func GetPostDispatchConfig(url string) *PostDispatchConfig {
+	config := &PostDispatchConfig{
+		Workers:          defaultPostDispatchWorkers,
+		QueueSize:        defaultPostDispatchQueueSize,
+		RateLimit:        defaultPostDispatchRateLimit,
+		RateBurst:        defaultPostDispatchRateBurst,
+		BreakerThreshold: defaultPostDispatchBreakerThreshold,
+		BreakerCooldown:  defaultPostDispatchBreakerCooldown,
+	}
+	serverCfg := GetServerConfig()
+	config.merge(serverCfg.PostDispatch)
+	for _, server := range serverCfg.SwapServers {
+		if strings.EqualFold(server.URL, url) {
+			config.merge(server.PostDispatch)
+			break
+		}
+	}
+	return config
+}
+
+// merge configed non zero values into default config
+func (config *PostDispatchConfig) merge(dispatchCfg *PostDispatchConfig) *PostDispatchConfig {
+	if dispatchCfg == nil {
+		return config
+	}
+	if dispatchCfg.Workers > 0 {
+		config.Workers = dispatchCfg.Workers
+	}
+	if dispatchCfg.QueueSize > 0 {
+		config.QueueSize = dispatchCfg.QueueSize
+	}
+	if dispatchCfg.RateLimit > 0 {
+		config.RateLimit = dispatchCfg.RateLimit
+	}
+	if dispatchCfg.RateBurst > 0 {
+		config.RateBurst = dispatchCfg.RateBurst
+	}
+	if dispatchCfg.BreakerThreshold > 0 {
+		config.BreakerThreshold = dispatchCfg.BreakerThreshold
+	}
+	if dispatchCfg.BreakerCooldown > 0 {
+		config.BreakerCooldown = dispatchCfg.BreakerCooldown
+	}
+	return config
+}
+
+// GetRetentionConfig get retention config of finished swap records (with default values)
+func GetRetentionConfig() *RetentionConfig {
+	config := &RetentionConfig{
+		ArchiveInterval:  defaultArchiveInterval,
+		ArchiveBatchSize: defaultArchiveBatchSize,
+		ArchiveTo:        ArchiveToCollection,
+	}
+	retentionCfg := GetServerConfig().Retention
+	if retentionCfg == nil {
+		return config
+	}
+	config.ArchiveAfterDays = retentionCfg.ArchiveAfterDays
+	config.WebhookEventTTLDays = retentionCfg.WebhookEventTTLDays
+	config.ArchiveDir = retentionCfg.ArchiveDir
+	if retentionCfg.ArchiveInterval > 0 {
+		config.ArchiveInterval = retentionCfg.ArchiveInterval
+	}
+	if retentionCfg.ArchiveBatchSize > 0 {
+		config.ArchiveBatchSize = retentionCfg.ArchiveBatchSize
+	}
+	if retentionCfg.ArchiveTo != "" {
+		config.ArchiveTo = strings.ToLower(retentionCfg.ArchiveTo)
+	}
+	return config
+}
+
+// GetArchiveDir get directory of archive export files
+func (c *RetentionConfig) GetArchiveDir() string {
+	if c.ArchiveDir != "" {
+		return c.ArchiveDir
+	}
+	return filepath.Join(GetDataDir(), "archive")
+}
+
 // GetMaxParseRegisteredLimit get MaxParseRegisteredLimit
 func GetMaxParseRegisteredLimit() int {
 	return GetServerConfig().APIServer.MaxParseRegisteredLimit
 }
 
+// GetMaxBatchRegisterItems get maximum number of items of one batch registration request
+func GetMaxBatchRegisterItems() int {
+	if limit := GetServerConfig().APIServer.MaxBatchRegisterItems; limit > 0 {
+		return limit
+	}
+	return defaultMaxBatchRegisterItems
+}
+
+// IsAsyncRegister is register swap asynchronously by default
+func IsAsyncRegister() bool {
+	return GetServerConfig().APIServer.AsyncRegister
+}
+
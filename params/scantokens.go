@@ -0,0 +1,287 @@
+package params
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/weijun-sh/gethscan-server/common"
+	"github.com/weijun-sh/gethscan-server/log"
+)
+
+// admin changes of tokens config are applied one by one
+var scanTokensUpdateLock sync.Mutex
+
+// GetKey get key to identify token config of a chain
+func (c *TokenConfig) GetKey() string {
+	if c.IsRouterSwap() {
+		return strings.ToLower(fmt.Sprintf("%v:%v:%v:%v", c.TxType, c.ChainID, c.RouterContract, c.SwapServer))
+	}
+	return strings.ToLower(fmt.Sprintf("%v:%v:%v:%v:%v:%v", c.TxType, c.PairID, c.TokenAddress, c.DepositAddress, c.CallByContract, c.SwapServer))
+}
+
+// GetChainTokens get tokens config of chain
+func GetChainTokens(chain string) ([]*TokenConfig, error) {
+	scantoken, exist := GetScanTokensConfig()[strings.ToLower(chain)]
+	if !exist {
+		return nil, fmt.Errorf("chain '%v' not found", chain)
+	}
+	return scantoken.Tokens, nil
+}
+
+// AddChainToken add token config to chain
+func AddChainToken(chain string, tokenCfg *TokenConfig) ([]*TokenConfig, error) {
+	return updateChainTokens(chain, func(tokens []*TokenConfig) ([]*TokenConfig, error) {
+		if err := tokenCfg.CheckConfig(); err != nil {
+			return nil, err
+		}
+		key := tokenCfg.GetKey()
+		if findToken(tokens, key) >= 0 {
+			return nil, fmt.Errorf("token config '%v' already exist", key)
+		}
+		return append(tokens, tokenCfg), nil
+	})
+}
+
+// SetChainTokenDisabled disable or enable token config of chain
+func SetChainTokenDisabled(chain, key string, disabled bool) ([]*TokenConfig, error) {
+	return updateChainTokens(chain, func(tokens []*TokenConfig) ([]*TokenConfig, error) {
+		index := findToken(tokens, key)
+		if index < 0 {
+			return nil, fmt.Errorf("token config '%v' not found", key)
+		}
+		tokens[index].Disabled = disabled
+		return tokens, nil
+	})
+}
+
+// RemoveChainToken remove token config from chain
+func RemoveChainToken(chain, key string) ([]*TokenConfig, error) {
+	return updateChainTokens(chain, func(tokens []*TokenConfig) ([]*TokenConfig, error) {
+		index := findToken(tokens, key)
+		if index < 0 {
+			return nil, fmt.Errorf("token config '%v' not found", key)
+		}
+		return append(tokens[:index], tokens[index+1:]...), nil
+	})
+}
+
+func findToken(tokens []*TokenConfig, key string) int {
+	for i, tokenCfg := range tokens {
+		if tokenCfg.GetKey() == strings.ToLower(key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// updateChainTokens change a copy of tokens config of chain,
+// the changed config is checked and saved to admin tokens file before taking effect.
+func updateChainTokens(chain string, update func([]*TokenConfig) ([]*TokenConfig, error)) ([]*TokenConfig, error) {
+	scanTokensUpdateLock.Lock()
+	defer scanTokensUpdateLock.Unlock()
+
+	chain = strings.ToLower(chain)
+	current := GetScanTokensConfig()
+	scantoken, exist := current[chain]
+	if !exist {
+		return nil, fmt.Errorf("chain '%v' not found", chain)
+	}
+
+	tokens := make([]*TokenConfig, 0, len(scantoken.Tokens))
+	for _, tokenCfg := range scantoken.Tokens {
+		tokens = append(tokens, tokenCfg.clone())
+	}
+	tokens, err := update(tokens)
+	if err != nil {
+		return nil, err
+	}
+	if err = (&ScanConfig{Tokens: tokens}).CheckConfig(); err != nil {
+		return nil, err
+	}
+	if err = scantoken.BlockChain.CheckTokens(tokens); err != nil {
+		return nil, err
+	}
+
+	newConfig := &ScanTokensConfig{
+		MongoDB:    scantoken.MongoDB,
+		BlockChain: scantoken.BlockChain,
+		Tokens:     tokens,
+		filePath:   scantoken.filePath,
+		fileTokens: scantoken.fileTokens,
+	}
+	if err = saveAdminTokensFile(newConfig); err != nil {
+		return nil, err
+	}
+
+	tokensConfig := make(map[string]*ScanTokensConfig, len(current))
+	for key, val := range current {
+		tokensConfig[key] = val
+	}
+	tokensConfig[chain] = newConfig
+	SetScanTokensConfig(tokensConfig)
+	return tokens, nil
+}
+
+func (c *TokenConfig) clone() *TokenConfig {
+	tokenCfg := *c
+	tokenCfg.Whitelist = append([]string(nil), c.Whitelist...)
+	return &tokenCfg
+}
+
+// adminTokensFileSuffix admin tokens file is named as config file with this suffix
+const adminTokensFileSuffix = ".admin"
+
+// adminTokensConfig token changes made by admin, saved apart from the config file
+// maintained by operators, and applied over the tokens in the config file when loaded.
+type adminTokensConfig struct {
+	Removed  []string       `toml:",omitempty"` // keys of removed tokens of config file
+	Disabled []string       `toml:",omitempty"` // keys of disabled tokens of config file
+	Enabled  []string       `toml:",omitempty"` // keys of enabled tokens of config file
+	Added    []*TokenConfig `toml:",omitempty"`
+}
+
+func (c *adminTokensConfig) isEmpty() bool {
+	return len(c.Removed) == 0 && len(c.Disabled) == 0 && len(c.Enabled) == 0 && len(c.Added) == 0
+}
+
+// IsScanTokensConfigFile is config file or admin tokens file in token pairs directory
+func IsScanTokensConfigFile(fileName string) bool {
+	return strings.HasSuffix(fileName, ".toml") || strings.HasSuffix(fileName, ".toml"+adminTokensFileSuffix)
+}
+
+func getAdminTokensFile(filePath string) string {
+	return filePath + adminTokensFileSuffix
+}
+
+// loadAdminTokensFile apply admin tokens file (if exist) to the checked tokens of config file
+func loadAdminTokensFile(filePath string, fileTokens []*TokenConfig) ([]*TokenConfig, error) {
+	adminFile := getAdminTokensFile(filePath)
+	if !common.FileExist(adminFile) {
+		return fileTokens, nil
+	}
+	adminTokens := &adminTokensConfig{}
+	if _, err := toml.DecodeFile(adminFile, adminTokens); err != nil {
+		return nil, fmt.Errorf("toml DecodeFile '%v' failed: %w", adminFile, err)
+	}
+	for _, tokenCfg := range adminTokens.Added {
+		if err := tokenCfg.CheckConfig(); err != nil {
+			return nil, fmt.Errorf("check admin tokens file '%v' failed: %w", adminFile, err)
+		}
+	}
+	log.Info("load admin tokens file", "file", adminFile, "removed", len(adminTokens.Removed), "disabled", len(adminTokens.Disabled), "enabled", len(adminTokens.Enabled), "added", len(adminTokens.Added))
+	return applyAdminTokens(fileTokens, adminTokens), nil
+}
+
+// applyAdminTokens apply admin changes to copy of tokens of config file.
+// changes of tokens no longer in config file are ignored,
+// added tokens which are also in config file now are ignored.
+func applyAdminTokens(fileTokens []*TokenConfig, adminTokens *adminTokensConfig) []*TokenConfig {
+	removed := toKeySet(adminTokens.Removed)
+	disabled := toKeySet(adminTokens.Disabled)
+	enabled := toKeySet(adminTokens.Enabled)
+	tokens := make([]*TokenConfig, 0, len(fileTokens)+len(adminTokens.Added))
+	exist := make(map[string]struct{}, cap(tokens))
+	for _, tokenCfg := range fileTokens {
+		key := tokenCfg.GetKey()
+		exist[key] = struct{}{}
+		if _, ok := removed[key]; ok {
+			continue
+		}
+		tokenCfg = tokenCfg.clone()
+		if _, ok := disabled[key]; ok {
+			tokenCfg.Disabled = true
+		}
+		if _, ok := enabled[key]; ok {
+			tokenCfg.Disabled = false
+		}
+		tokens = append(tokens, tokenCfg)
+	}
+	for _, tokenCfg := range adminTokens.Added {
+		key := tokenCfg.GetKey()
+		if _, ok := exist[key]; ok {
+			log.Info("ignore admin added token which is in config file", "key", key)
+			continue
+		}
+		exist[key] = struct{}{}
+		tokens = append(tokens, tokenCfg.clone())
+	}
+	return tokens
+}
+
+// diffAdminTokens get admin changes from tokens of config file to tokens
+func diffAdminTokens(fileTokens, tokens []*TokenConfig) *adminTokensConfig {
+	adminTokens := &adminTokensConfig{}
+	fileTokensMap := make(map[string]*TokenConfig, len(fileTokens))
+	for _, tokenCfg := range fileTokens {
+		fileTokensMap[tokenCfg.GetKey()] = tokenCfg
+	}
+	exist := make(map[string]struct{}, len(tokens))
+	for _, tokenCfg := range tokens {
+		key := tokenCfg.GetKey()
+		exist[key] = struct{}{}
+		fileToken, ok := fileTokensMap[key]
+		switch {
+		case !ok:
+			adminTokens.Added = append(adminTokens.Added, tokenCfg)
+		case tokenCfg.Disabled && !fileToken.Disabled:
+			adminTokens.Disabled = append(adminTokens.Disabled, key)
+		case !tokenCfg.Disabled && fileToken.Disabled:
+			adminTokens.Enabled = append(adminTokens.Enabled, key)
+		}
+	}
+	for _, tokenCfg := range fileTokens {
+		key := tokenCfg.GetKey()
+		if _, ok := exist[key]; !ok {
+			adminTokens.Removed = append(adminTokens.Removed, key)
+		}
+	}
+	return adminTokens
+}
+
+func toKeySet(keys []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		set[strings.ToLower(key)] = struct{}{}
+	}
+	return set
+}
+
+// saveAdminTokensFile save admin changes of tokens to admin tokens file,
+// the config file maintained by operators is never changed.
+// write to a temp file and then rename it, so the file is never seen partially written.
+func saveAdminTokensFile(config *ScanTokensConfig) error {
+	if config.filePath == "" {
+		return fmt.Errorf("unknown config file of chain '%v'", config.BlockChain.Chain)
+	}
+	adminFile := getAdminTokensFile(config.filePath)
+	adminTokens := diffAdminTokens(config.fileTokens, config.Tokens)
+	if adminTokens.isEmpty() {
+		if err := os.Remove(adminFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		log.Info("remove admin tokens file as no change of config file", "chain", config.BlockChain.Chain, "file", adminFile)
+		return nil
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# token changes made by admin, applied over tokens in '%v'\n", filepath.Base(config.filePath))
+	fmt.Fprintf(&buf, "# delete this file to revert them\n\n")
+	if err := toml.NewEncoder(&buf).Encode(adminTokens); err != nil {
+		return fmt.Errorf("toml encode failed: %w", err)
+	}
+	tmpFile := adminFile + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile, adminFile); err != nil {
+		_ = os.Remove(tmpFile)
+		return err
+	}
+	log.Info("save admin tokens file success", "chain", config.BlockChain.Chain, "file", adminFile, "tokens", len(config.Tokens))
+	return nil
+}
@@ -0,0 +1,99 @@
+package params
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testScanTokensConfig = `# operator comment
+[MongoDB]
+DBURL = "localhost:27017"
+
+[BlockChain]
+Chain = "fake"
+
+# bridge swapin
+[[Tokens]]
+TxType = "swapin"
+PairID = "usdc"
+TokenAddress = "0x1111111111111111111111111111111111111111"
+DepositAddress = "0x2222222222222222222222222222222222222222"
+SwapServer = "http://127.0.0.1:11556/rpc"
+
+[[Tokens]]
+TxType = "swapout"
+PairID = "usdc"
+TokenAddress = "0x1111111111111111111111111111111111111111"
+SwapServer = "http://127.0.0.1:11556/rpc"
+`
+
+func loadTestScanTokensConfig(t *testing.T, dir string) {
+	tokensConfig, err := LoadScanTokensConfigInDir(dir, true)
+	if err != nil {
+		t.Fatalf("load scan tokens config failed: %v", err)
+	}
+	SetScanTokensConfig(tokensConfig)
+}
+
+func TestAdminTokensFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scantokens")
+	if err != nil {
+		t.Fatalf("create temp dir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	oldConfig := GetScanTokensConfig()
+	defer SetScanTokensConfig(oldConfig)
+
+	configFile := filepath.Join(dir, "fake.toml")
+	if err = ioutil.WriteFile(configFile, []byte(testScanTokensConfig), 0644); err != nil {
+		t.Fatalf("write config file failed: %v", err)
+	}
+	loadTestScanTokensConfig(t, dir)
+	fileTokens, _ := GetChainTokens("fake")
+
+	routerToken := &TokenConfig{TxType: TxRouterERC20Swap, ChainID: "56", RouterContract: "0x3333333333333333333333333333333333333333", SwapServer: "http://127.0.0.1:11556/rpc"}
+	if _, err = AddChainToken("fake", routerToken); err != nil {
+		t.Fatalf("add chain token failed: %v", err)
+	}
+	if _, err = SetChainTokenDisabled("fake", fileTokens[0].GetKey(), true); err != nil {
+		t.Fatalf("disable chain token failed: %v", err)
+	}
+	tokens, err := RemoveChainToken("fake", fileTokens[1].GetKey())
+	if err != nil {
+		t.Fatalf("remove chain token failed: %v", err)
+	}
+
+	content, _ := ioutil.ReadFile(configFile)
+	if string(content) != testScanTokensConfig {
+		t.Errorf("config file is changed by admin:\n%s", content)
+	}
+	if _, err = os.Stat(getAdminTokensFile(configFile)); err != nil {
+		t.Errorf("admin tokens file is not saved: %v", err)
+	}
+
+	loadTestScanTokensConfig(t, dir)
+	reloaded, _ := GetChainTokens("fake")
+	if !reflect.DeepEqual(reloaded, tokens) {
+		t.Errorf("reloaded tokens mismatch, have %v, want %v", reloaded, tokens)
+	}
+	if len(reloaded) != 2 || !reloaded[0].Disabled || reloaded[1].GetKey() != routerToken.GetKey() {
+		t.Errorf("reloaded tokens mismatch: %v", reloaded)
+	}
+
+	// revert all admin changes, admin tokens file is removed
+	if _, err = SetChainTokenDisabled("fake", fileTokens[0].GetKey(), false); err != nil {
+		t.Fatalf("enable chain token failed: %v", err)
+	}
+	if _, err = RemoveChainToken("fake", routerToken.GetKey()); err != nil {
+		t.Fatalf("remove chain token failed: %v", err)
+	}
+	if _, err = AddChainToken("fake", fileTokens[1]); err != nil {
+		t.Fatalf("add chain token failed: %v", err)
+	}
+	if _, err = os.Stat(getAdminTokensFile(configFile)); !os.IsNotExist(err) {
+		t.Errorf("admin tokens file without changes is not removed: %v", err)
+	}
+}
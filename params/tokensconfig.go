@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/BurntSushi/toml"
 	"github.com/weijun-sh/gethscan-server/common"
@@ -26,6 +27,7 @@ const (
 var (
 	configFile string
 	scanTokensConfig map[string]*ScanTokensConfig = make(map[string]*ScanTokensConfig)
+	scanTokensConfigLock sync.RWMutex
 	scanConfig = &ScanConfig{}
 	mongodbConfig = &MongoDBConfig{}
 	blockchainConfig = &BlockChainConfig{}
@@ -35,11 +37,23 @@ type ScanTokensConfig struct {
 	MongoDB *MongoDBConfig
 	BlockChain *BlockChainConfig
 	Tokens  []*TokenConfig
+
+	filePath   string         // config file, tokens changed by admin are saved apart from it
+	fileTokens []*TokenConfig // tokens in config file, before admin changes applied
 }
 
 type BlockChainConfig struct {
 	Chain string
+	// Confirmations post swap after its tx block has so many confirmations
+	Confirmations uint64
+	// EnableScan scan new blocks to register swaps automatically
+	EnableScan bool
+	// SyncNumber start scanning from latest - SyncNumber if never scanned
 	SyncNumber uint64
+	// ScanLogs scan by eth_getLogs over block ranges instead of every block
+	ScanLogs bool
+	// MaxLogsRange maximum blocks range of one eth_getLogs call
+	MaxLogsRange uint64
 }
 
 // ScanConfig scan config
@@ -63,6 +77,9 @@ type TokenConfig struct {
 	// router
 	ChainID        string `toml:",omitempty" json:",omitempty"`
 	RouterContract string `toml:",omitempty" json:",omitempty"`
+
+	// Disabled do not scan and register swaps of this token
+	Disabled bool `toml:",omitempty" json:",omitempty"`
 }
 
 // GetMongodbConfig get mongodb config
@@ -82,20 +99,58 @@ func (c *TokenConfig) IsNativeToken() bool {
 
 // GetScanConfig get scan config
 func GetScanTokensConfig() map[string]*ScanTokensConfig {
+	scanTokensConfigLock.RLock()
+	defer scanTokensConfigLock.RUnlock()
 	return scanTokensConfig
 }
 
 // LoadConfig load config
 func LoadScanTokensConfig(filePath string) *ScanTokensConfig {
+	config, err := loadScanTokensConfigFile(filePath)
+	if err != nil {
+		log.Fatalf("LoadConfig error: %v", err)
+	}
+
+	mongodbConfig = config.MongoDB
+	blockchainConfig = config.BlockChain
+	scanConfig.Tokens = config.Tokens
+
+	configFile = filePath // init config file path
+	return config
+}
+
+// loadScanTokensConfigFile load and check tokens config file
+func loadScanTokensConfigFile(filePath string) (*ScanTokensConfig, error) {
 	log.Println("LoadConfig TokenConfig file is", filePath)
 	if !common.FileExist(filePath) {
-		log.Fatalf("LoadConfig error: config file '%v' not exist", filePath)
+		return nil, fmt.Errorf("config file '%v' not exist", filePath)
 	}
 
 	config := &ScanTokensConfig{}
 	if _, err := toml.DecodeFile(filePath, &config); err != nil {
-		log.Fatalf("LoadConfig error (toml DecodeFile): %v", err)
+		return nil, fmt.Errorf("toml DecodeFile '%v' failed: %w", filePath, err)
 	}
+	if config.BlockChain == nil || config.BlockChain.Chain == "" {
+		return nil, fmt.Errorf("config file '%v' has no 'BlockChain.Chain'", filePath)
+	}
+	for _, tokenCfg := range config.Tokens {
+		if err := tokenCfg.CheckConfig(); err != nil {
+			return nil, fmt.Errorf("check config file '%v' failed: %w", filePath, err)
+		}
+	}
+	tokens, err := loadAdminTokensFile(filePath, config.Tokens)
+	if err != nil {
+		return nil, err
+	}
+	if err := (&ScanConfig{Tokens: tokens}).CheckConfig(); err != nil {
+		return nil, fmt.Errorf("check config file '%v' failed: %w", filePath, err)
+	}
+	if err := config.BlockChain.CheckTokens(tokens); err != nil {
+		return nil, fmt.Errorf("check config file '%v' failed: %w", filePath, err)
+	}
+	config.filePath = filePath
+	config.fileTokens = config.Tokens
+	config.Tokens = tokens
 
 	//var bs []byte
 	//if log.JSONFormat {
@@ -105,38 +160,25 @@ func LoadScanTokensConfig(filePath string) *ScanTokensConfig {
 	//}
 	//log.Println("LoadConfig finished.", string(bs))
 
-       mongodbConfig = config.MongoDB
-	blockchainConfig = config.BlockChain
-       scanConfig.Tokens = config.Tokens
-
-       if err := scanConfig.CheckConfig(); err != nil {
-		log.Fatalf("LoadConfig Check config failed. %v", err)
-	}
-
-	configFile = filePath // init config file path
-	return config
+	return config, nil
 }
 
-// ReloadConfig reload config
-func ReloadConfig() {
-	log.Println("ReloadConfig TokenConfig file is", configFile)
-	if !common.FileExist(configFile) {
-		log.Errorf("ReloadConfig error: config file '%v' not exist", configFile)
-		return
-	}
-
-	config := &ScanTokensConfig{}
-	if _, err := toml.DecodeFile(configFile, &config); err != nil {
-		log.Errorf("ReloadConfig error (toml DecodeFile): %v", err)
-		return
-	}
-
-       scanConfig.Tokens = config.Tokens
-       if err := scanConfig.CheckConfig(); err != nil {
-		log.Errorf("ReloadConfig Check config failed. %v", err)
-		return
+// ReloadConfig reload and check tokens config in token pairs directory.
+// the current config is not changed, call SetScanTokensConfig to apply it.
+func ReloadConfig() (map[string]*ScanTokensConfig, error) {
+	log.Println("ReloadConfig token pairs directory is", tokenPairsConfigDirectory)
+	tokensConfig, err := LoadScanTokensConfigInDir(tokenPairsConfigDirectory, true)
+	if err != nil {
+		log.Errorf("ReloadConfig failed. %v", err)
+		return nil, err
 	}
 	log.Println("ReloadConfig success.")
+	return tokensConfig, nil
+}
+
+// GetTokenPairsDir get token pairs directory
+func GetTokenPairsDir() string {
+	return tokenPairsConfigDirectory
 }
 
 // CheckConfig check scan config
@@ -179,6 +221,20 @@ func (c *ScanConfig) CheckConfig() (err error) {
 	return nil
 }
 
+// CheckTokens check tokens config can be scanned in the scan mode of chain.
+// native token swapins have no log and can not be found by eth_getLogs.
+func (c *BlockChainConfig) CheckTokens(tokens []*TokenConfig) error {
+	if !c.ScanLogs {
+		return nil
+	}
+	for _, tokenCfg := range tokens {
+		if tokenCfg.IsNativeToken() {
+			return fmt.Errorf("native token '%v' can not be scanned with 'ScanLogs'", tokenCfg.PairID)
+		}
+	}
+	return nil
+}
+
 // IsValidSwapType is valid swap type
 func (c *TokenConfig) IsValidSwapType() bool {
 	switch c.TxType {
@@ -316,13 +372,18 @@ func LoadScanTokensConfigInDir(dir string, check bool) (map[string]*ScanTokensCo
                         continue
                 }
                 fileName := info.Name()
+                if strings.HasSuffix(fileName, ".toml"+adminTokensFileSuffix) {
+                        continue // loaded with its config file
+                }
                 if !strings.HasSuffix(fileName, ".toml") {
                         log.Info("ignore not *.toml file", "file", fileName)
                         continue
                 }
-                var tokenConfig *ScanTokensConfig
                 filePath := common.AbsolutePath(dir, fileName)
-                tokenConfig = LoadScanTokensConfig(filePath)
+                tokenConfig, err := loadScanTokensConfigFile(filePath)
+                if err != nil {
+                        return nil, err
+                }
                 // use all small case to identify
                 chain := strings.ToLower(tokenConfig.BlockChain.Chain)
                 // check duplicate chain
@@ -331,16 +392,16 @@ func LoadScanTokensConfigInDir(dir string, check bool) (map[string]*ScanTokensCo
                 }
                 tokensConfig[chain] = tokenConfig
         }
-        if check {
-		if err := scanConfig.CheckConfig(); err != nil {
-			log.Fatalf("LoadConfig Check config failed. %v", err)
-		}
-	}
+        if check && len(tokensConfig) == 0 {
+                return nil, fmt.Errorf("no tokens config in directory '%v'", dir)
+        }
         return tokensConfig, nil
 }
 
 // SetScanTokensConfig set tokens config
 func SetScanTokensConfig(tokensConfig map[string]*ScanTokensConfig) {
+        scanTokensConfigLock.Lock()
+        defer scanTokensConfigLock.Unlock()
         scanTokensConfig = tokensConfig
 }
 
@@ -2,6 +2,7 @@ package client
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -80,6 +81,15 @@ func (err *jsonError) Error() string {
 	return fmt.Sprintf("json-rpc error %d, %s", err.Code, err.Message)
 }
 
+// GetJSONRPCError get code and message of json-rpc error returned by server
+func GetJSONRPCError(err error) (code int, message string, ok bool) {
+	var jsonErr *jsonError
+	if errors.As(err, &jsonErr) {
+		return jsonErr.Code, jsonErr.Message, true
+	}
+	return 0, "", false
+}
+
 type jsonrpcResponse struct {
 	Version string          `json:"jsonrpc,omitempty"`
 	ID      json.RawMessage `json:"id,omitempty"`
@@ -4,8 +4,12 @@ package restapi
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"strings"
 
+	"github.com/weijun-sh/gethscan-server/admin"
 	"github.com/weijun-sh/gethscan-server/common"
 	"github.com/weijun-sh/gethscan-server/internal/swapapi"
 	"github.com/weijun-sh/gethscan-server/log"
@@ -53,15 +57,29 @@ type helpInfo struct {
 	Help string
 	Version string
 	Register string
+	RegisterBatch string
+	Job string
 	Status string
+	PostResult string
+	Address string
+	Metrics string
+	Health string
+	Ready string
 }
 
 func GetHelp() *helpInfo {
 	return &helpInfo{
 		Help:"/help, method(GET)",
 		Version:"/versioninfo, method(GET)",
-		Register:"/swap/register/{chainid}/{txhash}, method(POST)",
+		Register:"/swap/register/{chainid}/{txhash}?async=, method(POST)",
+		RegisterBatch:"/swap/register/batch, method(POST), body [{\"chain\":\"{chainid}\",\"txid\":\"{txhash}\"},...]",
+		Job:"/swap/job/{jobid}, method(GET)",
 		Status:"/swap/status/{txhash}, method(GET)",
+		PostResult:"/swap/postresult/{success|duplicate|rejected|transient}?chain=&offset=&limit=, method(GET)",
+		Address:"/swap/address/{address}?offset=&limit=, method(GET)",
+		Metrics:"/metrics, method(GET)",
+		Health:"/health, method(GET)",
+		Ready:"/ready, method(GET)",
 	}
 }
 
@@ -71,6 +89,29 @@ func HelpHandler(w http.ResponseWriter, r *http.Request) {
 	writeResponse(w, res, nil)
 }
 
+// HealthHandler handler, always respond status 200 with health details
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	res := swapapi.GetHealth()
+	writeResponse(w, res, nil)
+}
+
+// ReadyHandler handler, respond status 503 if not ready (eg. for load balancer to drain)
+func ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	res := swapapi.GetHealth()
+	if res.Ready {
+		writeResponse(w, res, nil)
+		return
+	}
+	jsonData, err := json.Marshal(res)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_, _ = w.Write(jsonData)
+}
+
 // ServerInfoHandler handler
 func ServerInfoHandler(w http.ResponseWriter, r *http.Request) {
 	res, err := swapapi.GetServerInfo()
@@ -239,11 +280,27 @@ func SwapoutHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func isAsyncRegister(r *http.Request) bool {
+	switch r.URL.Query().Get("async") {
+	case "true":
+		return true
+	case "false":
+		return false
+	default:
+		return params.IsAsyncRegister()
+	}
+}
+
 // RegisterSwapHandler handler
 func RegisterSwapHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	chain := vars["chainid"]
 	txid := vars["txid"]
+	if isAsyncRegister(r) {
+		res, err := swapapi.RegisterSwapAsync(chain, txid)
+		writeResponse(w, res, err)
+		return
+	}
 	err := swapapi.BuildRegisterSwap(chain, txid)
 	if err == nil {
 		log.Info("[api] RegisterSwapHandler success", "chain", chain, "txid", txid)
@@ -256,6 +313,28 @@ func RegisterSwapHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// RegisterSwapBatchHandler handler, request body is json array of items like {"chain":"","txid":""}
+func RegisterSwapBatchHandler(w http.ResponseWriter, r *http.Request) {
+	const maxItemLength = 256
+	maxLength := int64(params.GetMaxBatchRegisterItems()) * maxItemLength
+	var items []*swapapi.RegisterItem
+	err := json.NewDecoder(io.LimitReader(r.Body, maxLength)).Decode(&items)
+	if err != nil {
+		writeResponse(w, nil, fmt.Errorf("wrong batch request body, %w", err))
+		return
+	}
+	res, err := swapapi.RegisterSwapBatch(items)
+	writeResponse(w, res, err)
+}
+
+// RegisterJobHandler handler
+func RegisterJobHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobID := vars["jobid"]
+	res, err := swapapi.GetRegisterJob(jobID)
+	writeResponse(w, res, err)
+}
+
 // SwapStatusHandler handler
 func SwapStatusHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -264,28 +343,84 @@ func SwapStatusHandler(w http.ResponseWriter, r *http.Request) {
 	writeResponse(w, res, err)
 }
 
-// RegisterSwapPostHandler handler
+// RegisteredSwapsByPostResultHandler handler
+func RegisteredSwapsByPostResultHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := getHistoryParams(r)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	vars := mux.Vars(r)
+	postResult := vars["postresult"]
+	chain := r.URL.Query().Get("chain")
+	res, err := swapapi.GetRegisteredSwapsByPostResult(chain, postResult, p.offset, p.limit)
+	writeResponse(w, res, err)
+}
+
+// RegisteredSwapsByAddressHandler handler, find router swaps by sender or recipient
+func RegisteredSwapsByAddressHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := getHistoryParams(r)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	res, err := swapapi.GetRegisteredSwapsByAddress(p.address, p.offset, p.limit)
+	writeResponse(w, res, err)
+}
+
+// verifyAdminRequest verify request body is admin call of method with params
+func verifyAdminRequest(r *http.Request, method string, params ...string) error {
+	const maxAdminRequestLength = 64 * 1024
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxAdminRequestLength))
+	if err != nil {
+		return err
+	}
+	rawTx := strings.Trim(strings.TrimSpace(string(body)), "\"")
+	args, err := admin.VerifyAdminCall(rawTx)
+	if err != nil {
+		return err
+	}
+	if args.Method != method {
+		return fmt.Errorf("admin call method mismatch, have '%v' want '%v'", args.Method, method)
+	}
+	if len(args.Params) != len(params) {
+		return fmt.Errorf("admin call params mismatch, have %v want %v", args.Params, params)
+	}
+	for i, param := range params {
+		if !strings.EqualFold(args.Params[i], param) {
+			return fmt.Errorf("admin call params mismatch, have %v want %v", args.Params, params)
+		}
+	}
+	return nil
+}
+
+// RegisterSwapPostHandler handler, request body is admin signed 'registerswap' call
 func RegisterSwapPostHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	pairid := vars["pairid"]
 	txid := vars["txid"]
 	method := vars["method"]
 	swapServer := vars["swapserver"]
-	//chain := vars["chain"]
+	if err := verifyAdminRequest(r, "registerswap", method, pairid, txid, swapServer); err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
 	res, err := swapapi.RegisterSwap("", method, pairid, txid, swapServer)
 	writeResponse(w, res, err)
 }
 
-// RegisterSwapRouterHandler handler
+// RegisterSwapRouterHandler handler, request body is admin signed 'registerrouterswap' call
 func RegisterSwapRouterHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Printf("RegisterSwapRouterHandler, r: %v\n", r)
 	vars := mux.Vars(r)
 	chainid := vars["chainid"]
 	txid := vars["txid"]
 	logindex := vars["logindex"]
 	method := vars["method"]
 	swapServer := vars["swapserver"]
-	//chain := vars["chain"]
+	if err := verifyAdminRequest(r, "registerrouterswap", method, chainid, txid, logindex, swapServer); err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
 	res, err := swapapi.RegisterSwapRouter("", method, chainid, txid, logindex, swapServer)
 	writeResponse(w, res, err)
 }
@@ -1,15 +1,18 @@
 package rpcapi
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/weijun-sh/gethscan-server/admin"
 	"github.com/weijun-sh/gethscan-server/common"
+	"github.com/weijun-sh/gethscan-server/internal/swapapi"
 	"github.com/weijun-sh/gethscan-server/mongodb"
 	"github.com/weijun-sh/gethscan-server/params"
 	"github.com/weijun-sh/gethscan-server/tokens"
+	"github.com/weijun-sh/gethscan-server/tokens/eth"
 	"github.com/weijun-sh/gethscan-server/worker"
 )
 
@@ -25,20 +28,10 @@ const (
 
 // AdminCall admin call
 func (s *RPCAPI) AdminCall(r *http.Request, rawTx, result *string) (err error) {
-	if !params.HasAdmin() {
-		return fmt.Errorf("no admin is configed")
-	}
-	tx, err := admin.DecodeTransaction(*rawTx)
+	args, err := admin.VerifyAdminCall(*rawTx)
 	if err != nil {
 		return err
 	}
-	sender, args, err := admin.VerifyTransaction(tx)
-	if err != nil {
-		return err
-	}
-	if !params.IsAdmin(sender.String()) {
-		return fmt.Errorf("sender %v is not admin", sender.String())
-	}
 	return doCall(args, result)
 }
 
@@ -62,6 +55,14 @@ func doCall(args *admin.CallArgs, result *string) error {
 		return setnonce(args, result)
 	case "addpair":
 		return addpair(args, result)
+	case "registerswap":
+		return registerswap(args, result)
+	case "registerrouterswap":
+		return registerrouterswap(args, result)
+	case "scantokens":
+		return scantokens(args, result)
+	case "archive":
+		return archive(args, result)
 	default:
 		return fmt.Errorf("unknown admin method '%v'", args.Method)
 	}
@@ -354,3 +355,132 @@ func addpair(args *admin.CallArgs, result *string) (err error) {
 	*result = successReuslt
 	return nil
 }
+
+func registerswap(args *admin.CallArgs, result *string) (err error) {
+	if len(args.Params) != 4 {
+		return fmt.Errorf("wrong number of params, have %v want 4", len(args.Params))
+	}
+	method := args.Params[0]
+	pairID := args.Params[1]
+	txid := args.Params[2]
+	swapServer := args.Params[3]
+	_, err = swapapi.RegisterSwap("", method, pairID, txid, swapServer)
+	if err != nil {
+		return err
+	}
+	*result = successReuslt
+	return nil
+}
+
+func registerrouterswap(args *admin.CallArgs, result *string) (err error) {
+	if len(args.Params) != 5 {
+		return fmt.Errorf("wrong number of params, have %v want 5", len(args.Params))
+	}
+	method := args.Params[0]
+	chainID := args.Params[1]
+	txid := args.Params[2]
+	logIndex := args.Params[3]
+	swapServer := args.Params[4]
+	_, err = swapapi.RegisterSwapRouter("", method, chainID, txid, logIndex, swapServer)
+	if err != nil {
+		return err
+	}
+	*result = successReuslt
+	return nil
+}
+
+// scantokens manage scan tokens config of chain
+// operations: list, add (token config in json), enable, disable, remove (token key)
+func scantokens(args *admin.CallArgs, result *string) (err error) {
+	if len(args.Params) < 2 {
+		return fmt.Errorf("wrong number of params, have %v want at least 2", len(args.Params))
+	}
+	operation := args.Params[0]
+	chain := args.Params[1]
+	if operation == "list" {
+		if len(args.Params) != 2 {
+			return fmt.Errorf("wrong number of params, have %v want 2", len(args.Params))
+		}
+		return listScanTokens(chain, result)
+	}
+	if len(args.Params) != 3 {
+		return fmt.Errorf("wrong number of params, have %v want 3", len(args.Params))
+	}
+	var tokenConfigs []*params.TokenConfig
+	switch operation {
+	case "add":
+		tokenCfg := &params.TokenConfig{}
+		if err = json.Unmarshal([]byte(args.Params[2]), tokenCfg); err != nil {
+			return fmt.Errorf("wrong token config, %w", err)
+		}
+		tokenConfigs, err = params.AddChainToken(chain, tokenCfg)
+	case "enable":
+		tokenConfigs, err = params.SetChainTokenDisabled(chain, args.Params[2], false)
+	case "disable":
+		tokenConfigs, err = params.SetChainTokenDisabled(chain, args.Params[2], true)
+	case "remove":
+		tokenConfigs, err = params.RemoveChainToken(chain, args.Params[2])
+	default:
+		return fmt.Errorf("unknown operation '%v'", operation)
+	}
+	if err != nil {
+		return err
+	}
+	err = eth.SetChainTokens(chain, tokenConfigs)
+	if err != nil {
+		return err
+	}
+	*result = successReuslt
+	return nil
+}
+
+func listScanTokens(chain string, result *string) error {
+	tokenConfigs, err := params.GetChainTokens(chain)
+	if err != nil {
+		return err
+	}
+	list := make(map[string]*params.TokenConfig, len(tokenConfigs))
+	for _, tokenCfg := range tokenConfigs {
+		list[tokenCfg.GetKey()] = tokenCfg
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	*result = string(data)
+	return nil
+}
+
+// archive manage archived swaps by txid or time range 'from,to'
+// operations: purge, restore (from archive table, or from export file if given)
+func archive(args *admin.CallArgs, result *string) (err error) {
+	if !(len(args.Params) == 2 || len(args.Params) == 3) {
+		return fmt.Errorf("wrong number of params, have %v want 2 or 3", len(args.Params))
+	}
+	operation := args.Params[0]
+	filter, err := mongodb.ParseArchiveFilter(args.Params[1])
+	if err != nil {
+		return err
+	}
+	var exportFile string
+	if len(args.Params) > 2 {
+		exportFile = args.Params[2]
+	}
+	var count int
+	switch operation {
+	case "purge":
+		if exportFile != "" {
+			return fmt.Errorf("purge does not support export file")
+		}
+		count, err = worker.PurgeArchivedSwaps(filter)
+	case "restore":
+		count, err = worker.RestoreArchivedSwaps(filter, exportFile)
+	default:
+		return fmt.Errorf("unknown operation '%v'", operation)
+	}
+	if err != nil {
+		return err
+	}
+	*result = fmt.Sprintf("%v, %v records", successReuslt, count)
+	return nil
+}
@@ -69,71 +69,6 @@ func (s *RPCAPI) GetSwapStatistics(r *http.Request, pairID *string, result *swap
 	return err
 }
 
-// RPCPairidTxSwapserverArgs pairid, txid, pairID and swapServer
-type RPCPairidTxSwapserverArgs struct {
-	Method string `json: "method"`
-	PairID string `json:"pairid"`
-	TxID  string `json:"txid"`
-	Chain string `json: "chain"`
-	SwapServer string `json:"swapServer"`
-}
-
-func (args *RPCPairidTxSwapserverArgs) getPairidTxSwapserver() (chain, method, pairid, txid, swapServer *string, err error) {
-	chain = &args.Chain
-	method = &args.Method
-	pairid = &args.PairID
-	txid = &args.TxID
-	swapServer = &args.SwapServer
-	if *method == "" {
-		return nil, nil, nil, nil, nil, errors.New("empty method")
-	}
-	if *pairid == "" {
-		return nil, nil, nil, nil, nil, errors.New("empty pairid")
-	}
-	if *txid == "" {
-		return nil, nil, nil, nil, nil, errors.New("empty tx id")
-	}
-	if *swapServer == "" {
-		return nil, nil, nil, nil, nil, errors.New("empty server rpc")
-	}
-	return chain, method, pairid, txid, swapServer, nil
-}
-
-// RPCChainidTxSwapserverArgs pairid, txid, pairID and swapServer
-type RPCChainidTxSwapserverArgs struct {
-	Method string `json: "method"`
-	ChainID string `json:"chainid"`
-	TxID  string `json:"txid"`
-	LogIndex string `json:"logIndex"`
-	Chain string `json: "chain"`
-	SwapServer string `json:"swapServer"`
-}
-
-func (args *RPCChainidTxSwapserverArgs) getChainidTxSwapserver() (chain, method, chainid, txid, logIndex, swapServer *string, err error) {
-	chain = &args.Chain
-	method = &args.Method
-	chainid = &args.ChainID
-	txid = &args.TxID
-	logIndex = &args.LogIndex
-	swapServer = &args.SwapServer
-	if *method == "" {
-		return nil, nil, nil, nil, nil, nil, errors.New("empty method")
-	}
-	if *chainid == "" {
-		return nil, nil, nil, nil, nil, nil, errors.New("empty chainid")
-	}
-	if *txid == "" {
-		return nil, nil, nil, nil, nil, nil, errors.New("empty tx id")
-	}
-	if *logIndex == "" {
-		return nil, nil, nil, nil, nil, nil, errors.New("empty logIndex id")
-	}
-	if *swapServer == "" {
-		return nil, nil, nil, nil, nil, nil, errors.New("empty server rpc")
-	}
-	return chain, method, chainid, txid, logIndex, swapServer, nil
-}
-
 // RPCChainTxArgs txid and pairID
 type RPCChainTxArgs struct {
 	Chain string `json:"chain"`
@@ -370,45 +305,85 @@ func (s *RPCAPI) GetLatestScanInfo(r *http.Request, isSrc *bool, result *swapapi
 	return err
 }
 
-// RegisterSwap api
-func (s *RPCAPI) RegisterSwap(r *http.Request,  args *RPCPairidTxSwapserverArgs, result *swapapi.PostResult) error {
-	chain, method, pairid, txid, swapServer, err := args.getPairidTxSwapserver()
+// RegisterSwapPending api
+func (s *RPCAPI) RegisterSwapTx(r *http.Request,  args *RPCChainTxArgs, result *swapapi.PostResult) error {
+	chain, txid, err := args.getChainTx()
 	if err != nil {
 		return err
 	}
-	res, err := swapapi.RegisterSwap(*chain, *method, *pairid, *txid, *swapServer)
+	res, err := swapapi.RegisterSwapPending(*chain, *txid)
 	if err == nil && res != nil {
 		*result = *res
 	}
 	return err
 }
 
-// RegisterSwapRouter api
-func (s *RPCAPI) RegisterSwapRouter(r *http.Request,  args *RPCChainidTxSwapserverArgs, result *swapapi.PostResult) error {
-	chain, method, chainid, txid, logIndex, swapServer, err := args.getChainidTxSwapserver()
+// RegisterSwapAsync api
+func (s *RPCAPI) RegisterSwapAsync(r *http.Request, args *RPCChainTxArgs, result *swapapi.RegisterJob) error {
+	chain, txid, err := args.getChainTx()
 	if err != nil {
 		return err
 	}
-	res, err := swapapi.RegisterSwapRouter(*chain, *method, *chainid, *txid, *logIndex, *swapServer)
+	res, err := swapapi.RegisterSwapAsync(*chain, *txid)
 	if err == nil && res != nil {
 		*result = *res
 	}
 	return err
 }
 
-// RegisterSwapPending api
-func (s *RPCAPI) RegisterSwapTx(r *http.Request,  args *RPCChainTxArgs, result *swapapi.PostResult) error {
-	chain, txid, err := args.getChainTx()
-	if err != nil {
-		return err
+// RegisterSwapBatch api
+func (s *RPCAPI) RegisterSwapBatch(r *http.Request, args *[]*swapapi.RegisterItem, result *[]*swapapi.RegisterBatchResult) error {
+	res, err := swapapi.RegisterSwapBatch(*args)
+	if err == nil && res != nil {
+		*result = res
 	}
-	res, err := swapapi.RegisterSwapPending(*chain, *txid)
+	return err
+}
+
+// GetRegisterJob api
+func (s *RPCAPI) GetRegisterJob(r *http.Request, jobID *string, result *swapapi.SwapRegisterStatus) error {
+	res, err := swapapi.GetRegisterJob(*jobID)
 	if err == nil && res != nil {
 		*result = *res
 	}
 	return err
 }
 
+// GetRegisteredSwapsByAddress api, find router swaps by sender or recipient
+func (s *RPCAPI) GetRegisteredSwapsByAddress(r *http.Request, args *RPCQueryHistoryArgs, result *[]*swapapi.RegisteredSwap) error {
+	res, err := swapapi.GetRegisteredSwapsByAddress(args.Address, args.Offset, args.Limit)
+	if err == nil && res != nil {
+		*result = res
+	}
+	return err
+}
+
+// GetSwapStatus api
+func (s *RPCAPI) GetSwapStatus(r *http.Request, txid *string, result *swapapi.SwapRegisterStatus) error {
+	res, err := swapapi.RegisterSwapStatus(*txid)
+	if err == nil && res != nil {
+		*result = *res
+	}
+	return err
+}
+
+// RPCQueryPostResultArgs args
+type RPCQueryPostResultArgs struct {
+	Chain      string `json:"chain"`
+	PostResult string `json:"postresult"`
+	Offset     int    `json:"offset"`
+	Limit      int    `json:"limit"`
+}
+
+// GetRegisteredSwapsByPostResult api
+func (s *RPCAPI) GetRegisteredSwapsByPostResult(r *http.Request, args *RPCQueryPostResultArgs, result *[]*swapapi.RegisteredSwap) error {
+	res, err := swapapi.GetRegisteredSwapsByPostResult(args.Chain, args.PostResult, args.Offset, args.Limit)
+	if err == nil && res != nil {
+		*result = res
+	}
+	return err
+}
+
 // RegisterAddress api
 func (s *RPCAPI) RegisterAddress(r *http.Request, address *string, result *swapapi.PostResult) error {
 	res, err := swapapi.RegisterAddress(*address)
@@ -16,6 +16,7 @@ import (
 
 	"github.com/weijun-sh/gethscan-server/cmd/utils"
 	"github.com/weijun-sh/gethscan-server/log"
+	"github.com/weijun-sh/gethscan-server/metrics"
 	"github.com/weijun-sh/gethscan-server/params"
 	"github.com/weijun-sh/gethscan-server/rpc/restapi"
 	"github.com/weijun-sh/gethscan-server/rpc/rpcapi"
@@ -87,13 +88,20 @@ func initRouter(r *mux.Router) {
 	r.HandleFunc("/help", restapi.HelpHandler).Methods("GET")
 	r.HandleFunc("/serverinfo", restapi.ServerInfoHandler).Methods("GET")
 	r.HandleFunc("/versioninfo", restapi.VersionInfoHandler).Methods("GET")
+	r.Handle("/metrics", metrics.Handler()).Methods("GET")
+	r.HandleFunc("/health", restapi.HealthHandler).Methods("GET")
+	r.HandleFunc("/ready", restapi.ReadyHandler).Methods("GET")
 	//r.HandleFunc("/nonceinfo", restapi.NonceInfoHandler).Methods("GET")
 	//r.HandleFunc("/pairinfo/{pairid}", restapi.TokenPairInfoHandler).Methods("GET")
 	//r.HandleFunc("/pairsinfo/{pairids}", restapi.TokenPairsInfoHandler).Methods("GET")
 	//r.HandleFunc("/statistics/{pairid}", restapi.StatisticsHandler).Methods("GET")
 
+	r.HandleFunc("/swap/register/batch", restapi.RegisterSwapBatchHandler).Methods("POST")
 	r.HandleFunc("/swap/register/{chainid}/{txid}", restapi.RegisterSwapHandler).Methods("POST")
 	r.HandleFunc("/swap/status/{txid}", restapi.SwapStatusHandler).Methods("GET")
+	r.HandleFunc("/swap/job/{jobid}", restapi.RegisterJobHandler).Methods("GET")
+	r.HandleFunc("/swap/postresult/{postresult}", restapi.RegisteredSwapsByPostResultHandler).Methods("GET")
+	r.HandleFunc("/swap/address/{address}", restapi.RegisteredSwapsByAddressHandler).Methods("GET")
 	r.HandleFunc("/register/post/{method}/{pairid}/{txid}/{swapserver}", restapi.RegisterSwapPostHandler).Methods("POST")
 	r.HandleFunc("/register/post/{method}/{chainid}/{txid}/{logindex}/{swapserver}", restapi.RegisterSwapRouterHandler).Methods("POST")
 	//r.HandleFunc("/swapin/post/{pairid}/{txid}", restapi.PostSwapinHandler).Methods("POST")
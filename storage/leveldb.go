@@ -0,0 +1,1035 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weijun-sh/gethscan-server/cmd/utils"
+	"github.com/weijun-sh/gethscan-server/leveldb"
+	"github.com/weijun-sh/gethscan-server/log"
+	"github.com/weijun-sh/gethscan-server/mongodb"
+)
+
+// key prefixes of tables in leveldb
+const (
+	prefixSwapPending    = "pending/"
+	prefixRegisteredSwap = "registered/"
+	prefixSwapPost       = "posted/"
+	prefixSwapDeleted    = "deleted/"
+	prefixSwapArchive    = "archive/"
+	prefixScanInfo       = "scaninfo/"
+	prefixWebhookEvent   = "webhook/"
+)
+
+// LevelDBStorage storage in embedded leveldb, for small deployments and unit tests.
+// records are json encoded and keyed by table prefix, registered swaps are queried
+// by secondary indexes, other queries iterate all records of the table,
+// so it is not suitable for large amount of records.
+// the database is opened by only one process, leases work as in mongodb.
+type LevelDBStorage struct {
+	db *leveldb.Database
+
+	// serialize read-modify-write of records
+	mu sync.Mutex
+}
+
+// NewLevelDBStorage open leveldb storage at path
+func NewLevelDBStorage(path string, cache, handles int) (*LevelDBStorage, error) {
+	db, err := leveldb.New(path, cache, handles, false)
+	if err != nil {
+		return nil, err
+	}
+	s := &LevelDBStorage{db: db}
+	if err = s.ensureRegisteredSwapIndexes(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// InitLevelDBStorage open leveldb storage and use it as storage of scan server records,
+// the database is closed after all database related tasks are done when program exits.
+func InitLevelDBStorage(path string, cache, handles int) error {
+	s, err := NewLevelDBStorage(path, cache, handles)
+	if err != nil {
+		return err
+	}
+	utils.TopWaitGroup.Add(1)
+	go utils.WaitAndCleanup(func() {
+		defer utils.TopWaitGroup.Done()
+		mongodb.MgoWaitGroup.Wait()
+		if err := s.Close(); err != nil {
+			log.Warn("[leveldb] close storage failed", "path", path, "err", err)
+			return
+		}
+		log.Info("[leveldb] close storage success", "path", path)
+	})
+	SetStorage(s)
+	log.Info("[leveldb] open storage success", "path", path)
+	return nil
+}
+
+// Close close database
+func (s *LevelDBStorage) Close() error {
+	return s.db.Close()
+}
+
+func (s *LevelDBStorage) get(prefix, key string, result interface{}) error {
+	data, err := s.db.Get([]byte(prefix + key))
+	if err != nil {
+		if leveldb.IsNotFoundErr(err) {
+			return mongodb.ErrItemNotFound
+		}
+		return err
+	}
+	return json.Unmarshal(data, result)
+}
+
+func (s *LevelDBStorage) put(prefix, key string, record interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.db.Put([]byte(prefix+key), data)
+}
+
+// insert put record if key not exist, otherwise return mongodb.ErrItemIsDup
+func (s *LevelDBStorage) insert(prefix, key string, record interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exist, err := s.db.Has([]byte(prefix + key))
+	if err != nil {
+		return err
+	}
+	if exist {
+		return mongodb.ErrItemIsDup
+	}
+	return s.put(prefix, key, record)
+}
+
+// iterate call fn with value of every record in table
+func (s *LevelDBStorage) iterate(prefix string, fn func(value []byte) error) error {
+	it := s.db.NewIterator([]byte(prefix), nil)
+	defer it.Release()
+	for it.Next() {
+		if err := fn(it.Value()); err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
+
+func (s *LevelDBStorage) allSwapPending() (result []*mongodb.MgoRegisteredSwapPending, err error) {
+	err = s.iterate(prefixSwapPending, func(value []byte) error {
+		var item mongodb.MgoRegisteredSwapPending
+		if err := json.Unmarshal(value, &item); err != nil {
+			return err
+		}
+		result = append(result, &item)
+		return nil
+	})
+	return result, err
+}
+
+// allSwaps all records of registered, posted or deleted swap table
+func (s *LevelDBStorage) allSwaps(prefix string) (result []*mongodb.MgoRegisteredSwap, err error) {
+	err = s.iterate(prefix, func(value []byte) error {
+		var item mongodb.MgoRegisteredSwap
+		if err := json.Unmarshal(value, &item); err != nil {
+			return err
+		}
+		result = append(result, &item)
+		return nil
+	})
+	return result, err
+}
+
+func (s *LevelDBStorage) allArchivedSwap() (result []*mongodb.MgoArchivedSwap, err error) {
+	err = s.iterate(prefixSwapArchive, func(value []byte) error {
+		var item mongodb.MgoArchivedSwap
+		if err := json.Unmarshal(value, &item); err != nil {
+			return err
+		}
+		result = append(result, &item)
+		return nil
+	})
+	return result, err
+}
+
+func (s *LevelDBStorage) allWebhookEvent() (result []*mongodb.MgoWebhookEvent, err error) {
+	err = s.iterate(prefixWebhookEvent, func(value []byte) error {
+		var item mongodb.MgoWebhookEvent
+		if err := json.Unmarshal(value, &item); err != nil {
+			return err
+		}
+		result = append(result, &item)
+		return nil
+	})
+	return result, err
+}
+
+// isAfterCursor is record positioned after cursor, nil cursor means from the start
+func isAfterCursor(cursor *mongodb.SwapCursor, timestamp int64, key string) bool {
+	return cursor == nil ||
+		timestamp > cursor.Timestamp ||
+		(timestamp == cursor.Timestamp && key > cursor.Key)
+}
+
+// isNotLeased is record not leased by other instances
+func isNotLeased(lockedBy string, lockedUntil int64) bool {
+	return lockedUntil == 0 ||
+		lockedUntil <= time.Now().Unix() ||
+		lockedBy == mongodb.GetInstanceID()
+}
+
+func isPendingState(state mongodb.SwapState) bool {
+	return state == mongodb.StateSubmitted || state == mongodb.StateVerifying
+}
+
+func newStateHistory(state mongodb.SwapState, message string, now time.Time) []*mongodb.MgoStateHistory {
+	return []*mongodb.MgoStateHistory{{State: state, Message: message, Timestamp: now.Unix()}}
+}
+
+// ------------------ swap pending ------------------------
+
+// AddRegisteredSwapPending impl
+func (s *LevelDBStorage) AddRegisteredSwapPending(chain, txid string) error {
+	ma := mongodb.NewRegisteredSwapPending(chain, txid, time.Now())
+	err := s.insert(prefixSwapPending, ma.Key, ma)
+	if err == nil {
+		log.Info("leveldb add register swap pending", "txid", ma.Key, "chain", chain)
+	} else {
+		log.Debug("leveldb add register swap pending", "txid", ma.Key, "chain", chain, "err", err)
+	}
+	return err
+}
+
+// AddRegisteredSwapPendings impl, items are written in one batch
+func (s *LevelDBStorage) AddRegisteredSwapPendings(items []*mongodb.RegisterItem) ([]error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	errs := make([]error, len(items))
+	added := make(map[string]bool, len(items))
+	batch := s.db.NewBatch()
+	for i, item := range items {
+		exist, err := s.db.Has([]byte(prefixSwapPending + item.TxID))
+		if err != nil {
+			return nil, err
+		}
+		if exist || added[item.TxID] {
+			errs[i] = mongodb.ErrItemIsDup
+			continue
+		}
+		data, err := json.Marshal(mongodb.NewRegisteredSwapPending(item.Chain, item.TxID, now))
+		if err != nil {
+			return nil, err
+		}
+		_ = batch.Put([]byte(prefixSwapPending+item.TxID), data)
+		added[item.TxID] = true
+	}
+	if err := batch.Write(); err != nil {
+		return nil, err
+	}
+	log.Info("leveldb add register swap pendings", "count", len(items), "added", len(added))
+	return errs, nil
+}
+
+// FindSwapPendingStatus impl
+func (s *LevelDBStorage) FindSwapPendingStatus(txid string) (*mongodb.MgoRegisteredSwapPending, error) {
+	var result mongodb.MgoRegisteredSwapPending
+	if err := s.get(prefixSwapPending, txid, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// FindSwapPendingByJobID impl
+func (s *LevelDBStorage) FindSwapPendingByJobID(jobID string) (*mongodb.MgoRegisteredSwapPending, error) {
+	all, err := s.allSwapPending()
+	if err != nil {
+		return nil, err
+	}
+	jobID = strings.ToLower(jobID)
+	for _, item := range all {
+		if item.JobID == jobID {
+			return item, nil
+		}
+	}
+	return nil, mongodb.ErrItemNotFound
+}
+
+// FindSwapPending impl
+func (s *LevelDBStorage) FindSwapPending(chain string, cursor *mongodb.SwapCursor, limit int) ([]*mongodb.MgoRegisteredSwapPending, error) {
+	all, err := s.allSwapPending()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*mongodb.MgoRegisteredSwapPending, 0, limit)
+	for _, item := range all {
+		if (chain == "" || item.Chain == chain) &&
+			isPendingState(item.Status) &&
+			isAfterCursor(cursor, item.Timestamp, item.Key) &&
+			isNotLeased(item.LockedBy, item.LockedUntil) {
+			result = append(result, item)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Timestamp != result[j].Timestamp {
+			return result[i].Timestamp < result[j].Timestamp
+		}
+		return result[i].Key < result[j].Key
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+// FindOldestSwapPending impl
+func (s *LevelDBStorage) FindOldestSwapPending() (*mongodb.MgoRegisteredSwapPending, error) {
+	all, err := s.allSwapPending()
+	if err != nil {
+		return nil, err
+	}
+	var oldest *mongodb.MgoRegisteredSwapPending
+	for _, item := range all {
+		if isPendingState(item.Status) && (oldest == nil || item.Timestamp < oldest.Timestamp) {
+			oldest = item
+		}
+	}
+	if oldest == nil {
+		return nil, mongodb.ErrItemNotFound
+	}
+	return oldest, nil
+}
+
+// UpdateSwapPendingJobID impl
+func (s *LevelDBStorage) UpdateSwapPendingJobID(txid, jobID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var item mongodb.MgoRegisteredSwapPending
+	if err := s.get(prefixSwapPending, txid, &item); err != nil {
+		return err
+	}
+	item.JobID = jobID
+	return s.put(prefixSwapPending, txid, &item)
+}
+
+// UpdateSwapPendingState impl
+func (s *LevelDBStorage) UpdateSwapPendingState(txid string, to mongodb.SwapState, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var item mongodb.MgoRegisteredSwapPending
+	err := s.get(prefixSwapPending, txid, &item)
+	if err == nil {
+		err = mongodb.CheckSwapPendingTransition(item.Status, to)
+	}
+	if err == nil {
+		now := time.Now()
+		item.Status = to
+		item.Time = now.Format("2006-01-02 15:04:05")
+		item.LastError = message
+		item.History = append(item.History, newStateHistory(to, message, now)...)
+		err = s.put(prefixSwapPending, txid, &item)
+	}
+	if err != nil {
+		log.Info("leveldb update swap state failed", "table", "pending", "key", txid, "to", to, "message", message, "err", err)
+		return err
+	}
+	log.Info("leveldb update swap state", "table", "pending", "key", txid, "to", to, "message", message)
+	return nil
+}
+
+// LeaseSwapPending impl
+func (s *LevelDBStorage) LeaseSwapPending(txid string, duration time.Duration) error {
+	return s.acquireLease(prefixSwapPending, txid, &mongodb.MgoRegisteredSwapPending{}, duration)
+}
+
+// ReleaseSwapPending impl
+func (s *LevelDBStorage) ReleaseSwapPending(txid string) error {
+	return s.releaseLease(prefixSwapPending, txid, &mongodb.MgoRegisteredSwapPending{})
+}
+
+// ------------------ registered swap ------------------------
+
+// AddRegisteredSwapItem impl
+func (s *LevelDBStorage) AddRegisteredSwapItem(swap *mongodb.MgoRegisteredSwap) error {
+	if len(swap.History) == 0 {
+		swap.History = newStateHistory(swap.Status, "", time.Now())
+	}
+	err := s.insertRegisteredSwap(swap)
+	if err == nil {
+		log.Info("leveldb add register swap success", "key", swap.Key, "chain", swap.Chain, "status", swap.Status)
+	} else {
+		log.Info("leveldb add register swap failed", "key", swap.Key, "chain", swap.Chain, "err", err)
+	}
+	return err
+}
+
+// FindRegisterdSwapTxid impl
+func (s *LevelDBStorage) FindRegisterdSwapTxid(txid string) ([]*mongodb.MgoRegisteredSwap, error) {
+	var result []*mongodb.MgoRegisteredSwap
+	err := s.iterateIndexedSwaps(getTxidIndexPrefix(txid), "", func(swap *mongodb.MgoRegisteredSwap) bool {
+		result = append(result, swap)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, mongodb.ErrItemNotFound
+	}
+	return result, nil
+}
+
+// FindRegisteredSwapWithStatus impl
+func (s *LevelDBStorage) FindRegisteredSwapWithStatus(chain string, status mongodb.SwapState, cursor *mongodb.SwapCursor, limit int) ([]*mongodb.MgoRegisteredSwap, error) {
+	// status index is sorted by timestamp and key, start from the cursor
+	var start string
+	if cursor != nil {
+		start = getIndexTimestamp(cursor.Timestamp) + "/" + cursor.Key
+	}
+	result := make([]*mongodb.MgoRegisteredSwap, 0, limit)
+	err := s.iterateIndexedSwaps(getStatusIndexPrefix(status), start, func(swap *mongodb.MgoRegisteredSwap) bool {
+		if (chain == "" || swap.Chain == chain) &&
+			swap.Status == status &&
+			isAfterCursor(cursor, swap.Timestamp, swap.Key) &&
+			isNotLeased(swap.LockedBy, swap.LockedUntil) {
+			result = append(result, swap)
+		}
+		return limit <= 0 || len(result) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// sortRegisteredSwaps sort by timestamp and key
+func sortRegisteredSwaps(swaps []*mongodb.MgoRegisteredSwap) {
+	sort.Slice(swaps, func(i, j int) bool {
+		if swaps[i].Timestamp != swaps[j].Timestamp {
+			return swaps[i].Timestamp < swaps[j].Timestamp
+		}
+		return swaps[i].Key < swaps[j].Key
+	})
+}
+
+// FindRegisteredSwapToRetry impl
+func (s *LevelDBStorage) FindRegisteredSwapToRetry(limit int) ([]*mongodb.MgoRegisteredSwap, error) {
+	now := time.Now().Unix()
+	result := make([]*mongodb.MgoRegisteredSwap, 0, limit)
+	err := s.iterateIndexedSwaps(getStatusIndexPrefix(mongodb.StatePosting), "", func(swap *mongodb.MgoRegisteredSwap) bool {
+		if swap.Status == mongodb.StatePosting &&
+			swap.NextAttempt <= now &&
+			isNotLeased(swap.LockedBy, swap.LockedUntil) {
+			result = append(result, swap)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].NextAttempt < result[j].NextAttempt
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+// FindRegisteredSwapWithPostResult impl
+func (s *LevelDBStorage) FindRegisteredSwapWithPostResult(chain, postResult string, offset, limit int) ([]*mongodb.MgoRegisteredSwap, error) {
+	return s.findIndexedSwapsPage(getPostResultIndexPrefix(postResult), func(swap *mongodb.MgoRegisteredSwap) bool {
+		return swap.PostResult == postResult && (chain == "" || swap.Chain == chain)
+	}, offset, limit)
+}
+
+// FindRegisteredSwapByAddress impl
+func (s *LevelDBStorage) FindRegisteredSwapByAddress(address string, offset, limit int) ([]*mongodb.MgoRegisteredSwap, error) {
+	address = strings.ToLower(address)
+	return s.findIndexedSwapsPage(getAddressIndexPrefix(address), func(swap *mongodb.MgoRegisteredSwap) bool {
+		return swap.Event != nil && (swap.Event.From == address || swap.Event.To == address)
+	}, offset, limit)
+}
+
+// pageRegisteredSwaps sort swaps by timestamp and get page of them,
+// negative limit means latest first.
+func pageRegisteredSwaps(result []*mongodb.MgoRegisteredSwap, offset, limit int) []*mongodb.MgoRegisteredSwap {
+	sortRegisteredSwaps(result)
+	if limit < 0 { // latest first
+		for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+			result[i], result[j] = result[j], result[i]
+		}
+		limit = -limit
+	}
+	if offset >= len(result) {
+		return result[:0]
+	}
+	if offset > 0 {
+		result = result[offset:]
+	}
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// UpdateRegisteredSwapState impl
+func (s *LevelDBStorage) UpdateRegisteredSwapState(key string, to mongodb.SwapState, message string) error {
+	return s.updateRegisteredSwapState(key, to, message, nil)
+}
+
+// UpdateRegisteredSwapPosting impl
+func (s *LevelDBStorage) UpdateRegisteredSwapPosting(key string, nextAttempt int64) error {
+	return s.updateRegisteredSwapState(key, mongodb.StatePosting, "", func(swap *mongodb.MgoRegisteredSwap) {
+		swap.NextAttempt = nextAttempt
+	})
+}
+
+// UpdateRegisteredSwapPostResult impl
+func (s *LevelDBStorage) UpdateRegisteredSwapPostResult(key string, state mongodb.SwapState, postResult string, errCode int, response string) error {
+	lastError := response
+	if state == mongodb.StatePosted {
+		lastError = ""
+	}
+	return s.updateRegisteredSwapState(key, state, lastError, func(swap *mongodb.MgoRegisteredSwap) {
+		swap.PostResult = postResult
+		swap.PostErrorCode = errCode
+		swap.LastError = lastError
+		swap.LastResponse = response
+	})
+}
+
+// UpdateRegisteredSwapRetry impl
+func (s *LevelDBStorage) UpdateRegisteredSwapRetry(key string, attempts int, lastError string, nextAttempt int64, state mongodb.SwapState) error {
+	return s.updateRegisteredSwapState(key, state, lastError, func(swap *mongodb.MgoRegisteredSwap) {
+		swap.Attempts = attempts
+		swap.LastError = lastError
+		swap.NextAttempt = nextAttempt
+		swap.PostResult = mongodb.PostResultTransient
+		swap.LastResponse = lastError
+	})
+}
+
+// UpdateRegisteredSwapBlock impl
+func (s *LevelDBStorage) UpdateRegisteredSwapBlock(key string, blockHeight uint64, blockHash string, state mongodb.SwapState) error {
+	return s.updateRegisteredSwapState(key, state, "block "+blockHash, func(swap *mongodb.MgoRegisteredSwap) {
+		swap.BlockHeight = blockHeight
+		swap.BlockHash = blockHash
+		swap.MissingChecks = 0
+		swap.MissingSince = 0
+	})
+}
+
+// UpdateRegisteredSwapTxMissing impl
+func (s *LevelDBStorage) UpdateRegisteredSwapTxMissing(key string, missingChecks int, missingSince int64, message string) error {
+	return s.updateRegisteredSwapState(key, mongodb.StateVerifying, message, func(swap *mongodb.MgoRegisteredSwap) {
+		swap.BlockHeight = 0
+		swap.BlockHash = ""
+		swap.MissingChecks = missingChecks
+		swap.MissingSince = missingSince
+	})
+}
+
+// updateRegisteredSwapState update state of registered swap if transition is allowed,
+// other fields are set by 'update' along with the state.
+func (s *LevelDBStorage) updateRegisteredSwapState(key string, to mongodb.SwapState, message string, update func(*mongodb.MgoRegisteredSwap)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var swap mongodb.MgoRegisteredSwap
+	err := s.get(prefixRegisteredSwap, key, &swap)
+	if err == nil {
+		err = mongodb.CheckRegisteredSwapTransition(swap.Status, to)
+	}
+	if err == nil {
+		oldIndexKeys := getRegisteredSwapIndexKeys(&swap)
+		if update != nil {
+			update(&swap)
+		}
+		now := time.Now()
+		swap.Status = to
+		swap.Time = now.Format("2006-01-02 15:04:05")
+		swap.History = append(swap.History, newStateHistory(to, message, now)...)
+		err = s.writeRegisteredSwap(oldIndexKeys, &swap)
+	}
+	if err != nil {
+		log.Info("leveldb update swap state failed", "table", "registered", "key", key, "to", to, "message", message, "err", err)
+		return err
+	}
+	log.Info("leveldb update swap state", "table", "registered", "key", key, "to", to, "message", message)
+	return nil
+}
+
+// LeaseRegisteredSwap impl
+func (s *LevelDBStorage) LeaseRegisteredSwap(key string, status mongodb.SwapState, duration time.Duration) (*mongodb.MgoRegisteredSwap, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var swap mongodb.MgoRegisteredSwap
+	if err := s.get(prefixRegisteredSwap, key, &swap); err != nil {
+		return nil, err
+	}
+	if !isNotLeased(swap.LockedBy, swap.LockedUntil) {
+		return nil, mongodb.ErrItemIsLeased
+	}
+	if swap.Status != status ||
+		(status == mongodb.StatePosting && swap.NextAttempt > time.Now().Unix()) {
+		return nil, mongodb.ErrItemIsChanged
+	}
+	swap.LockedBy = mongodb.GetInstanceID()
+	swap.LockedUntil = time.Now().Add(duration).Unix()
+	if err := s.put(prefixRegisteredSwap, key, &swap); err != nil {
+		return nil, err
+	}
+	return &swap, nil
+}
+
+// ReleaseRegisteredSwap impl
+func (s *LevelDBStorage) ReleaseRegisteredSwap(key string) error {
+	return s.releaseLease(prefixRegisteredSwap, key, &mongodb.MgoRegisteredSwap{})
+}
+
+// ------------------ posted and deleted swap ------------------------
+
+// AddSwapPost impl
+func (s *LevelDBStorage) AddSwapPost(post *mongodb.MgoRegisteredSwap) error {
+	now := time.Now()
+	ma := &mongodb.MgoRegisteredSwap{
+		Key:        post.Key,
+		TxID:       post.TxID,
+		PairID:     post.PairID,
+		Method:     post.Method,
+		LogIndex:   post.LogIndex,
+		SwapServer: post.SwapServer,
+		Chain:      post.Chain,
+		ChainID:    post.ChainID,
+		Status:     mongodb.StatePosted,
+		Timestamp:  now.Unix(),
+		Time:       now.Format("2006-01-02 15:04:05"),
+	}
+	err := s.insert(prefixSwapPost, ma.Key, ma)
+	if err == nil {
+		log.Info("leveldb add swap post", "txid", ma.Key)
+	} else {
+		log.Info("leveldb add swap post", "txid", ma.Key, "err", err)
+	}
+	return err
+}
+
+// RemoveRegisteredSwap impl, removed swaps are kept in deleted swap table
+func (s *LevelDBStorage) RemoveRegisteredSwap(txid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var swaps []*mongodb.MgoRegisteredSwap
+	err := s.iterateIndexedSwaps(getTxidIndexPrefix(txid), "", func(swap *mongodb.MgoRegisteredSwap) bool {
+		swaps = append(swaps, swap)
+		return true
+	})
+	if err == nil {
+		now := time.Now()
+		batch := s.db.NewBatch()
+		for _, swap := range swaps {
+			deleteRegisteredSwap(batch, swap) // index keys before marking deleted
+			mongodb.MarkSwapDeleted(swap, now)
+			data, errm := json.Marshal(swap)
+			if errm != nil {
+				return errm
+			}
+			_ = batch.Put([]byte(prefixSwapDeleted+swap.Key), data)
+		}
+		err = batch.Write()
+	}
+	if err == nil {
+		log.Info("leveldb remove register swap", "txid", txid)
+	} else {
+		log.Info("leveldb remove register swap", "txid", txid, "err", err)
+	}
+	return err
+}
+
+// ------------------ archived swap ------------------------
+
+func getArchiveSourcePrefix(kind string) string {
+	switch kind {
+	case mongodb.ArchiveKindPending:
+		return prefixSwapPending
+	case mongodb.ArchiveKindRegistered:
+		return prefixRegisteredSwap
+	case mongodb.ArchiveKindPost:
+		return prefixSwapPost
+	case mongodb.ArchiveKindDeleted:
+		return prefixSwapDeleted
+	default:
+		return ""
+	}
+}
+
+// FindSwapsToArchive impl
+func (s *LevelDBStorage) FindSwapsToArchive(before int64, limit int) ([]*mongodb.MgoArchivedSwap, error) {
+	now := time.Now()
+	result := make([]*mongodb.MgoArchivedSwap, 0, limit)
+	pendings, err := s.allSwapPending()
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range pendings {
+		if item.Timestamp < before &&
+			mongodb.IsSwapFinished(mongodb.ArchiveKindPending, item.Status) &&
+			isNotLeased(item.LockedBy, item.LockedUntil) {
+			result = append(result, mongodb.NewArchivedPending(item, now))
+		}
+	}
+	for _, kind := range []string{mongodb.ArchiveKindRegistered, mongodb.ArchiveKindPost, mongodb.ArchiveKindDeleted} {
+		swaps, err := s.allSwaps(getArchiveSourcePrefix(kind))
+		if err != nil {
+			return nil, err
+		}
+		for _, swap := range swaps {
+			if swap.Timestamp < before &&
+				mongodb.IsSwapFinished(kind, swap.Status) &&
+				isNotLeased(swap.LockedBy, swap.LockedUntil) {
+				result = append(result, mongodb.NewArchivedSwap(kind, swap, now))
+			}
+		}
+	}
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+// AddArchivedSwaps impl
+func (s *LevelDBStorage) AddArchivedSwaps(swaps []*mongodb.MgoArchivedSwap) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	batch := s.db.NewBatch()
+	for _, a := range swaps {
+		data, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		_ = batch.Put([]byte(prefixSwapArchive+a.Key), data)
+	}
+	return batch.Write()
+}
+
+// RemoveArchivedSources impl
+func (s *LevelDBStorage) RemoveArchivedSources(swaps []*mongodb.MgoArchivedSwap) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	batch := s.db.NewBatch()
+	for _, a := range swaps {
+		if a.Kind == mongodb.ArchiveKindRegistered {
+			var swap mongodb.MgoRegisteredSwap
+			err := s.get(prefixRegisteredSwap, a.GetRecordKey(), &swap)
+			if err == mongodb.ErrItemNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			deleteRegisteredSwap(batch, &swap)
+			continue
+		}
+		_ = batch.Delete([]byte(getArchiveSourcePrefix(a.Kind) + a.GetRecordKey()))
+	}
+	return batch.Write()
+}
+
+// FindArchivedSwaps impl
+func (s *LevelDBStorage) FindArchivedSwaps(filter *mongodb.ArchiveFilter, limit int) ([]*mongodb.MgoArchivedSwap, error) {
+	all, err := s.allArchivedSwap()
+	if err != nil {
+		return nil, err
+	}
+	var result []*mongodb.MgoArchivedSwap
+	for _, a := range all {
+		if filter.IsMatch(a) {
+			result = append(result, a)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Timestamp < result[j].Timestamp
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+// RestoreArchivedSwaps impl
+func (s *LevelDBStorage) RestoreArchivedSwaps(swaps []*mongodb.MgoArchivedSwap) error {
+	for _, a := range swaps {
+		prefix := getArchiveSourcePrefix(a.Kind)
+		if prefix == "" || a.GetRecordKey() == "" {
+			return fmt.Errorf("wrong archived swap '%v'", a.Key)
+		}
+		var err error
+		if a.Kind == mongodb.ArchiveKindRegistered && a.Swap != nil {
+			err = s.insertRegisteredSwap(a.Swap)
+		} else {
+			err = s.insert(prefix, a.GetRecordKey(), a.GetRecord())
+		}
+		if err != nil && err != mongodb.ErrItemIsDup {
+			log.Warn("leveldb restore archived swap failed", "key", a.Key, "err", err)
+			return err
+		}
+		if err = s.db.Delete([]byte(prefixSwapArchive + a.Key)); err != nil {
+			return err
+		}
+		log.Info("leveldb restore archived swap", "key", a.Key)
+	}
+	return nil
+}
+
+// PurgeArchivedSwaps impl
+func (s *LevelDBStorage) PurgeArchivedSwaps(filter *mongodb.ArchiveFilter) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.allArchivedSwap()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	batch := s.db.NewBatch()
+	for _, a := range all {
+		if filter.IsMatch(a) {
+			_ = batch.Delete([]byte(prefixSwapArchive + a.Key))
+			count++
+		}
+	}
+	if err = batch.Write(); err != nil {
+		return 0, err
+	}
+	log.Info("leveldb purge archived swaps", "filter", filter, "count", count)
+	return count, nil
+}
+
+// ------------------ swap states ------------------------
+
+// CountSwapStates impl
+func (s *LevelDBStorage) CountSwapStates() (pending, registered map[mongodb.SwapState]int, err error) {
+	allPending, err := s.allSwapPending()
+	if err != nil {
+		return nil, nil, err
+	}
+	pending = make(map[mongodb.SwapState]int)
+	for _, item := range allPending {
+		if !item.Status.IsFinal() {
+			pending[item.Status]++
+		}
+	}
+	registered = make(map[mongodb.SwapState]int)
+	for _, state := range mongodb.AllSwapStates {
+		if state.IsFinal() {
+			continue
+		}
+		count, err := s.countIndex(getStatusIndexPrefix(state))
+		if err != nil {
+			return nil, nil, err
+		}
+		if count > 0 {
+			registered[state] = count
+		}
+	}
+	return pending, registered, nil
+}
+
+// ------------------ latest scan info ------------------------
+
+func getChainScanInfoKey(chain string) string {
+	return strings.ToLower("scan:" + chain)
+}
+
+// UpdateChainScanInfo impl
+func (s *LevelDBStorage) UpdateChainScanInfo(chain string, blockHeight uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := getChainScanInfoKey(chain)
+	info := &mongodb.MgoLatestScanInfo{Key: key}
+	err := s.get(prefixScanInfo, key, info)
+	if err != nil && err != mongodb.ErrItemNotFound {
+		return err
+	}
+	if !isNotLeased(info.LockedBy, info.LockedUntil) {
+		return mongodb.ErrItemIsLeased
+	}
+	info.BlockHeight = blockHeight
+	info.Timestamp = time.Now().Unix()
+	err = s.put(prefixScanInfo, key, info)
+	if err != nil {
+		log.Debug("leveldb update chain scan info failed", "chain", chain, "blockHeight", blockHeight, "err", err)
+	}
+	return err
+}
+
+// FindChainScanInfo impl
+func (s *LevelDBStorage) FindChainScanInfo(chain string) (*mongodb.MgoLatestScanInfo, error) {
+	var result mongodb.MgoLatestScanInfo
+	if err := s.get(prefixScanInfo, getChainScanInfoKey(chain), &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// LeaseChainScan impl
+func (s *LevelDBStorage) LeaseChainScan(chain string, duration time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := getChainScanInfoKey(chain)
+	info := &mongodb.MgoLatestScanInfo{Key: key}
+	err := s.get(prefixScanInfo, key, info)
+	if err != nil && err != mongodb.ErrItemNotFound {
+		return err
+	}
+	if !isNotLeased(info.LockedBy, info.LockedUntil) {
+		return mongodb.ErrItemIsLeased
+	}
+	info.LockedBy = mongodb.GetInstanceID()
+	info.LockedUntil = time.Now().Add(duration).Unix()
+	return s.put(prefixScanInfo, key, info)
+}
+
+// ReleaseChainScan impl
+func (s *LevelDBStorage) ReleaseChainScan(chain string) error {
+	return s.releaseLease(prefixScanInfo, getChainScanInfoKey(chain), &mongodb.MgoLatestScanInfo{})
+}
+
+// ------------------ webhook event ------------------------
+
+// AddWebhookEvent impl, duplicate event is ignored
+func (s *LevelDBStorage) AddWebhookEvent(ev *mongodb.MgoWebhookEvent) error {
+	err := s.insert(prefixWebhookEvent, ev.Key, ev)
+	switch {
+	case err == nil:
+		log.Info("leveldb add webhook event", "key", ev.Key)
+		return nil
+	case err == mongodb.ErrItemIsDup:
+		return nil
+	default:
+		log.Warn("leveldb add webhook event failed", "key", ev.Key, "err", err)
+		return err
+	}
+}
+
+// FindWebhookEventsToDeliver impl
+func (s *LevelDBStorage) FindWebhookEventsToDeliver(limit int) ([]*mongodb.MgoWebhookEvent, error) {
+	all, err := s.allWebhookEvent()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	result := make([]*mongodb.MgoWebhookEvent, 0, limit)
+	for _, ev := range all {
+		if ev.Status == mongodb.WebhookEventPending &&
+			ev.NextAttempt <= now &&
+			isNotLeased(ev.LockedBy, ev.LockedUntil) {
+			result = append(result, ev)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].NextAttempt < result[j].NextAttempt
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+// UpdateWebhookEventDelivery impl
+func (s *LevelDBStorage) UpdateWebhookEventDelivery(key, status string, attempts int, lastError string, nextAttempt int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ev mongodb.MgoWebhookEvent
+	err := s.get(prefixWebhookEvent, key, &ev)
+	if err == nil {
+		ev.Status = status
+		ev.Attempts = attempts
+		ev.LastError = lastError
+		ev.NextAttempt = nextAttempt
+		err = s.put(prefixWebhookEvent, key, &ev)
+	}
+	if err != nil {
+		log.Warn("leveldb update webhook event failed", "key", key, "status", status, "err", err)
+	}
+	return err
+}
+
+// LeaseWebhookEvent impl
+func (s *LevelDBStorage) LeaseWebhookEvent(key string, duration time.Duration) error {
+	return s.acquireLease(prefixWebhookEvent, key, &mongodb.MgoWebhookEvent{}, duration)
+}
+
+// ReleaseWebhookEvent impl
+func (s *LevelDBStorage) ReleaseWebhookEvent(key string) error {
+	return s.releaseLease(prefixWebhookEvent, key, &mongodb.MgoWebhookEvent{})
+}
+
+// ------------------ lease ------------------------
+
+// getLeaseFields get lease fields of record
+func getLeaseFields(record interface{}) (lockedBy *string, lockedUntil *int64) {
+	switch r := record.(type) {
+	case *mongodb.MgoRegisteredSwapPending:
+		return &r.LockedBy, &r.LockedUntil
+	case *mongodb.MgoRegisteredSwap:
+		return &r.LockedBy, &r.LockedUntil
+	case *mongodb.MgoWebhookEvent:
+		return &r.LockedBy, &r.LockedUntil
+	case *mongodb.MgoLatestScanInfo:
+		return &r.LockedBy, &r.LockedUntil
+	default:
+		panic(fmt.Sprintf("record type %T has no lease", record))
+	}
+}
+
+func (s *LevelDBStorage) acquireLease(prefix, key string, record interface{}, duration time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.get(prefix, key, record); err != nil {
+		return err
+	}
+	lockedBy, lockedUntil := getLeaseFields(record)
+	if !isNotLeased(*lockedBy, *lockedUntil) {
+		return mongodb.ErrItemIsLeased
+	}
+	*lockedBy = mongodb.GetInstanceID()
+	*lockedUntil = time.Now().Add(duration).Unix()
+	return s.put(prefix, key, record)
+}
+
+func (s *LevelDBStorage) releaseLease(prefix, key string, record interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.get(prefix, key, record)
+	if err == mongodb.ErrItemNotFound {
+		return nil // record removed
+	}
+	if err != nil {
+		return err
+	}
+	lockedBy, lockedUntil := getLeaseFields(record)
+	if *lockedBy != mongodb.GetInstanceID() {
+		return nil // lease expired and taken by others
+	}
+	*lockedBy = ""
+	*lockedUntil = 0
+	return s.put(prefix, key, record)
+}
+
+// Ping impl
+func (s *LevelDBStorage) Ping(timeout time.Duration) error {
+	_, err := s.db.Has([]byte(prefixScanInfo))
+	return err
+}
+
+// Type impl
+func (s *LevelDBStorage) Type() string {
+	return "leveldb"
+}
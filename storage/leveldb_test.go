@@ -0,0 +1,426 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/weijun-sh/gethscan-server/mongodb"
+)
+
+func newTestLevelDBStorage(t *testing.T) *LevelDBStorage {
+	dir, err := ioutil.TempDir("", "scanserver-storage")
+	if err != nil {
+		t.Fatalf("create temp dir failed: %v", err)
+	}
+	s, err := NewLevelDBStorage(dir, 16, 16)
+	if err != nil {
+		t.Fatalf("open leveldb storage failed: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = s.Close()
+		_ = os.RemoveAll(dir)
+	})
+	return s
+}
+
+func TestLevelDBRegisteredSwapLifecycle(t *testing.T) {
+	s := newTestLevelDBStorage(t)
+
+	swap := mongodb.NewRegisteredSwap("eth", "swap.RegisterRouterSwap", "", "0x1234", "56", "3", "http://127.0.0.1:11556/rpc")
+	if err := s.AddRegisteredSwapItem(swap); err != nil {
+		t.Fatalf("add registered swap failed: %v", err)
+	}
+	if err := s.AddRegisteredSwapItem(swap); !errors.Is(err, mongodb.ErrItemIsDup) {
+		t.Errorf("add duplicate registered swap, have error %v, want %v", err, mongodb.ErrItemIsDup)
+	}
+	if _, err := s.FindRegisterdSwapTxid("0x5678"); !errors.Is(err, mongodb.ErrItemNotFound) {
+		t.Errorf("find not exist registered swap, have error %v, want %v", err, mongodb.ErrItemNotFound)
+	}
+
+	if err := s.UpdateRegisteredSwapPosting(swap.Key, time.Now().Unix()); err != nil {
+		t.Fatalf("update registered swap to posting failed: %v", err)
+	}
+	if err := s.UpdateRegisteredSwapState(swap.Key, mongodb.StateVerified, ""); !errors.Is(err, mongodb.ErrStateTransition) {
+		t.Errorf("update registered swap from posting to verified, have error %v, want %v", err, mongodb.ErrStateTransition)
+	}
+	if swaps, _ := s.FindRegisteredSwapToRetry(10); len(swaps) != 1 {
+		t.Errorf("find registered swap to retry, have %v records, want 1", len(swaps))
+	}
+	if err := s.UpdateRegisteredSwapPostResult(swap.Key, mongodb.StatePosted, mongodb.PostResultSuccess, 0, "ok"); err != nil {
+		t.Fatalf("update registered swap post result failed: %v", err)
+	}
+
+	swaps, err := s.FindRegisterdSwapTxid("0x1234")
+	if err != nil || len(swaps) != 1 {
+		t.Fatalf("find registered swap failed: %v", err)
+	}
+	if swaps[0].Status != mongodb.StatePosted || swaps[0].PostResult != mongodb.PostResultSuccess || swaps[0].ChainID != 56 || swaps[0].LogIndex != 3 {
+		t.Errorf("registered swap mismatch: %+v", swaps[0])
+	}
+	if len(swaps[0].History) != 3 {
+		t.Errorf("registered swap history length mismatch, have %v, want 3", len(swaps[0].History))
+	}
+	if swaps, _ := s.FindRegisteredSwapWithPostResult("eth", mongodb.PostResultSuccess, 0, 10); len(swaps) != 1 {
+		t.Errorf("find registered swap with post result, have %v records, want 1", len(swaps))
+	}
+
+	if err := s.RemoveRegisteredSwap("0x1234"); err != nil {
+		t.Fatalf("remove registered swap failed: %v", err)
+	}
+	if _, err := s.FindRegisterdSwapTxid("0x1234"); !errors.Is(err, mongodb.ErrItemNotFound) {
+		t.Errorf("find removed registered swap, have error %v, want %v", err, mongodb.ErrItemNotFound)
+	}
+}
+
+func TestLevelDBRegisteredSwapIndexes(t *testing.T) {
+	s := newTestLevelDBStorage(t)
+
+	address := "0x1111111111111111111111111111111111111111"
+	for i := 0; i < 3; i++ {
+		swap := mongodb.NewRegisteredSwap("eth", "swap.RegisterRouterSwap", "", fmt.Sprintf("0x%02d", i), "56", "0", "http://127.0.0.1:11556/rpc")
+		swap.Timestamp = int64(1000 - i) // inserted in reverse order of timestamp
+		swap.Event = &mongodb.MgoRouterSwapEvent{Name: "LogAnySwapOut", From: address, To: address}
+		if err := s.AddRegisteredSwapItem(swap); err != nil {
+			t.Fatalf("add registered swap failed: %v", err)
+		}
+	}
+	swaps, _ := s.FindRegisterdSwapTxid("0x02")
+	if len(swaps) != 1 {
+		t.Fatalf("find registered swap by txid, have %v records, want 1", len(swaps))
+	}
+	if err := s.UpdateRegisteredSwapPosting(swaps[0].Key, time.Now().Unix()); err != nil {
+		t.Fatalf("update registered swap to posting failed: %v", err)
+	}
+
+	checkIndexes := func() {
+		verified, err := s.FindRegisteredSwapWithStatus("eth", mongodb.StateVerified, nil, 10)
+		if err != nil || len(verified) != 2 || verified[0].TxID != "0x01" || verified[1].TxID != "0x00" {
+			t.Errorf("find verified swaps by status index, have %v records, err %v", len(verified), err)
+		}
+		if next, _ := s.FindRegisteredSwapWithStatus("eth", mongodb.StateVerified, verified[0].Cursor(), 10); len(next) != 1 || next[0].TxID != "0x00" {
+			t.Errorf("find verified swaps after cursor, have %v records, want 1", len(next))
+		}
+		if retry, _ := s.FindRegisteredSwapToRetry(10); len(retry) != 1 || retry[0].TxID != "0x02" {
+			t.Errorf("find registered swap to retry, have %v records, want 1", len(retry))
+		}
+		if latest, _ := s.FindRegisteredSwapByAddress(address, 1, -1); len(latest) != 1 || latest[0].TxID != "0x01" {
+			t.Errorf("find second latest swap by address, have %v records, want 1", len(latest))
+		}
+		if _, registered, _ := s.CountSwapStates(); registered[mongodb.StateVerified] != 2 || registered[mongodb.StatePosting] != 1 {
+			t.Errorf("count registered swap states mismatch, have %v", registered)
+		}
+	}
+	checkIndexes()
+
+	// indexes are rebuilt if missing (eg. database written before indexes)
+	for _, prefix := range append(registeredIndexPrefixes, keyRegisteredIndexVersion) {
+		it := s.db.NewIterator([]byte(prefix), nil)
+		for it.Next() {
+			_ = s.db.Delete(append([]byte(nil), it.Key()...))
+		}
+		it.Release()
+	}
+	if swaps, _ := s.FindRegisteredSwapWithStatus("eth", mongodb.StateVerified, nil, 10); len(swaps) != 0 {
+		t.Fatalf("find swaps without indexes, have %v records, want none", len(swaps))
+	}
+	if err := s.ensureRegisteredSwapIndexes(); err != nil {
+		t.Fatalf("rebuild registered swap indexes failed: %v", err)
+	}
+	checkIndexes()
+
+	if err := s.RemoveRegisteredSwap("0x00"); err != nil {
+		t.Fatalf("remove registered swap failed: %v", err)
+	}
+	if swaps, _ := s.FindRegisteredSwapByAddress(address, 0, 10); len(swaps) != 2 {
+		t.Errorf("find swaps by address after remove, have %v records, want 2", len(swaps))
+	}
+	if count, _ := s.countIndex(getTxidIndexPrefix("0x00")); count != 0 {
+		t.Errorf("txid index of removed swap is kept, have %v entries", count)
+	}
+}
+
+func TestLevelDBSwapPendingLifecycle(t *testing.T) {
+	s := newTestLevelDBStorage(t)
+
+	if err := s.AddRegisteredSwapPending("eth", "0xaaaa"); err != nil {
+		t.Fatalf("add swap pending failed: %v", err)
+	}
+	if err := s.AddRegisteredSwapPending("eth", "0xaaaa"); !errors.Is(err, mongodb.ErrItemIsDup) {
+		t.Errorf("add duplicate swap pending, have error %v, want %v", err, mongodb.ErrItemIsDup)
+	}
+	pending, err := s.FindSwapPendingByJobID(mongodb.GetRegisterJobID("eth", "0xaaaa"))
+	if err != nil || pending.Key != "0xaaaa" {
+		t.Fatalf("find swap pending by job id failed: %v", err)
+	}
+	if err := s.UpdateSwapPendingState("0xaaaa", mongodb.StateVerified, ""); !errors.Is(err, mongodb.ErrStateTransition) {
+		t.Errorf("update swap pending from submitted to verified, have error %v, want %v", err, mongodb.ErrStateTransition)
+	}
+	pendings, registered, err := s.CountSwapStates()
+	if err != nil || pendings[mongodb.StateSubmitted] != 1 || len(registered) != 0 {
+		t.Errorf("count swap states mismatch, pending %v, registered %v, err %v", pendings, registered, err)
+	}
+
+	_ = s.UpdateSwapPendingState("0xaaaa", mongodb.StateVerifying, "")
+	if err := s.UpdateSwapPendingState("0xaaaa", mongodb.StateVerified, ""); err != nil {
+		t.Fatalf("update swap pending to verified failed: %v", err)
+	}
+	if _, err := s.FindOldestSwapPending(); !errors.Is(err, mongodb.ErrItemNotFound) {
+		t.Errorf("find oldest swap pending, have error %v, want %v", err, mongodb.ErrItemNotFound)
+	}
+}
+
+func TestLevelDBAddSwapPendings(t *testing.T) {
+	s := newTestLevelDBStorage(t)
+
+	if err := s.AddRegisteredSwapPending("eth", "0xaaaa"); err != nil {
+		t.Fatalf("add swap pending failed: %v", err)
+	}
+	items := []*mongodb.RegisterItem{
+		{Chain: "eth", TxID: "0xaaaa"},
+		{Chain: "eth", TxID: "0xbbbb"},
+		{Chain: "bsc", TxID: "0xcccc"},
+		{Chain: "eth", TxID: "0xbbbb"},
+	}
+	errs, err := s.AddRegisteredSwapPendings(items)
+	if err != nil || len(errs) != len(items) {
+		t.Fatalf("add swap pendings failed: %v", err)
+	}
+	wantErrs := []error{mongodb.ErrItemIsDup, nil, nil, mongodb.ErrItemIsDup}
+	for i, want := range wantErrs {
+		if !errors.Is(errs[i], want) {
+			t.Errorf("add swap pendings item %v, have error %v, want %v", i, errs[i], want)
+		}
+	}
+	pending, err := s.FindSwapPendingByJobID(mongodb.GetRegisterJobID("bsc", "0xcccc"))
+	if err != nil || pending.Key != "0xcccc" || pending.Status != mongodb.StateSubmitted {
+		t.Errorf("find swap pending added in batch failed: %v", err)
+	}
+	pendings, _, err := s.CountSwapStates()
+	if err != nil || pendings[mongodb.StateSubmitted] != 3 {
+		t.Errorf("count swap states mismatch, pending %v, err %v", pendings, err)
+	}
+}
+
+func TestLevelDBFindRegisteredSwapByCursor(t *testing.T) {
+	s := newTestLevelDBStorage(t)
+
+	const count = 5
+	for i := 0; i < count; i++ {
+		swap := mongodb.NewRegisteredSwap("eth", "swap.Swapin", "usdt", fmt.Sprintf("0x%02d", i), "", "0", "http://127.0.0.1:11557/rpc")
+		swap.Timestamp = 1000 + int64(i/2) // records with the same timestamp are ordered by key
+		if err := s.AddRegisteredSwapItem(swap); err != nil {
+			t.Fatalf("add registered swap failed: %v", err)
+		}
+	}
+
+	var cursor *mongodb.SwapCursor
+	var found []string
+	for {
+		swaps, err := s.FindRegisteredSwapWithStatus("eth", mongodb.StateVerified, cursor, 2)
+		if err != nil {
+			t.Fatalf("find registered swap failed: %v", err)
+		}
+		for _, swap := range swaps {
+			found = append(found, swap.TxID)
+			// records leaving the status do not shift the position of cursor
+			_ = s.UpdateRegisteredSwapPosting(swap.Key, 0)
+		}
+		if len(swaps) < 2 {
+			break
+		}
+		cursor = swaps[len(swaps)-1].Cursor()
+	}
+	if len(found) != count {
+		t.Fatalf("find registered swaps by cursor, have %v, want %v records", found, count)
+	}
+	for i, txid := range found {
+		if want := fmt.Sprintf("0x%02d", i); txid != want {
+			t.Errorf("registered swap %v mismatch, have %v, want %v", i, txid, want)
+		}
+	}
+}
+
+func TestLevelDBLeaseRegisteredSwap(t *testing.T) {
+	s := newTestLevelDBStorage(t)
+
+	swap := mongodb.NewRegisteredSwap("eth", "swap.Swapin", "usdt", "0xabcd", "", "0", "http://127.0.0.1:11557/rpc")
+	if err := s.AddRegisteredSwapItem(swap); err != nil {
+		t.Fatalf("add registered swap failed: %v", err)
+	}
+	if _, err := s.LeaseRegisteredSwap("0xnotexist", mongodb.StateVerified, time.Minute); !errors.Is(err, mongodb.ErrItemNotFound) {
+		t.Errorf("lease not exist swap, have error %v, want %v", err, mongodb.ErrItemNotFound)
+	}
+
+	oldID := mongodb.GetInstanceID()
+	defer mongodb.SetInstanceID(oldID)
+
+	mongodb.SetInstanceID("instance-1")
+	if _, err := s.LeaseRegisteredSwap(swap.Key, mongodb.StateVerified, time.Minute); err != nil {
+		t.Fatalf("lease swap failed: %v", err)
+	}
+	mongodb.SetInstanceID("instance-2")
+	if _, err := s.LeaseRegisteredSwap(swap.Key, mongodb.StateVerified, time.Minute); !errors.Is(err, mongodb.ErrItemIsLeased) {
+		t.Errorf("lease swap leased by others, have error %v, want %v", err, mongodb.ErrItemIsLeased)
+	}
+	if swaps, _ := s.FindRegisteredSwapWithStatus("", mongodb.StateVerified, nil, 10); len(swaps) != 0 {
+		t.Errorf("find swap leased by others, have %v records, want none", len(swaps))
+	}
+	mongodb.SetInstanceID("instance-1")
+	if err := s.ReleaseRegisteredSwap(swap.Key); err != nil {
+		t.Fatalf("release swap failed: %v", err)
+	}
+	mongodb.SetInstanceID("instance-2")
+	if _, err := s.LeaseRegisteredSwap(swap.Key, mongodb.StateVerified, time.Minute); err != nil {
+		t.Errorf("lease released swap failed: %v", err)
+	}
+
+	// posted by instance-2, the stale copy of instance-1 is not leased
+	if err := s.UpdateRegisteredSwapPosting(swap.Key, time.Now().Unix()+60); err != nil {
+		t.Fatalf("update swap posting failed: %v", err)
+	}
+	if err := s.ReleaseRegisteredSwap(swap.Key); err != nil {
+		t.Fatalf("release swap failed: %v", err)
+	}
+	mongodb.SetInstanceID("instance-1")
+	if _, err := s.LeaseRegisteredSwap(swap.Key, mongodb.StateVerified, time.Minute); !errors.Is(err, mongodb.ErrItemIsChanged) {
+		t.Errorf("lease swap in stale status, have error %v, want %v", err, mongodb.ErrItemIsChanged)
+	}
+	if _, err := s.LeaseRegisteredSwap(swap.Key, mongodb.StatePosting, time.Minute); !errors.Is(err, mongodb.ErrItemIsChanged) {
+		t.Errorf("lease swap before next attempt, have error %v, want %v", err, mongodb.ErrItemIsChanged)
+	}
+	if err := s.UpdateRegisteredSwapRetry(swap.Key, 1, "timeout", time.Now().Unix(), mongodb.StatePosting); err != nil {
+		t.Fatalf("update swap retry failed: %v", err)
+	}
+	leased, err := s.LeaseRegisteredSwap(swap.Key, mongodb.StatePosting, time.Minute)
+	if err != nil {
+		t.Fatalf("lease swap to retry failed: %v", err)
+	}
+	if leased.Status != mongodb.StatePosting || leased.Attempts != 1 || leased.LockedBy != "instance-1" {
+		t.Errorf("leased swap mismatch, have status %v attempts %v lockedby %v", leased.Status, leased.Attempts, leased.LockedBy)
+	}
+}
+
+func TestLevelDBLeaseChainScan(t *testing.T) {
+	s := newTestLevelDBStorage(t)
+
+	oldID := mongodb.GetInstanceID()
+	defer mongodb.SetInstanceID(oldID)
+
+	mongodb.SetInstanceID("instance-1")
+	if err := s.LeaseChainScan("eth", time.Minute); err != nil {
+		t.Fatalf("lease chain scan failed: %v", err)
+	}
+	if err := s.UpdateChainScanInfo("eth", 100); err != nil {
+		t.Fatalf("update chain scan info failed: %v", err)
+	}
+	mongodb.SetInstanceID("instance-2")
+	if err := s.LeaseChainScan("eth", time.Minute); !errors.Is(err, mongodb.ErrItemIsLeased) {
+		t.Errorf("lease chain scan leased by others, have error %v, want %v", err, mongodb.ErrItemIsLeased)
+	}
+	if err := s.UpdateChainScanInfo("eth", 200); !errors.Is(err, mongodb.ErrItemIsLeased) {
+		t.Errorf("update chain scan info leased by others, have error %v, want %v", err, mongodb.ErrItemIsLeased)
+	}
+	mongodb.SetInstanceID("instance-1")
+	if err := s.ReleaseChainScan("eth"); err != nil {
+		t.Fatalf("release chain scan failed: %v", err)
+	}
+	mongodb.SetInstanceID("instance-2")
+	if err := s.LeaseChainScan("eth", time.Minute); err != nil {
+		t.Errorf("lease released chain scan failed: %v", err)
+	}
+	info, err := s.FindChainScanInfo("eth")
+	if err != nil || info.BlockHeight != 100 || info.LockedBy != "instance-2" {
+		t.Errorf("chain scan info mismatch, have %+v, err %v", info, err)
+	}
+}
+
+func TestLevelDBWebhookEvent(t *testing.T) {
+	s := newTestLevelDBStorage(t)
+
+	ev := &mongodb.MgoWebhookEvent{
+		Key:       "0x1234:posted:hook",
+		Webhook:   "hook",
+		Event:     "posted",
+		Status:    mongodb.WebhookEventPending,
+		Timestamp: time.Now().Unix(),
+	}
+	if err := s.AddWebhookEvent(ev); err != nil {
+		t.Fatalf("add webhook event failed: %v", err)
+	}
+	if err := s.AddWebhookEvent(ev); err != nil {
+		t.Errorf("add duplicate webhook event, have error %v, want nil", err)
+	}
+	if events, _ := s.FindWebhookEventsToDeliver(10); len(events) != 1 {
+		t.Fatalf("find webhook events to deliver, have %v records, want 1", len(events))
+	}
+	if err := s.UpdateWebhookEventDelivery(ev.Key, mongodb.WebhookEventDelivered, 1, "", 0); err != nil {
+		t.Fatalf("update webhook event delivery failed: %v", err)
+	}
+	if events, _ := s.FindWebhookEventsToDeliver(10); len(events) != 0 {
+		t.Errorf("find delivered webhook events, have %v records, want none", len(events))
+	}
+}
+
+func TestLevelDBArchiveSwaps(t *testing.T) {
+	s := newTestLevelDBStorage(t)
+
+	posted := mongodb.NewRegisteredSwap("eth", "swap.Swapin", "usdt", "0x01", "", "0", "http://127.0.0.1:11557/rpc")
+	posted.Status = mongodb.StatePosted
+	verified := mongodb.NewRegisteredSwap("eth", "swap.Swapin", "usdt", "0x02", "", "0", "http://127.0.0.1:11557/rpc")
+	deleted := mongodb.NewRegisteredSwap("eth", "swap.Swapin", "usdt", "0x03", "", "0", "http://127.0.0.1:11557/rpc")
+	for _, swap := range []*mongodb.MgoRegisteredSwap{posted, verified, deleted} {
+		if err := s.AddRegisteredSwapItem(swap); err != nil {
+			t.Fatalf("add registered swap failed: %v", err)
+		}
+	}
+	if err := s.RemoveRegisteredSwap("0x03"); err != nil {
+		t.Fatalf("remove registered swap failed: %v", err)
+	}
+
+	before := time.Now().Unix() + 1
+	swaps, err := s.FindSwapsToArchive(before, 10)
+	if err != nil || len(swaps) != 2 {
+		t.Fatalf("find swaps to archive, have %v records, want 2 (posted and deleted), err %v", len(swaps), err)
+	}
+	if swaps, _ := s.FindSwapsToArchive(before-3600, 10); len(swaps) != 0 {
+		t.Errorf("find swaps to archive registered later, have %v records, want none", len(swaps))
+	}
+	if err = s.AddArchivedSwaps(swaps); err != nil {
+		t.Fatalf("add archived swaps failed: %v", err)
+	}
+	if err = s.RemoveArchivedSources(swaps); err != nil {
+		t.Fatalf("remove archived sources failed: %v", err)
+	}
+	if swaps, _ := s.FindSwapsToArchive(before, 10); len(swaps) != 0 {
+		t.Errorf("find swaps to archive after archived, have %v records, want none", len(swaps))
+	}
+	if _, err = s.FindRegisterdSwapTxid("0x01"); !errors.Is(err, mongodb.ErrItemNotFound) {
+		t.Errorf("find archived registered swap, have error %v, want %v", err, mongodb.ErrItemNotFound)
+	}
+	if _, err = s.FindRegisterdSwapTxid("0x02"); err != nil {
+		t.Errorf("find not finished registered swap failed: %v", err)
+	}
+
+	archived, err := s.FindArchivedSwaps(&mongodb.ArchiveFilter{TxID: "0x01"}, 0)
+	if err != nil || len(archived) != 1 || archived[0].Kind != mongodb.ArchiveKindRegistered {
+		t.Fatalf("find archived swaps by txid failed: %v", err)
+	}
+	if err = s.RestoreArchivedSwaps(archived); err != nil {
+		t.Fatalf("restore archived swaps failed: %v", err)
+	}
+	if restored, err := s.FindRegisterdSwapTxid("0x01"); err != nil || restored[0].Status != mongodb.StatePosted {
+		t.Errorf("find restored registered swap failed: %v", err)
+	}
+
+	count, err := s.PurgeArchivedSwaps(&mongodb.ArchiveFilter{From: 0})
+	if err != nil || count != 1 {
+		t.Errorf("purge archived swaps, have %v records, want 1 (deleted), err %v", count, err)
+	}
+	if archived, _ := s.FindArchivedSwaps(&mongodb.ArchiveFilter{From: 0}, 0); len(archived) != 0 {
+		t.Errorf("find purged archived swaps, have %v records, want none", len(archived))
+	}
+}
@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/weijun-sh/gethscan-server/leveldb"
+	"github.com/weijun-sh/gethscan-server/log"
+	"github.com/weijun-sh/gethscan-server/mongodb"
+)
+
+// secondary indexes of registered swaps, so polling queries do not decode the whole table.
+// index keys are '<index prefix><field>/[<padded timestamp>/]<swap key>',
+// index values are swap keys. indexes are written in the same batch as the swap record.
+const (
+	prefixRegisteredByStatus     = "registered-status/"
+	prefixRegisteredByTxid       = "registered-txid/"
+	prefixRegisteredByPostResult = "registered-postresult/"
+	prefixRegisteredByAddress    = "registered-address/"
+
+	// indexes are rebuilt when opening database with another index version
+	keyRegisteredIndexVersion = "meta/registeredindex"
+	registeredIndexVersion    = "1"
+
+	rebuildIndexBatchBytes = 100 * 1024
+)
+
+var registeredIndexPrefixes = []string{
+	prefixRegisteredByStatus,
+	prefixRegisteredByTxid,
+	prefixRegisteredByPostResult,
+	prefixRegisteredByAddress,
+}
+
+// getIndexTimestamp padded timestamp, index keys are sorted by timestamp
+func getIndexTimestamp(timestamp int64) string {
+	if timestamp < 0 {
+		timestamp = 0
+	}
+	return fmt.Sprintf("%020d", timestamp)
+}
+
+func getStatusIndexPrefix(status mongodb.SwapState) string {
+	return prefixRegisteredByStatus + string(status) + "/"
+}
+
+func getTxidIndexPrefix(txid string) string {
+	return prefixRegisteredByTxid + txid + "/"
+}
+
+func getPostResultIndexPrefix(postResult string) string {
+	return prefixRegisteredByPostResult + postResult + "/"
+}
+
+func getAddressIndexPrefix(address string) string {
+	return prefixRegisteredByAddress + strings.ToLower(address) + "/"
+}
+
+// getRegisteredSwapIndexKeys get index keys of registered swap
+func getRegisteredSwapIndexKeys(swap *mongodb.MgoRegisteredSwap) []string {
+	timestamp := getIndexTimestamp(swap.Timestamp)
+	txid := swap.TxID
+	if txid == "" {
+		txid = swap.Key // records before keyed by txid + logindex + swapserver has no txid field
+	}
+	keys := []string{
+		getStatusIndexPrefix(swap.Status) + timestamp + "/" + swap.Key,
+		getTxidIndexPrefix(txid) + swap.Key,
+	}
+	if swap.PostResult != "" {
+		keys = append(keys, getPostResultIndexPrefix(swap.PostResult)+timestamp+"/"+swap.Key)
+	}
+	if swap.Event != nil {
+		if swap.Event.From != "" {
+			keys = append(keys, getAddressIndexPrefix(swap.Event.From)+timestamp+"/"+swap.Key)
+		}
+		if swap.Event.To != "" && !strings.EqualFold(swap.Event.To, swap.Event.From) {
+			keys = append(keys, getAddressIndexPrefix(swap.Event.To)+timestamp+"/"+swap.Key)
+		}
+	}
+	return keys
+}
+
+// putRegisteredSwap put registered swap and its indexes into batch,
+// index keys of the record before (if any) are deleted.
+func putRegisteredSwap(batch leveldb.Batch, oldIndexKeys []string, swap *mongodb.MgoRegisteredSwap) error {
+	data, err := json.Marshal(swap)
+	if err != nil {
+		return err
+	}
+	for _, key := range oldIndexKeys {
+		_ = batch.Delete([]byte(key))
+	}
+	_ = batch.Put([]byte(prefixRegisteredSwap+swap.Key), data)
+	for _, key := range getRegisteredSwapIndexKeys(swap) {
+		_ = batch.Put([]byte(key), []byte(swap.Key))
+	}
+	return nil
+}
+
+// deleteRegisteredSwap delete registered swap and its indexes in batch
+func deleteRegisteredSwap(batch leveldb.Batch, swap *mongodb.MgoRegisteredSwap) {
+	_ = batch.Delete([]byte(prefixRegisteredSwap + swap.Key))
+	for _, key := range getRegisteredSwapIndexKeys(swap) {
+		_ = batch.Delete([]byte(key))
+	}
+}
+
+// writeRegisteredSwap write registered swap and its indexes
+func (s *LevelDBStorage) writeRegisteredSwap(oldIndexKeys []string, swap *mongodb.MgoRegisteredSwap) error {
+	batch := s.db.NewBatch()
+	if err := putRegisteredSwap(batch, oldIndexKeys, swap); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+// insertRegisteredSwap write registered swap if key not exist, otherwise return mongodb.ErrItemIsDup
+func (s *LevelDBStorage) insertRegisteredSwap(swap *mongodb.MgoRegisteredSwap) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exist, err := s.db.Has([]byte(prefixRegisteredSwap + swap.Key))
+	if err != nil {
+		return err
+	}
+	if exist {
+		return mongodb.ErrItemIsDup
+	}
+	return s.writeRegisteredSwap(nil, swap)
+}
+
+// iterateIndex call fn with swap key of every index entry with prefix from start (inclusive),
+// stop if fn returns false or error.
+func (s *LevelDBStorage) iterateIndex(prefix, start string, fn func(key string) (bool, error)) error {
+	it := s.db.NewIterator([]byte(prefix), []byte(start))
+	defer it.Release()
+	for it.Next() {
+		next, err := fn(string(it.Value()))
+		if err != nil || !next {
+			return err
+		}
+	}
+	return it.Error()
+}
+
+// iterateIndexedSwaps call fn with every registered swap of index entries,
+// records removed after reading the index are skipped.
+func (s *LevelDBStorage) iterateIndexedSwaps(prefix, start string, fn func(swap *mongodb.MgoRegisteredSwap) bool) error {
+	return s.iterateIndex(prefix, start, func(key string) (bool, error) {
+		var swap mongodb.MgoRegisteredSwap
+		err := s.get(prefixRegisteredSwap, key, &swap)
+		if err == mongodb.ErrItemNotFound {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return fn(&swap), nil
+	})
+}
+
+// findIndexedSwapsPage get page of registered swaps of index sorted by timestamp,
+// negative limit means latest first.
+func (s *LevelDBStorage) findIndexedSwapsPage(prefix string, match func(*mongodb.MgoRegisteredSwap) bool, offset, limit int) ([]*mongodb.MgoRegisteredSwap, error) {
+	result := make([]*mongodb.MgoRegisteredSwap, 0, 20)
+	if limit < 0 {
+		// index is in timestamp ascending order, collect all then page
+		err := s.iterateIndexedSwaps(prefix, "", func(swap *mongodb.MgoRegisteredSwap) bool {
+			if match(swap) {
+				result = append(result, swap)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		return pageRegisteredSwaps(result, offset, limit), nil
+	}
+	skipped := 0
+	err := s.iterateIndexedSwaps(prefix, "", func(swap *mongodb.MgoRegisteredSwap) bool {
+		if !match(swap) {
+			return true
+		}
+		if skipped < offset {
+			skipped++
+			return true
+		}
+		result = append(result, swap)
+		return limit == 0 || len(result) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// countIndex count index entries with prefix
+func (s *LevelDBStorage) countIndex(prefix string) (count int, err error) {
+	err = s.iterateIndex(prefix, "", func(string) (bool, error) {
+		count++
+		return true, nil
+	})
+	return count, err
+}
+
+// ensureRegisteredSwapIndexes rebuild indexes of registered swaps
+// if they are built by another index version (or not built before).
+func (s *LevelDBStorage) ensureRegisteredSwapIndexes() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	version, err := s.db.Get([]byte(keyRegisteredIndexVersion))
+	if err == nil && string(version) == registeredIndexVersion {
+		return nil
+	}
+	if err != nil && !leveldb.IsNotFoundErr(err) {
+		return err
+	}
+	err = nil
+	batch := s.db.NewBatch()
+	flush := func(force bool) error {
+		if !force && batch.ValueSize() < rebuildIndexBatchBytes {
+			return nil
+		}
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		batch.Reset()
+		return nil
+	}
+	for _, prefix := range registeredIndexPrefixes {
+		it := s.db.NewIterator([]byte(prefix), nil)
+		for it.Next() {
+			_ = batch.Delete(append([]byte(nil), it.Key()...))
+			if err = flush(false); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = it.Error()
+		}
+		it.Release()
+		if err != nil {
+			return err
+		}
+	}
+	count := 0
+	err = s.iterate(prefixRegisteredSwap, func(value []byte) error {
+		var swap mongodb.MgoRegisteredSwap
+		if err := json.Unmarshal(value, &swap); err != nil {
+			return err
+		}
+		for _, key := range getRegisteredSwapIndexKeys(&swap) {
+			_ = batch.Put([]byte(key), []byte(swap.Key))
+		}
+		count++
+		return flush(false)
+	})
+	if err != nil {
+		return err
+	}
+	_ = batch.Put([]byte(keyRegisteredIndexVersion), []byte(registeredIndexVersion))
+	if err = flush(true); err != nil {
+		return err
+	}
+	log.Info("[leveldb] rebuild registered swap indexes", "version", registeredIndexVersion, "count", count)
+	return nil
+}
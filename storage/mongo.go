@@ -0,0 +1,235 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/weijun-sh/gethscan-server/mongodb"
+)
+
+// MongoStorage storage in mongodb, the session is initialized by mongodb.MongoServerInit
+type MongoStorage struct{}
+
+// NewMongoStorage new mongodb storage
+func NewMongoStorage() *MongoStorage {
+	return &MongoStorage{}
+}
+
+// AddRegisteredSwapPending impl
+func (s *MongoStorage) AddRegisteredSwapPending(chain, txid string) error {
+	return mongodb.AddRegisteredSwapPending(chain, txid)
+}
+
+// AddRegisteredSwapPendings impl
+func (s *MongoStorage) AddRegisteredSwapPendings(items []*mongodb.RegisterItem) ([]error, error) {
+	return mongodb.AddRegisteredSwapPendings(items)
+}
+
+// FindSwapPendingStatus impl
+func (s *MongoStorage) FindSwapPendingStatus(txid string) (*mongodb.MgoRegisteredSwapPending, error) {
+	return mongodb.FindSwapPendingStatus(txid)
+}
+
+// FindSwapPendingByJobID impl
+func (s *MongoStorage) FindSwapPendingByJobID(jobID string) (*mongodb.MgoRegisteredSwapPending, error) {
+	return mongodb.FindSwapPendingByJobID(jobID)
+}
+
+// FindSwapPending impl
+func (s *MongoStorage) FindSwapPending(chain string, cursor *mongodb.SwapCursor, limit int) ([]*mongodb.MgoRegisteredSwapPending, error) {
+	return mongodb.FindSwapPending(chain, cursor, limit)
+}
+
+// FindOldestSwapPending impl
+func (s *MongoStorage) FindOldestSwapPending() (*mongodb.MgoRegisteredSwapPending, error) {
+	return mongodb.FindOldestSwapPending()
+}
+
+// UpdateSwapPendingJobID impl
+func (s *MongoStorage) UpdateSwapPendingJobID(txid, jobID string) error {
+	return mongodb.UpdateSwapPendingJobID(txid, jobID)
+}
+
+// UpdateSwapPendingState impl
+func (s *MongoStorage) UpdateSwapPendingState(txid string, to mongodb.SwapState, message string) error {
+	return mongodb.UpdateSwapPendingState(txid, to, message)
+}
+
+// LeaseSwapPending impl
+func (s *MongoStorage) LeaseSwapPending(txid string, duration time.Duration) error {
+	return mongodb.LeaseSwapPending(txid, duration)
+}
+
+// ReleaseSwapPending impl
+func (s *MongoStorage) ReleaseSwapPending(txid string) error {
+	return mongodb.ReleaseSwapPending(txid)
+}
+
+// AddRegisteredSwapItem impl
+func (s *MongoStorage) AddRegisteredSwapItem(swap *mongodb.MgoRegisteredSwap) error {
+	return mongodb.AddRegisteredSwapItem(swap)
+}
+
+// FindRegisterdSwapTxid impl
+func (s *MongoStorage) FindRegisterdSwapTxid(txid string) ([]*mongodb.MgoRegisteredSwap, error) {
+	return mongodb.FindRegisterdSwapTxid(txid)
+}
+
+// FindRegisteredSwapWithStatus impl
+func (s *MongoStorage) FindRegisteredSwapWithStatus(chain string, status mongodb.SwapState, cursor *mongodb.SwapCursor, limit int) ([]*mongodb.MgoRegisteredSwap, error) {
+	return mongodb.FindRegisteredSwapWithStatus(chain, status, cursor, limit)
+}
+
+// FindRegisteredSwapToRetry impl
+func (s *MongoStorage) FindRegisteredSwapToRetry(limit int) ([]*mongodb.MgoRegisteredSwap, error) {
+	return mongodb.FindRegisteredSwapToRetry(limit)
+}
+
+// FindRegisteredSwapWithPostResult impl
+func (s *MongoStorage) FindRegisteredSwapWithPostResult(chain, postResult string, offset, limit int) ([]*mongodb.MgoRegisteredSwap, error) {
+	return mongodb.FindRegisteredSwapWithPostResult(chain, postResult, offset, limit)
+}
+
+// FindRegisteredSwapByAddress impl
+func (s *MongoStorage) FindRegisteredSwapByAddress(address string, offset, limit int) ([]*mongodb.MgoRegisteredSwap, error) {
+	return mongodb.FindRegisteredSwapByAddress(address, offset, limit)
+}
+
+// UpdateRegisteredSwapState impl
+func (s *MongoStorage) UpdateRegisteredSwapState(key string, to mongodb.SwapState, message string) error {
+	return mongodb.UpdateRegisteredSwapState(key, to, message)
+}
+
+// UpdateRegisteredSwapPosting impl
+func (s *MongoStorage) UpdateRegisteredSwapPosting(key string, nextAttempt int64) error {
+	return mongodb.UpdateRegisteredSwapPosting(key, nextAttempt)
+}
+
+// UpdateRegisteredSwapPostResult impl
+func (s *MongoStorage) UpdateRegisteredSwapPostResult(key string, state mongodb.SwapState, postResult string, errCode int, response string) error {
+	return mongodb.UpdateRegisteredSwapPostResult(key, state, postResult, errCode, response)
+}
+
+// UpdateRegisteredSwapRetry impl
+func (s *MongoStorage) UpdateRegisteredSwapRetry(key string, attempts int, lastError string, nextAttempt int64, state mongodb.SwapState) error {
+	return mongodb.UpdateRegisteredSwapRetry(key, attempts, lastError, nextAttempt, state)
+}
+
+// UpdateRegisteredSwapBlock impl
+func (s *MongoStorage) UpdateRegisteredSwapBlock(key string, blockHeight uint64, blockHash string, state mongodb.SwapState) error {
+	return mongodb.UpdateRegisteredSwapBlock(key, blockHeight, blockHash, state)
+}
+
+// UpdateRegisteredSwapTxMissing impl
+func (s *MongoStorage) UpdateRegisteredSwapTxMissing(key string, missingChecks int, missingSince int64, message string) error {
+	return mongodb.UpdateRegisteredSwapTxMissing(key, missingChecks, missingSince, message)
+}
+
+// LeaseRegisteredSwap impl
+func (s *MongoStorage) LeaseRegisteredSwap(key string, status mongodb.SwapState, duration time.Duration) (*mongodb.MgoRegisteredSwap, error) {
+	return mongodb.LeaseRegisteredSwap(key, status, duration)
+}
+
+// ReleaseRegisteredSwap impl
+func (s *MongoStorage) ReleaseRegisteredSwap(key string) error {
+	return mongodb.ReleaseRegisteredSwap(key)
+}
+
+// AddSwapPost impl
+func (s *MongoStorage) AddSwapPost(post *mongodb.MgoRegisteredSwap) error {
+	return mongodb.AddSwapPost(post)
+}
+
+// RemoveRegisteredSwap impl
+func (s *MongoStorage) RemoveRegisteredSwap(txid string) error {
+	return mongodb.RemoveRegisteredSwap(txid)
+}
+
+// FindSwapsToArchive impl
+func (s *MongoStorage) FindSwapsToArchive(before int64, limit int) ([]*mongodb.MgoArchivedSwap, error) {
+	return mongodb.FindSwapsToArchive(before, limit)
+}
+
+// AddArchivedSwaps impl
+func (s *MongoStorage) AddArchivedSwaps(swaps []*mongodb.MgoArchivedSwap) error {
+	return mongodb.AddArchivedSwaps(swaps)
+}
+
+// RemoveArchivedSources impl
+func (s *MongoStorage) RemoveArchivedSources(swaps []*mongodb.MgoArchivedSwap) error {
+	return mongodb.RemoveArchivedSources(swaps)
+}
+
+// FindArchivedSwaps impl
+func (s *MongoStorage) FindArchivedSwaps(filter *mongodb.ArchiveFilter, limit int) ([]*mongodb.MgoArchivedSwap, error) {
+	return mongodb.FindArchivedSwaps(filter, limit)
+}
+
+// RestoreArchivedSwaps impl
+func (s *MongoStorage) RestoreArchivedSwaps(swaps []*mongodb.MgoArchivedSwap) error {
+	return mongodb.RestoreArchivedSwaps(swaps)
+}
+
+// PurgeArchivedSwaps impl
+func (s *MongoStorage) PurgeArchivedSwaps(filter *mongodb.ArchiveFilter) (int, error) {
+	return mongodb.PurgeArchivedSwaps(filter)
+}
+
+// CountSwapStates impl
+func (s *MongoStorage) CountSwapStates() (pending, registered map[mongodb.SwapState]int, err error) {
+	return mongodb.CountSwapStates()
+}
+
+// UpdateChainScanInfo impl
+func (s *MongoStorage) UpdateChainScanInfo(chain string, blockHeight uint64) error {
+	return mongodb.UpdateChainScanInfo(chain, blockHeight)
+}
+
+// FindChainScanInfo impl
+func (s *MongoStorage) FindChainScanInfo(chain string) (*mongodb.MgoLatestScanInfo, error) {
+	return mongodb.FindChainScanInfo(chain)
+}
+
+// LeaseChainScan impl
+func (s *MongoStorage) LeaseChainScan(chain string, duration time.Duration) error {
+	return mongodb.LeaseChainScan(chain, duration)
+}
+
+// ReleaseChainScan impl
+func (s *MongoStorage) ReleaseChainScan(chain string) error {
+	return mongodb.ReleaseChainScan(chain)
+}
+
+// AddWebhookEvent impl
+func (s *MongoStorage) AddWebhookEvent(ev *mongodb.MgoWebhookEvent) error {
+	return mongodb.AddWebhookEvent(ev)
+}
+
+// FindWebhookEventsToDeliver impl
+func (s *MongoStorage) FindWebhookEventsToDeliver(limit int) ([]*mongodb.MgoWebhookEvent, error) {
+	return mongodb.FindWebhookEventsToDeliver(limit)
+}
+
+// UpdateWebhookEventDelivery impl
+func (s *MongoStorage) UpdateWebhookEventDelivery(key, status string, attempts int, lastError string, nextAttempt int64) error {
+	return mongodb.UpdateWebhookEventDelivery(key, status, attempts, lastError, nextAttempt)
+}
+
+// LeaseWebhookEvent impl
+func (s *MongoStorage) LeaseWebhookEvent(key string, duration time.Duration) error {
+	return mongodb.LeaseWebhookEvent(key, duration)
+}
+
+// ReleaseWebhookEvent impl
+func (s *MongoStorage) ReleaseWebhookEvent(key string) error {
+	return mongodb.ReleaseWebhookEvent(key)
+}
+
+// Ping impl
+func (s *MongoStorage) Ping(timeout time.Duration) error {
+	return mongodb.PingSession(timeout)
+}
+
+// Type impl
+func (s *MongoStorage) Type() string {
+	return "mongodb"
+}
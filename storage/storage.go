@@ -0,0 +1,99 @@
+// Package storage defines the storage of scan server records
+// (swap pending, registered, posted, deleted and archived swaps, scan info and webhook events).
+// mongodb is the default implementation, an embedded leveldb implementation
+// lets small deployments and unit tests run without mongodb.
+package storage
+
+import (
+	"time"
+
+	"github.com/weijun-sh/gethscan-server/mongodb"
+)
+
+// Storage storage of scan server records,
+// implementations return the special errors defined in mongodb package
+// (eg. mongodb.ErrItemNotFound, mongodb.ErrItemIsDup, mongodb.ErrStateTransition).
+type Storage interface {
+	// swap pending (registered by chain and txid, verified in background)
+	AddRegisteredSwapPending(chain, txid string) error
+	AddRegisteredSwapPendings(items []*mongodb.RegisterItem) ([]error, error) // error of every item
+	FindSwapPendingStatus(txid string) (*mongodb.MgoRegisteredSwapPending, error)
+	FindSwapPendingByJobID(jobID string) (*mongodb.MgoRegisteredSwapPending, error)
+	FindSwapPending(chain string, cursor *mongodb.SwapCursor, limit int) ([]*mongodb.MgoRegisteredSwapPending, error)
+	FindOldestSwapPending() (*mongodb.MgoRegisteredSwapPending, error)
+	UpdateSwapPendingJobID(txid, jobID string) error
+	UpdateSwapPendingState(txid string, to mongodb.SwapState, message string) error
+	LeaseSwapPending(txid string, duration time.Duration) error
+	ReleaseSwapPending(txid string) error
+
+	// registered swap (one per swap log, posted to swap server)
+	AddRegisteredSwapItem(swap *mongodb.MgoRegisteredSwap) error
+	FindRegisterdSwapTxid(txid string) ([]*mongodb.MgoRegisteredSwap, error)
+	FindRegisteredSwapWithStatus(chain string, status mongodb.SwapState, cursor *mongodb.SwapCursor, limit int) ([]*mongodb.MgoRegisteredSwap, error)
+	FindRegisteredSwapToRetry(limit int) ([]*mongodb.MgoRegisteredSwap, error)
+	FindRegisteredSwapWithPostResult(chain, postResult string, offset, limit int) ([]*mongodb.MgoRegisteredSwap, error)
+	FindRegisteredSwapByAddress(address string, offset, limit int) ([]*mongodb.MgoRegisteredSwap, error)
+	UpdateRegisteredSwapState(key string, to mongodb.SwapState, message string) error
+	UpdateRegisteredSwapPosting(key string, nextAttempt int64) error
+	UpdateRegisteredSwapPostResult(key string, state mongodb.SwapState, postResult string, errCode int, response string) error
+	UpdateRegisteredSwapRetry(key string, attempts int, lastError string, nextAttempt int64, state mongodb.SwapState) error
+	UpdateRegisteredSwapBlock(key string, blockHeight uint64, blockHash string, state mongodb.SwapState) error
+	UpdateRegisteredSwapTxMissing(key string, missingChecks int, missingSince int64, message string) error
+	LeaseRegisteredSwap(key string, status mongodb.SwapState, duration time.Duration) (*mongodb.MgoRegisteredSwap, error)
+	ReleaseRegisteredSwap(key string) error
+
+	// posted and deleted swap
+	AddSwapPost(post *mongodb.MgoRegisteredSwap) error
+	RemoveRegisteredSwap(txid string) error
+
+	// archive of finished swaps (swap pending, registered, posted and deleted swaps)
+	FindSwapsToArchive(before int64, limit int) ([]*mongodb.MgoArchivedSwap, error)
+	AddArchivedSwaps(swaps []*mongodb.MgoArchivedSwap) error
+	RemoveArchivedSources(swaps []*mongodb.MgoArchivedSwap) error
+	FindArchivedSwaps(filter *mongodb.ArchiveFilter, limit int) ([]*mongodb.MgoArchivedSwap, error)
+	RestoreArchivedSwaps(swaps []*mongodb.MgoArchivedSwap) error
+	PurgeArchivedSwaps(filter *mongodb.ArchiveFilter) (int, error)
+
+	// count records of swap pending and registered swap in not final states
+	CountSwapStates() (pending, registered map[mongodb.SwapState]int, err error)
+
+	// latest scanned block height of chain, updated by the lease holder of scanning the chain
+	UpdateChainScanInfo(chain string, blockHeight uint64) error
+	FindChainScanInfo(chain string) (*mongodb.MgoLatestScanInfo, error)
+	LeaseChainScan(chain string, duration time.Duration) error
+	ReleaseChainScan(chain string) error
+
+	// webhook event
+	AddWebhookEvent(ev *mongodb.MgoWebhookEvent) error
+	FindWebhookEventsToDeliver(limit int) ([]*mongodb.MgoWebhookEvent, error)
+	UpdateWebhookEventDelivery(key, status string, attempts int, lastError string, nextAttempt int64) error
+	LeaseWebhookEvent(key string, duration time.Duration) error
+	ReleaseWebhookEvent(key string) error
+
+	// Ping check storage is available with a short timeout (eg. for health check)
+	Ping(timeout time.Duration) error
+	// Type storage backend type, 'mongodb' or 'leveldb'
+	Type() string
+}
+
+var db Storage = NewMongoStorage()
+
+// SetStorage set storage of scan server records, default is mongodb
+func SetStorage(s Storage) {
+	db = s
+}
+
+// DB get storage of scan server records
+func DB() Storage {
+	return db
+}
+
+// FindRegisterdSwap find verified registered swaps to post
+func FindRegisterdSwap(chain string, cursor *mongodb.SwapCursor, limit int) ([]*mongodb.MgoRegisteredSwap, error) {
+	return db.FindRegisteredSwapWithStatus(chain, mongodb.StateVerified, cursor, limit)
+}
+
+// AddRegisteredSwap add register swap
+func AddRegisteredSwap(chain, method, pairid, txid, chainid, logIndex, swapServer string) error {
+	return db.AddRegisteredSwapItem(mongodb.NewRegisteredSwap(chain, method, pairid, txid, chainid, logIndex, swapServer))
+}
@@ -0,0 +1,133 @@
+package eth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jowenshaw/gethclient/common"
+	"github.com/jowenshaw/gethclient/types/ethereum"
+
+	"github.com/weijun-sh/gethscan-server/log"
+	"github.com/weijun-sh/gethscan-server/mongodb"
+	"github.com/weijun-sh/gethscan-server/storage"
+)
+
+const maxCheckConfirmationsLimit = 100
+
+var (
+	// swap whose tx is not found is failed after so many checks and so long time,
+	// before that it waits the tx to be packed in another block after reorg.
+	maxSwapTxMissingChecks = 10
+	swapTxMissingTimeout   = int64(30 * 60) // seconds
+)
+
+var (
+	errTxWithWrongReceiptStatus = errors.New("tx with wrong receipt status")
+	errWaitConfirmations        = errors.New("swap is waiting confirmations")
+	errSwapTxMissing            = errors.New("swap tx is not found, maybe reorged")
+	errSwapReorged              = errors.New("swap tx is reorged")
+)
+
+// CheckSwapConfirmations check registered swaps waiting confirmations of all chains
+func CheckSwapConfirmations() {
+	for _, scanner := range getChainScanners() {
+		if scanner.confirmations == 0 {
+			continue
+		}
+		scanner.checkSwapConfirmations()
+	}
+}
+
+func (scanner *ethSwapScanner) checkSwapConfirmations() {
+	swaps, err := storage.DB().FindRegisteredSwapWithStatus(scanner.chain, mongodb.StateVerifying, scanner.confirmationsCursor, maxCheckConfirmationsLimit)
+	if err != nil || len(swaps) < maxCheckConfirmationsLimit {
+		scanner.confirmationsCursor = nil // start from the oldest next time
+	} else {
+		scanner.confirmationsCursor = swaps[len(swaps)-1].Cursor()
+	}
+	if err != nil || len(swaps) == 0 {
+		return
+	}
+	latest := scanner.loopGetLatestBlockNumber()
+	for _, swap := range swaps {
+		_ = scanner.checkSwapBlock(swap, latest)
+	}
+}
+
+// RecheckSwapBlock recheck the block of swap tx before posting.
+// return error if the swap should not be posted now.
+func RecheckSwapBlock(swap *mongodb.MgoRegisteredSwap) error {
+	if swap.BlockHash == "" {
+		return nil // registered without waiting confirmations
+	}
+	scanner := GetChainScanner(swap.Chain)
+	if scanner == nil {
+		return nil
+	}
+	// called while holding the post lease, do not retry until success,
+	// every gateway call is bounded by gateway call timeout
+	latest, err := scanner.getLatestBlockNumber()
+	if err != nil {
+		log.Warn("recheck swap block failed", "chain", scanner.chain, "txid", swap.TxID, "err", err)
+		return err
+	}
+	return scanner.checkSwapBlock(swap, latest)
+}
+
+// checkSwapBlock check the block of swap tx, update swap state
+// to Verified if stable, back to Verifying if not stable or tx not found,
+// and to Failed if tx is still not found after several checks.
+func (scanner *ethSwapScanner) checkSwapBlock(swap *mongodb.MgoRegisteredSwap, latest uint64) error {
+	txid := swap.TxID
+	receipt, err := scanner.loopGetTxReceipt(common.HexToHash(txid))
+	switch {
+	case errors.Is(err, ethereum.NotFound), errors.Is(err, errTxWithWrongReceiptStatus):
+		return scanner.markSwapTxMissing(swap, err)
+	case err != nil:
+		log.Warn("check swap block failed", "chain", scanner.chain, "txid", txid, "err", err)
+		return err
+	}
+
+	blockHeight := receipt.BlockNumber.Uint64()
+	blockHash := receipt.BlockHash.Hex()
+	if blockHash != swap.BlockHash {
+		log.Info("swap tx is packed in another block", "chain", scanner.chain, "txid", txid, "oldBlock", swap.BlockHash, "newBlock", blockHash)
+	}
+
+	state := mongodb.StateVerifying
+	err = errWaitConfirmations
+	if latest >= blockHeight+scanner.confirmations {
+		state = swap.Status // keep verified or posting
+		if state == mongodb.StateVerifying {
+			state = mongodb.StateVerified
+		}
+		err = nil
+	}
+	if state != swap.Status || blockHash != swap.BlockHash || swap.MissingChecks != 0 {
+		if uerr := storage.DB().UpdateRegisteredSwapBlock(swap.Key, blockHeight, blockHash, state); uerr != nil {
+			log.Warn("update swap block failed", "chain", scanner.chain, "txid", txid, "key", swap.Key, "blockHash", blockHash, "state", state, "err", uerr)
+			return uerr
+		}
+	}
+	return err
+}
+
+// markSwapTxMissing move swap back to Verifying and clear its block,
+// so it is posted after confirmations if the tx is packed in another block.
+// fail the swap if the tx is still not found after several checks and timeout.
+func (scanner *ethSwapScanner) markSwapTxMissing(swap *mongodb.MgoRegisteredSwap, reason error) error {
+	now := time.Now().Unix()
+	missingSince := swap.MissingSince
+	if missingSince == 0 {
+		missingSince = now
+	}
+	missingChecks := swap.MissingChecks + 1
+	if missingChecks >= maxSwapTxMissingChecks && now-missingSince >= swapTxMissingTimeout {
+		log.Warn("swap tx is reorged", "chain", scanner.chain, "txid", swap.TxID, "key", swap.Key, "checks", missingChecks, "since", missingSince, "err", reason)
+		_ = storage.DB().UpdateRegisteredSwapState(swap.Key, mongodb.StateFailed, errSwapReorged.Error())
+		return errSwapReorged
+	}
+	log.Warn("swap tx is not found", "chain", scanner.chain, "txid", swap.TxID, "key", swap.Key, "blockHash", swap.BlockHash, "checks", missingChecks, "err", reason)
+	_ = storage.DB().UpdateRegisteredSwapTxMissing(swap.Key, missingChecks, missingSince, reason.Error())
+	return errSwapTxMissing
+}
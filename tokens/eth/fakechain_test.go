@@ -0,0 +1,204 @@
+package eth
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/jowenshaw/gethclient/common"
+	"github.com/jowenshaw/gethclient/types"
+	"github.com/jowenshaw/gethclient/types/ethereum"
+
+	"github.com/weijun-sh/gethscan-server/params"
+)
+
+// fakeChain in memory chain implementing chainClient,
+// every tx added is packed in a new block.
+type fakeChain struct {
+	lock     sync.Mutex
+	chainID  *big.Int
+	blocks   []*types.Block
+	txs      map[common.Hash]*types.Transaction
+	receipts map[common.Hash]*types.Receipt
+	logs     []types.Log
+
+	receiptErr error // error returned by TransactionReceipt if set
+	hang       bool  // HeaderByNumber blocks until ctx is done if set
+}
+
+var _ chainClient = &fakeChain{}
+
+func newFakeChain() *fakeChain {
+	genesis := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(0)})
+	return &fakeChain{
+		chainID:  big.NewInt(1),
+		blocks:   []*types.Block{genesis},
+		txs:      make(map[common.Hash]*types.Transaction),
+		receipts: make(map[common.Hash]*types.Receipt),
+	}
+}
+
+// newFakeTx new tx sent to address with input data, nonce makes tx hash unique
+func newFakeTx(nonce uint64, to string, input []byte) *types.Transaction {
+	return types.NewTransaction(nonce, common.HexToAddress(to), big.NewInt(0), 100000, big.NewInt(1), input)
+}
+
+// newFakeLog new log of contract with topics, data is not empty
+func newFakeLog(contract string, topics ...common.Hash) *types.Log {
+	return &types.Log{
+		Address: common.HexToAddress(contract),
+		Topics:  topics,
+		Data:    []byte{},
+	}
+}
+
+// addTx pack tx in a new block, receipt status 1 is success and 0 is failed
+func (c *fakeChain) addTx(tx *types.Transaction, status uint64, logs ...*types.Log) *types.Receipt {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	height := uint64(len(c.blocks))
+	header := &types.Header{
+		ParentHash: c.blocks[height-1].Hash(),
+		Number:     new(big.Int).SetUint64(height),
+		Time:       uint64(time.Now().Unix()),
+	}
+	block := types.NewBlockWithHeader(header).WithBody([]*types.Transaction{tx}, nil)
+	receipt := &types.Receipt{
+		Status:      status,
+		Logs:        logs,
+		TxHash:      tx.Hash(),
+		BlockHash:   block.Hash(),
+		BlockNumber: header.Number,
+	}
+	for i, rlog := range logs {
+		rlog.BlockNumber = height
+		rlog.BlockHash = block.Hash()
+		rlog.TxHash = tx.Hash()
+		rlog.Index = uint(i)
+		c.logs = append(c.logs, *rlog)
+	}
+	c.blocks = append(c.blocks, block)
+	c.txs[tx.Hash()] = tx
+	c.receipts[tx.Hash()] = receipt
+	return receipt
+}
+
+// reorg remove tx and its receipt from chain
+func (c *fakeChain) reorg(txHash common.Hash) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.txs, txHash)
+	delete(c.receipts, txHash)
+}
+
+// setReceiptErr make TransactionReceipt fail with err, nil to recover
+func (c *fakeChain) setReceiptErr(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.receiptErr = err
+}
+
+func (c *fakeChain) getBlock(number *big.Int) (*types.Block, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if number == nil {
+		return c.blocks[len(c.blocks)-1], nil
+	}
+	if !number.IsUint64() || number.Uint64() >= uint64(len(c.blocks)) {
+		return nil, ethereum.NotFound
+	}
+	return c.blocks[number.Uint64()], nil
+}
+
+// ChainID impl
+func (c *fakeChain) ChainID(ctx context.Context) (*big.Int, error) {
+	return c.chainID, nil
+}
+
+// HeaderByNumber impl
+func (c *fakeChain) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	if c.hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	block, err := c.getBlock(number)
+	if err != nil {
+		return nil, err
+	}
+	return block.Header(), nil
+}
+
+// BlockByNumber impl
+func (c *fakeChain) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	return c.getBlock(number)
+}
+
+// TransactionByHash impl
+func (c *fakeChain) TransactionByHash(ctx context.Context, hash common.Hash) (tx *types.Transaction, isPending bool, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	tx, exist := c.txs[hash]
+	if !exist {
+		return nil, false, ethereum.NotFound
+	}
+	return tx, false, nil
+}
+
+// TransactionReceipt impl
+func (c *fakeChain) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.receiptErr != nil {
+		return nil, c.receiptErr
+	}
+	receipt, exist := c.receipts[txHash]
+	if !exist {
+		return nil, ethereum.NotFound
+	}
+	return receipt, nil
+}
+
+// FilterLogs impl, only block range and addresses are filtered
+func (c *fakeChain) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var result []types.Log
+	for _, rlog := range c.logs {
+		if q.FromBlock != nil && rlog.BlockNumber < q.FromBlock.Uint64() {
+			continue
+		}
+		if q.ToBlock != nil && rlog.BlockNumber > q.ToBlock.Uint64() {
+			continue
+		}
+		if len(q.Addresses) > 0 && !containsAddress(q.Addresses, rlog.Address) {
+			continue
+		}
+		result = append(result, rlog)
+	}
+	return result, nil
+}
+
+func containsAddress(addresses []common.Address, address common.Address) bool {
+	for _, addr := range addresses {
+		if addr == address {
+			return true
+		}
+	}
+	return false
+}
+
+// newFakeScanner new scanner of chain with the only gateway connected to fake chain
+func newFakeScanner(chain *fakeChain, tokens ...*params.TokenConfig) *ethSwapScanner {
+	scanner := &ethSwapScanner{
+		chain:         "fake",
+		chainID:       chain.chainID,
+		rpcInterval:   time.Millisecond,
+		rpcRetryCount: 3,
+		gateways:      []*ethGateway{{url: "http://127.0.0.1:8545", client: chain}},
+		cachedBlocks:  newCachedScannedBlocks(100),
+	}
+	scanner.ctx, scanner.cancel = context.WithCancel(context.Background())
+	scanner.setTokens(tokens)
+	return scanner
+}
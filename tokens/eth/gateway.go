@@ -0,0 +1,234 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"sort"
+	"time"
+
+	ethclient "github.com/jowenshaw/gethclient"
+	"github.com/jowenshaw/gethclient/common"
+	"github.com/jowenshaw/gethclient/types"
+	"github.com/jowenshaw/gethclient/types/ethereum"
+
+	"github.com/weijun-sh/gethscan-server/cmd/utils"
+	"github.com/weijun-sh/gethscan-server/log"
+	"github.com/weijun-sh/gethscan-server/metrics"
+	"github.com/weijun-sh/gethscan-server/tokens/tools"
+)
+
+var (
+	adjustGatewayOrderInterval = 60 * time.Second
+	gatewayHealthCheckTimeout  = 10 * time.Second
+	// every call to gateway is canceled after this time, then the next gateway is tried
+	gatewayCallTimeout = 30 * time.Second
+
+	// gateway lagging behind the highest one more than this is not preferred
+	maxGatewayBlockLag = uint64(5)
+	// latency (in milliseconds) beyond this is regarded as the same
+	maxGatewayLatencyWeight = uint64(60000)
+)
+
+// chainClient rpc apis of chain called by scanner,
+// implemented by *ethclient.Client, and by a fake chain in tests.
+type chainClient interface {
+	ChainID(ctx context.Context) (*big.Int, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+	TransactionByHash(ctx context.Context, hash common.Hash) (tx *types.Transaction, isPending bool, err error)
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+	FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error)
+}
+
+// dialChainClient dial rpc gateway of chain
+var dialChainClient = func(url string) (chainClient, error) {
+	return ethclient.Dial(url)
+}
+
+// ethGateway rpc gateway of a chain
+type ethGateway struct {
+	url    string
+	client chainClient
+
+	// health check result
+	height  uint64
+	latency time.Duration
+	err     error
+}
+
+// getGateways get gateways in order
+func (scanner *ethSwapScanner) getGateways() []*ethGateway {
+	scanner.gatewayLock.RLock()
+	defer scanner.gatewayLock.RUnlock()
+	gateways := make([]*ethGateway, len(scanner.gateways))
+	copy(gateways, scanner.gateways)
+	return gateways
+}
+
+// withClient call with gateways in order until success (failover).
+// every call has a timeout, so a hung gateway fails and the next one is tried.
+// not found error is returned only if all gateways say so.
+func (scanner *ethSwapScanner) withClient(call func(ctx context.Context, client chainClient) error) (err error) {
+	for _, gateway := range scanner.getGateways() {
+		if scanner.isStopped() {
+			return scanner.ctx.Err()
+		}
+		callErr := scanner.callGateway(gateway, call)
+		metrics.AddGatewayCall(scanner.chain, gateway.url, callErr)
+		if callErr == nil {
+			return nil
+		}
+		if err == nil || !errors.Is(callErr, ethereum.NotFound) {
+			err = callErr
+		}
+		log.Debug("call gateway failed", "chain", scanner.chain, "gateway", metrics.GetHost(gateway.url), "err", callErr)
+	}
+	if err == nil {
+		err = errors.New("no available gateway")
+	}
+	return err
+}
+
+func (scanner *ethSwapScanner) callGateway(gateway *ethGateway, call func(ctx context.Context, client chainClient) error) error {
+	ctx, cancel := context.WithTimeout(scanner.ctx, gatewayCallTimeout)
+	defer cancel()
+	err := call(ctx, gateway.client)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		log.Warn("call gateway timeout", "chain", scanner.chain, "gateway", metrics.GetHost(gateway.url), "timeout", gatewayCallTimeout)
+		return fmt.Errorf("call gateway timeout: %w", context.DeadlineExceeded)
+	}
+	return err
+}
+
+func (scanner *ethSwapScanner) loopAdjustGatewayOrder() {
+	for {
+		time.Sleep(adjustGatewayOrderInterval)
+		if utils.IsCleanuping() || scanner.isStopped() {
+			return
+		}
+		scanner.adjustGatewayOrder()
+	}
+}
+
+func (gateway *ethGateway) healthCheck(ctx context.Context, chain string) {
+	ctx, cancel := context.WithTimeout(ctx, gatewayHealthCheckTimeout)
+	defer cancel()
+	start := time.Now()
+	header, err := gateway.client.HeaderByNumber(ctx, nil)
+	gateway.latency = time.Since(start)
+	gateway.err = err
+	metrics.AddGatewayCall(chain, gateway.url, err)
+	if err == nil {
+		gateway.height = header.Number.Uint64()
+	}
+}
+
+// adjustGatewayOrder adjust gateway order by block height and latency
+func (scanner *ethSwapScanner) adjustGatewayOrder() {
+	gateways := scanner.getGateways()
+	checked := make(map[string]*ethGateway, len(gateways))
+	maxHeight := uint64(0)
+	for _, gateway := range gateways {
+		result := &ethGateway{url: gateway.url, client: gateway.client}
+		result.healthCheck(scanner.ctx, scanner.chain)
+		if result.err == nil && result.height > maxHeight {
+			maxHeight = result.height
+		}
+		checked[result.url] = result
+	}
+
+	var weightedAPIs tools.WeightedStringSlice
+	for _, gateway := range gateways {
+		result := checked[gateway.url]
+		var weight uint64
+		switch {
+		case result.err != nil:
+			weight = 0
+		case result.height+maxGatewayBlockLag < maxHeight:
+			weight = 1
+		default:
+			latency := uint64(result.latency.Milliseconds())
+			if latency > maxGatewayLatencyWeight {
+				latency = maxGatewayLatencyWeight
+			}
+			weight = 2 + maxGatewayLatencyWeight - latency
+		}
+		weightedAPIs = weightedAPIs.Add(result.url, weight)
+	}
+	weightedAPIs = weightedAPIs.Sort()
+
+	ordered := make([]*ethGateway, 0, len(gateways))
+	weights := make([]string, 0, len(gateways)) // url may contain api key, only log host
+	for _, api := range weightedAPIs {
+		ordered = append(ordered, checked[api.Content])
+		weights = append(weights, fmt.Sprintf("%v=%v", metrics.GetHost(api.Content), api.Weight))
+	}
+
+	scanner.gatewayLock.Lock()
+	scanner.gateways = ordered
+	if maxHeight > scanner.latestHeight {
+		scanner.latestHeight = maxHeight
+	}
+	scanner.gatewayLock.Unlock()
+
+	log.Info("adjust gateways", "chain", scanner.chain, "maxHeight", maxHeight, "result", weights)
+}
+
+// GatewayHealth result of the latest health check of gateway
+type GatewayHealth struct {
+	Host    string // url may contain api key, only host is shown
+	Height  uint64
+	Latency int64  // milliseconds
+	Error   string `json:",omitempty"`
+}
+
+// ChainHealth rpc reachability and latest block of chain scanner
+type ChainHealth struct {
+	Chain        string
+	Reachable    bool // any gateway is reachable in the latest health check
+	LatestHeight uint64
+	Gateways     []*GatewayHealth
+}
+
+// GetChainsHealth get health of all chain scanners (sorted by chain)
+func GetChainsHealth() []*ChainHealth {
+	scanners := getChainScanners()
+	result := make([]*ChainHealth, 0, len(scanners))
+	for _, scanner := range scanners {
+		result = append(result, scanner.getHealth())
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Chain < result[j].Chain })
+	return result
+}
+
+func (scanner *ethSwapScanner) getHealth() *ChainHealth {
+	scanner.gatewayLock.RLock()
+	defer scanner.gatewayLock.RUnlock()
+	health := &ChainHealth{
+		Chain:        scanner.chain,
+		LatestHeight: scanner.latestHeight,
+		Gateways:     make([]*GatewayHealth, 0, len(scanner.gateways)),
+	}
+	for _, gateway := range scanner.gateways {
+		gatewayHealth := &GatewayHealth{
+			Host:    metrics.GetHost(gateway.url),
+			Height:  gateway.height,
+			Latency: gateway.latency.Milliseconds(),
+		}
+		if gateway.err != nil {
+			err := gateway.err
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				err = urlErr.Err // do not show url
+			}
+			gatewayHealth.Error = err.Error()
+		} else {
+			health.Reachable = true
+		}
+		health.Gateways = append(health.Gateways, gatewayHealth)
+	}
+	return health
+}
@@ -0,0 +1,42 @@
+package eth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithClientFailoverOnTimeout(t *testing.T) {
+	oldTimeout := gatewayCallTimeout
+	gatewayCallTimeout = 10 * time.Millisecond
+	defer func() { gatewayCallTimeout = oldTimeout }()
+
+	hungChain := newFakeChain()
+	hungChain.hang = true
+	chain := newFakeChain()
+	chain.addTx(newFakeTx(0, testTokenAddr, nil), 1)
+
+	scanner := newFakeScanner(chain)
+	scanner.gateways = []*ethGateway{
+		{url: "http://127.0.0.1:8545", client: hungChain},
+		{url: "http://127.0.0.1:8546", client: chain},
+	}
+	latest, err := scanner.getLatestBlockNumber()
+	if err != nil || latest != 1 {
+		t.Errorf("get latest block number with hung gateway, have %v, err %v, want 1", latest, err)
+	}
+
+	scanner.gateways = scanner.gateways[:1]
+	start := time.Now()
+	if _, err = scanner.getLatestBlockNumber(); err == nil {
+		t.Errorf("get latest block number with only hung gateway, have no error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("call hung gateway is not canceled in time, elapsed %v", elapsed)
+	}
+
+	scanner.stop()
+	if _, err = scanner.getLatestBlockNumber(); !errors.Is(err, scanner.ctx.Err()) {
+		t.Errorf("call gateway after scanner stopped, have error %v, want %v", err, scanner.ctx.Err())
+	}
+}
@@ -0,0 +1,81 @@
+package eth
+
+import (
+	"github.com/weijun-sh/gethscan-server/log"
+	"github.com/weijun-sh/gethscan-server/params"
+)
+
+// ReloadCrossChain reload tokens config in token pairs directory,
+// replace tokens of existing chain scanners, and add or remove chain scanners.
+// if any error occurs, the previous config and scanners are kept.
+func ReloadCrossChain() error {
+	config, err := params.ReloadConfig()
+	if err != nil {
+		return err
+	}
+
+	// build scanners of new chains (or chains with changed blockchain config) first,
+	// so that any failure leaves the running scanners untouched.
+	current := make(map[string]*ethSwapScanner)
+	for _, scanner := range getChainScanners() {
+		current[scanner.chain] = scanner
+	}
+	added := make(map[string]*ethSwapScanner)
+	for chain, scantoken := range config {
+		if old, exist := current[chain]; exist && !old.isBlockChainChanged(scantoken) {
+			continue
+		}
+		scanner, errf := buildChain(chain, scantoken)
+		if errf != nil {
+			for _, s := range added {
+				s.stop()
+			}
+			log.Warn("reload tokens config failed, keep the previous config", "chain", chain, "err", errf)
+			return errf
+		}
+		added[chain] = scanner
+	}
+
+	params.SetScanTokensConfig(config)
+
+	chainScannerLock.Lock()
+	for chain, scanner := range current {
+		scantoken, exist := config[chain]
+		if !exist {
+			scanner.stop()
+			delete(chainScanner, chain)
+			log.Info("reload tokens config: remove chain scanner", "chain", chain)
+			continue
+		}
+		if _, replaced := added[chain]; replaced {
+			scanner.stop()
+			log.Info("reload tokens config: replace chain scanner", "chain", chain)
+			continue
+		}
+		scanner.setTokens(scantoken.Tokens)
+		log.Info("reload tokens config: update chain tokens", "chain", chain, "tokens", len(scantoken.Tokens))
+	}
+	for chain, scanner := range added {
+		chainScanner[chain] = scanner
+		if _, exist := current[chain]; !exist {
+			log.Info("reload tokens config: add chain scanner", "chain", chain)
+		}
+	}
+	chainScannerLock.Unlock()
+
+	for _, scanner := range added {
+		go scanner.loopAdjustGatewayOrder()
+		scanner.startScanJob()
+	}
+	log.Info("reload tokens config success", "chains", len(config))
+	return nil
+}
+
+// isBlockChainChanged blockchain config can not be changed in place
+func (scanner *ethSwapScanner) isBlockChainChanged(scantoken *params.ScanTokensConfig) bool {
+	var blockChain params.BlockChainConfig
+	if scantoken.BlockChain != nil {
+		blockChain = *scantoken.BlockChain
+	}
+	return blockChain != scanner.blockChain
+}
@@ -0,0 +1,211 @@
+package eth
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+
+	"github.com/jowenshaw/gethclient/types"
+
+	"github.com/weijun-sh/gethscan-server/common"
+	"github.com/weijun-sh/gethscan-server/mongodb"
+	"github.com/weijun-sh/gethscan-server/tokens"
+)
+
+// router swap event names
+const (
+	eventAnySwapOut                  = "LogAnySwapOut"
+	eventAnySwapTradeTokensForTokens = "LogAnySwapTradeTokensForTokens"
+	eventAnySwapTradeTokensForNative = "LogAnySwapTradeTokensForNative"
+	eventNFT721SwapOut               = "LogNFT721SwapOut"
+	eventNFT1155SwapOut              = "LogNFT1155SwapOut"
+	eventNFT1155SwapOutBatch         = "LogNFT1155SwapOutBatch"
+	eventAnyCall                     = "LogAnyCall"
+	eventAnyCallTransferSwapOut      = "LogAnyCallTransferSwapOut"
+)
+
+// getRouterEventName get event name of router swap log topic
+func getRouterEventName(logTopic []byte) string {
+	switch {
+	case bytes.Equal(logTopic, routerAnySwapOutTopic):
+		return eventAnySwapOut
+	case bytes.Equal(logTopic, routerAnySwapTradeTokensForTokensTopic):
+		return eventAnySwapTradeTokensForTokens
+	case bytes.Equal(logTopic, routerAnySwapTradeTokensForNativeTopic):
+		return eventAnySwapTradeTokensForNative
+	case bytes.Equal(logTopic, logNFT721SwapOutTopic):
+		return eventNFT721SwapOut
+	case bytes.Equal(logTopic, logNFT1155SwapOutTopic):
+		return eventNFT1155SwapOut
+	case bytes.Equal(logTopic, logNFT1155SwapOutBatchTopic):
+		return eventNFT1155SwapOutBatch
+	case bytes.Equal(logTopic, logAnycallSwapOutTopic):
+		return eventAnyCall
+	case bytes.Equal(logTopic, logAnycallTransferSwapOutTopic):
+		return eventAnyCallTransferSwapOut
+	default:
+		return ""
+	}
+}
+
+// decodeRouterSwapEvent ABI-decode router swap log
+func decodeRouterSwapEvent(rlog *types.Log) (*mongodb.MgoRouterSwapEvent, error) {
+	if len(rlog.Topics) == 0 {
+		return nil, tokens.ErrTxWithWrongTopics
+	}
+	event := &mongodb.MgoRouterSwapEvent{Name: getRouterEventName(rlog.Topics[0].Bytes())}
+	var err error
+	switch event.Name {
+	case eventAnySwapOut:
+		// LogAnySwapOut(address indexed token, address indexed from, address indexed to, uint amount, uint fromChainID, uint toChainID)
+		err = decodeSwapOutEvent(event, rlog, 3)
+		if err == nil {
+			event.Amount = getDecimalInData(rlog.Data, 0)
+		}
+	case eventAnySwapTradeTokensForTokens, eventAnySwapTradeTokensForNative:
+		// LogAnySwapTradeTokensForTokens(address[] path, address indexed from, address indexed to, uint amountIn, uint amountOutMin, uint fromChainID, uint toChainID)
+		err = decodeTradeEvent(event, rlog)
+	case eventNFT721SwapOut:
+		// LogNFT721SwapOut(address indexed token, address indexed from, address indexed to, uint tokenId, uint fromChainID, uint toChainID)
+		err = decodeSwapOutEvent(event, rlog, 3)
+		if err == nil {
+			event.TokenID = getDecimalInData(rlog.Data, 0)
+		}
+	case eventNFT1155SwapOut:
+		// LogNFT1155SwapOut(address indexed token, address indexed from, address indexed to, uint tokenId, uint amount, uint fromChainID, uint toChainID)
+		err = decodeSwapOutEvent(event, rlog, 4)
+		if err == nil {
+			event.TokenID = getDecimalInData(rlog.Data, 0)
+			event.Amount = getDecimalInData(rlog.Data, 32)
+		}
+	case eventNFT1155SwapOutBatch:
+		// LogNFT1155SwapOutBatch(address indexed token, address indexed from, address indexed to, uint[] tokenIds, uint[] amounts, uint fromChainID, uint toChainID)
+		err = decodeSwapOutEvent(event, rlog, 4)
+		if err == nil {
+			event.TokenIDs, err = getDecimalSliceInData(rlog.Data, 0)
+		}
+		if err == nil {
+			event.Amounts, err = getDecimalSliceInData(rlog.Data, 32)
+		}
+	case eventAnyCall:
+		// LogAnyCall(address indexed from, address[] to, bytes[] data, address[] callbacks, uint[] nonces, uint fromChainID, uint toChainID)
+		err = decodeAnyCallEvent(event, rlog)
+	case eventAnyCallTransferSwapOut:
+		// only the event is recorded, its arguments are not decoded
+	default:
+		return nil, tokens.ErrRouterLogNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// decodeSwapOutEvent decode events with indexed token, from and to,
+// and data of dataSlots words ending with fromChainID and toChainID.
+func decodeSwapOutEvent(event *mongodb.MgoRouterSwapEvent, rlog *types.Log, dataSlots uint64) error {
+	if len(rlog.Topics) != 4 {
+		return tokens.ErrTxWithWrongTopics
+	}
+	if uint64(len(rlog.Data)) < dataSlots*32 {
+		return tokens.ErrTxWithWrongLogData
+	}
+	event.Token = getAddressInTopic(rlog, 1)
+	event.From = getAddressInTopic(rlog, 2)
+	event.To = getAddressInTopic(rlog, 3)
+	event.FromChainID = getDecimalInData(rlog.Data, (dataSlots-2)*32)
+	event.ToChainID = getDecimalInData(rlog.Data, (dataSlots-1)*32)
+	return nil
+}
+
+// decodeTradeEvent decode trade events, token is the first of path
+func decodeTradeEvent(event *mongodb.MgoRouterSwapEvent, rlog *types.Log) error {
+	if len(rlog.Topics) != 3 {
+		return tokens.ErrTxWithWrongTopics
+	}
+	if len(rlog.Data) < 6*32 {
+		return tokens.ErrTxWithWrongLogData
+	}
+	path, err := getAddressSliceInData(rlog.Data, 0)
+	if err != nil {
+		return err
+	}
+	if len(path) == 0 {
+		return tokens.ErrTxWithWrongLogData
+	}
+	event.Token = path[0]
+	event.From = getAddressInTopic(rlog, 1)
+	event.To = getAddressInTopic(rlog, 2)
+	event.Amount = getDecimalInData(rlog.Data, 32)
+	event.FromChainID = getDecimalInData(rlog.Data, 96)
+	event.ToChainID = getDecimalInData(rlog.Data, 128)
+	return nil
+}
+
+// decodeAnyCallEvent decode anycall event, to is the first callee
+func decodeAnyCallEvent(event *mongodb.MgoRouterSwapEvent, rlog *types.Log) error {
+	if len(rlog.Topics) != 2 {
+		return tokens.ErrTxWithWrongTopics
+	}
+	if len(rlog.Data) < 6*32 {
+		return tokens.ErrTxWithWrongLogData
+	}
+	callees, err := getAddressSliceInData(rlog.Data, 0)
+	if err != nil {
+		return err
+	}
+	if len(callees) > 0 {
+		event.To = callees[0]
+	}
+	event.From = getAddressInTopic(rlog, 1)
+	event.FromChainID = getDecimalInData(rlog.Data, 128)
+	event.ToChainID = getDecimalInData(rlog.Data, 160)
+	return nil
+}
+
+func getAddressInTopic(rlog *types.Log, index int) string {
+	return strings.ToLower(common.BytesToAddress(rlog.Topics[index].Bytes()).Hex())
+}
+
+func getDecimalInData(data []byte, pos uint64) string {
+	return common.GetBigInt(data, pos, 32).String()
+}
+
+// getSliceInData get length and start of elements of dynamic array whose offset is at pos
+func getSliceInData(data []byte, pos uint64) (length, start uint64, err error) {
+	dataLength := uint64(len(data))
+	offset, overflow := common.GetUint64(data, pos, 32)
+	if overflow || dataLength < offset+32 {
+		return 0, 0, tokens.ErrTxWithWrongLogData
+	}
+	length, overflow = common.GetUint64(data, offset, 32)
+	if overflow || (dataLength-offset-32)/32 < length {
+		return 0, 0, tokens.ErrTxWithWrongLogData
+	}
+	return length, offset + 32, nil
+}
+
+func getAddressSliceInData(data []byte, pos uint64) ([]string, error) {
+	length, start, err := getSliceInData(data, pos)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, length)
+	for i := uint64(0); i < length; i++ {
+		address := common.BytesToAddress(common.GetData(data, start+i*32, 32))
+		result[i] = strings.ToLower(address.Hex())
+	}
+	return result, nil
+}
+
+func getDecimalSliceInData(data []byte, pos uint64) ([]string, error) {
+	length, start, err := getSliceInData(data, pos)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, length)
+	for i := uint64(0); i < length; i++ {
+		result[i] = new(big.Int).SetBytes(common.GetData(data, start+i*32, 32)).String()
+	}
+	return result, nil
+}
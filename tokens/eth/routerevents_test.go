@@ -0,0 +1,88 @@
+package eth
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/jowenshaw/gethclient/common"
+	"github.com/jowenshaw/gethclient/types"
+
+	"github.com/weijun-sh/gethscan-server/mongodb"
+	"github.com/weijun-sh/gethscan-server/tokens"
+)
+
+func word(v int64) []byte {
+	return common.BigToHash(big.NewInt(v)).Bytes()
+}
+
+func withData(rlog *types.Log, words ...[]byte) *types.Log {
+	rlog.Data = concatBytes(words...)
+	return rlog
+}
+
+func anySwapOutLog(router string, amount, fromChainID, toChainID int64) *types.Log {
+	rlog := newFakeLog(router, common.BytesToHash(routerAnySwapOutTopic), addressToHash(testTokenAddr), addressToHash(testSenderAddr), addressToHash(testOtherAddr))
+	return withData(rlog, word(amount), word(fromChainID), word(toChainID))
+}
+
+func tradeLog(topic []byte) *types.Log {
+	rlog := newFakeLog(testRouterAddr, common.BytesToHash(topic), addressToHash(testSenderAddr), addressToHash(testOtherAddr))
+	return withData(rlog, word(160), word(1000), word(900), word(56), word(1),
+		word(2), addressToHash(testTokenAddr).Bytes(), addressToHash(testOtherAddr).Bytes())
+}
+
+func nftLog(topic []byte, words ...[]byte) *types.Log {
+	rlog := newFakeLog(testRouterAddr, common.BytesToHash(topic), addressToHash(testTokenAddr), addressToHash(testSenderAddr), addressToHash(testOtherAddr))
+	return withData(rlog, words...)
+}
+
+func anyCallLog() *types.Log {
+	rlog := newFakeLog(testRouterAddr, common.BytesToHash(logAnycallSwapOutTopic), addressToHash(testSenderAddr))
+	return withData(rlog, word(192), word(256), word(288), word(320), word(56), word(1),
+		word(1), addressToHash(testOtherAddr).Bytes(), word(0), word(0), word(0))
+}
+
+var decodeRouterEventTestCases = []struct {
+	name    string
+	log     *types.Log
+	want    *mongodb.MgoRouterSwapEvent
+	wantErr error
+}{
+	{name: "any swap out", log: anySwapOutLog(testRouterAddr, 1000, 56, 1),
+		want: &mongodb.MgoRouterSwapEvent{Name: eventAnySwapOut, Token: testTokenAddr, From: testSenderAddr, To: testOtherAddr, Amount: "1000", FromChainID: "56", ToChainID: "1"}},
+	{name: "trade tokens for tokens", log: tradeLog(routerAnySwapTradeTokensForTokensTopic),
+		want: &mongodb.MgoRouterSwapEvent{Name: eventAnySwapTradeTokensForTokens, Token: testTokenAddr, From: testSenderAddr, To: testOtherAddr, Amount: "1000", FromChainID: "56", ToChainID: "1"}},
+	{name: "trade tokens for native", log: tradeLog(routerAnySwapTradeTokensForNativeTopic),
+		want: &mongodb.MgoRouterSwapEvent{Name: eventAnySwapTradeTokensForNative, Token: testTokenAddr, From: testSenderAddr, To: testOtherAddr, Amount: "1000", FromChainID: "56", ToChainID: "1"}},
+	{name: "nft 721 swap out", log: nftLog(logNFT721SwapOutTopic, word(7), word(56), word(1)),
+		want: &mongodb.MgoRouterSwapEvent{Name: eventNFT721SwapOut, Token: testTokenAddr, From: testSenderAddr, To: testOtherAddr, TokenID: "7", FromChainID: "56", ToChainID: "1"}},
+	{name: "nft 1155 swap out", log: nftLog(logNFT1155SwapOutTopic, word(7), word(3), word(56), word(1)),
+		want: &mongodb.MgoRouterSwapEvent{Name: eventNFT1155SwapOut, Token: testTokenAddr, From: testSenderAddr, To: testOtherAddr, TokenID: "7", Amount: "3", FromChainID: "56", ToChainID: "1"}},
+	{name: "nft 1155 swap out batch", log: nftLog(logNFT1155SwapOutBatchTopic, word(128), word(224), word(56), word(1), word(2), word(7), word(8), word(2), word(3), word(4)),
+		want: &mongodb.MgoRouterSwapEvent{Name: eventNFT1155SwapOutBatch, Token: testTokenAddr, From: testSenderAddr, To: testOtherAddr, TokenIDs: []string{"7", "8"}, Amounts: []string{"3", "4"}, FromChainID: "56", ToChainID: "1"}},
+	{name: "anycall", log: anyCallLog(),
+		want: &mongodb.MgoRouterSwapEvent{Name: eventAnyCall, From: testSenderAddr, To: testOtherAddr, FromChainID: "56", ToChainID: "1"}},
+	{name: "anycall transfer swap out", log: routerLog(testRouterAddr, logAnycallTransferSwapOutTopic),
+		want: &mongodb.MgoRouterSwapEvent{Name: eventAnyCallTransferSwapOut}},
+
+	{name: "any swap out with wrong topics", log: withData(routerLog(testRouterAddr, routerAnySwapOutTopic), word(1000), word(56), word(1)), wantErr: tokens.ErrTxWithWrongTopics},
+	{name: "any swap out with short data", log: nftLog(routerAnySwapOutTopic, word(1000), word(56)), wantErr: tokens.ErrTxWithWrongLogData},
+	{name: "nft 1155 swap out batch with wrong offset", log: nftLog(logNFT1155SwapOutBatchTopic, word(1024), word(224), word(56), word(1)), wantErr: tokens.ErrTxWithWrongLogData},
+	{name: "nft 1155 swap out batch with wrong length", log: nftLog(logNFT1155SwapOutBatchTopic, word(128), word(128), word(56), word(1), word(3), word(7)), wantErr: tokens.ErrTxWithWrongLogData},
+	{name: "not router log", log: transferLog(testTokenAddr, testOtherAddr), wantErr: tokens.ErrRouterLogNotFound},
+}
+
+func TestDecodeRouterSwapEvent(t *testing.T) {
+	for _, tc := range decodeRouterEventTestCases {
+		event, err := decodeRouterSwapEvent(tc.log)
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("%v: decode router swap event error mismatch, have %v, want %v", tc.name, err, tc.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(event, tc.want) {
+			t.Errorf("%v: decode router swap event mismatch, have %+v, want %+v", tc.name, event, tc.want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package eth
+
+import (
+	"context"
+	"math/big"
+	"strings"
+
+	"github.com/jowenshaw/gethclient/common"
+	"github.com/jowenshaw/gethclient/types"
+	"github.com/jowenshaw/gethclient/types/ethereum"
+
+	"github.com/weijun-sh/gethscan-server/log"
+)
+
+const (
+	defaultMaxLogsRange = uint64(1000)
+
+	// grow logs range after so many successful calls in a row
+	growLogsRangeAfter = 10
+)
+
+// keywords of node errors which mean the logs range should be reduced
+var tooManyLogsErrKeywords = []string{
+	"too many",
+	"query returned more than",
+	"limit exceeded",
+	"range too large",
+	"block range",
+	"response size",
+}
+
+func isTooManyLogsError(err error) bool {
+	errMsg := strings.ToLower(err.Error())
+	for _, keyword := range tooManyLogsErrKeywords {
+		if strings.Contains(errMsg, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// getLogsFilterQueries get queries of swap logs
+// transfer logs are restricted to deposit addresses, otherwise all transfers of tokens are returned
+func (scanner *ethSwapScanner) getLogsFilterQueries() (queries []*ethereum.FilterQuery) {
+	var swapAddresses, transferAddresses []common.Address
+	var depositAddresses []common.Hash
+	for _, tokenCfg := range scanner.getTokens() {
+		switch {
+		case tokenCfg.IsRouterSwap():
+			swapAddresses = append(swapAddresses, common.HexToAddress(tokenCfg.RouterContract))
+		case tokenCfg.IsNativeToken():
+			continue // native token has no log, rejected by config check
+		case tokenCfg.DepositAddress != "":
+			transferAddresses = append(transferAddresses, common.HexToAddress(tokenCfg.TokenAddress))
+			depositAddresses = append(depositAddresses, common.BytesToHash(common.HexToAddress(tokenCfg.DepositAddress).Bytes()))
+		default:
+			swapAddresses = append(swapAddresses, common.HexToAddress(tokenCfg.TokenAddress))
+		}
+	}
+	if len(swapAddresses) > 0 {
+		queries = append(queries, &ethereum.FilterQuery{
+			Addresses: swapAddresses,
+			Topics: [][]common.Hash{{
+				addressSwapoutLogTopic,
+				stringSwapoutLogTopic,
+				common.BytesToHash(routerAnySwapOutTopic),
+				common.BytesToHash(routerAnySwapTradeTokensForTokensTopic),
+				common.BytesToHash(routerAnySwapTradeTokensForNativeTopic),
+				common.BytesToHash(logNFT721SwapOutTopic),
+				common.BytesToHash(logNFT1155SwapOutTopic),
+				common.BytesToHash(logNFT1155SwapOutBatchTopic),
+				common.BytesToHash(logAnycallSwapOutTopic),
+				common.BytesToHash(logAnycallTransferSwapOutTopic),
+			}},
+		})
+	}
+	if len(transferAddresses) > 0 {
+		queries = append(queries, &ethereum.FilterQuery{
+			Addresses: transferAddresses,
+			Topics:    [][]common.Hash{{transferLogTopic}, {}, depositAddresses},
+		})
+	}
+	return queries
+}
+
+func (scanner *ethSwapScanner) filterLogs(query *ethereum.FilterQuery, from, to uint64) (logs []types.Log, err error) {
+	query.FromBlock = new(big.Int).SetUint64(from)
+	query.ToBlock = new(big.Int).SetUint64(to)
+	err = scanner.withClient(func(ctx context.Context, client chainClient) (err error) {
+		logs, err = client.FilterLogs(ctx, *query)
+		return err
+	})
+	return logs, err
+}
+
+// scanRangeByLogs scan swap logs in range [start, end], return next height to scan
+func (scanner *ethSwapScanner) scanRangeByLogs(start, end uint64) (next uint64) {
+	chain := scanner.chain
+	queries := scanner.getLogsFilterQueries()
+	successCount := 0
+	next = start
+	for next <= end {
+		if !scanner.holdScanLease() {
+			break
+		}
+		to := next + scanner.logsRange - 1
+		if to > end {
+			to = end
+		}
+		txids, err := scanner.getSwapTxidsInRange(queries, next, to)
+		if err != nil {
+			if isTooManyLogsError(err) && scanner.logsRange > 1 {
+				scanner.logsRange /= 2
+				successCount = 0
+				log.Info("[scanlogs] reduce logs range", "chain", chain, "range", scanner.logsRange, "err", err)
+				continue
+			}
+			log.Warn("[scanlogs] get logs failed", "chain", chain, "from", next, "to", to, "err", err)
+			break // retry in next loop
+		}
+		if err = scanner.scanLogTransactions(txids); err != nil {
+			log.Warn("[scanlogs] scan txs failed", "chain", chain, "from", next, "to", to, "err", err)
+			break // retry in next loop, registered swaps in range are ignored as duplicate
+		}
+		log.Info("[scanlogs] scanned blocks", "chain", chain, "from", next, "to", to, "txs", len(txids))
+		if !scanner.updateScanInfo(to) {
+			break
+		}
+		next = to + 1
+
+		successCount++
+		if successCount >= growLogsRangeAfter && scanner.logsRange < scanner.maxLogsRange {
+			scanner.logsRange *= 2
+			if scanner.logsRange > scanner.maxLogsRange {
+				scanner.logsRange = scanner.maxLogsRange
+			}
+			successCount = 0
+			log.Info("[scanlogs] grow logs range", "chain", chain, "range", scanner.logsRange)
+		}
+	}
+	return next
+}
+
+// getSwapTxidsInRange get distinct txids of swap logs in range [from, to] in order
+func (scanner *ethSwapScanner) getSwapTxidsInRange(queries []*ethereum.FilterQuery, from, to uint64) (txids []common.Hash, err error) {
+	exist := make(map[common.Hash]struct{})
+	for _, query := range queries {
+		logs, err := scanner.filterLogs(query, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(logs); i++ {
+			if logs[i].Removed {
+				continue
+			}
+			txHash := logs[i].TxHash
+			if _, ok := exist[txHash]; ok {
+				continue
+			}
+			exist[txHash] = struct{}{}
+			txids = append(txids, txHash)
+		}
+	}
+	return txids, nil
+}
+
+// scanLogTransactions scan txs of swap logs, stop at the first failed tx
+func (scanner *ethSwapScanner) scanLogTransactions(txids []common.Hash) error {
+	for _, txid := range txids {
+		if err := scanner.scanLogTransaction(txid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (scanner *ethSwapScanner) scanLogTransaction(txHash common.Hash) error {
+	tx, err := scanner.loopGetTx(txHash)
+	if err != nil {
+		log.Warn("[scanlogs] get tx failed", "chain", scanner.chain, "txid", txHash.Hex(), "err", err)
+		return err
+	}
+	return scanner.scanBlockTransaction(tx)
+}
@@ -10,12 +10,14 @@ import (
 	"sync"
 	"time"
 
-	ethclient "github.com/jowenshaw/gethclient"
 	"github.com/jowenshaw/gethclient/common"
 	"github.com/jowenshaw/gethclient/types"
 
+	"github.com/weijun-sh/gethscan-server/cmd/utils"
 	"github.com/weijun-sh/gethscan-server/log"
+	"github.com/weijun-sh/gethscan-server/metrics"
 	"github.com/weijun-sh/gethscan-server/params"
+	"github.com/weijun-sh/gethscan-server/storage"
 	"github.com/weijun-sh/gethscan-server/tools"
 	"github.com/weijun-sh/gethscan-server/tokens"
 	"github.com/weijun-sh/gethscan-server/mongodb"
@@ -58,71 +60,195 @@ const (
 
 var (
 	chainScanner map[string]*ethSwapScanner = make(map[string]*ethSwapScanner)
+	chainScannerLock sync.RWMutex
+
+	restIntervalInScanJob = 3 * time.Second
+
+	maxPendingVerifyWorkers = 4 // concurrent verifications of pending swaps
+	maxPendingVerifyLimit   = 10
+	pendingLeaseDuration    = 5 * time.Minute
+
+	// several instances may share the database, only the lease holder scans a chain
+	chainScanLeaseDuration = 2 * time.Minute
+
+	// position of verifying swap pending, only used in the parse chain tx loop
+	pendingCursor *mongodb.SwapCursor
 )
 
 type ethSwapScanner struct {
-        gateway     string
 	scanReceipt bool
 
         chainID *big.Int
 	chain string
 
-        client *ethclient.Client
+	// gateways ordered by health, call with failover
+	gatewayURLs  []string
+	gatewayLock  sync.RWMutex
+	gateways     []*ethGateway
+	latestHeight uint64
         ctx    context.Context
+        cancel context.CancelFunc // stop scanner when chain is removed
 
         rpcInterval   time.Duration
         rpcRetryCount int
 
         cachedSwapPosts *tools.Ring
         tokens []*params.TokenConfig
+        tokensLock sync.RWMutex
+        blockChain params.BlockChainConfig
+
+	// post swap after tx block has so many confirmations
+	confirmations uint64
+	// position of checking swaps waiting confirmations, only used in the confirmations loop
+	confirmationsCursor *mongodb.SwapCursor
+
+	// scan chain
+	enableScan   bool
+	syncNumber   uint64
+	cachedBlocks *cachedSacnnedBlocks
+	// time of leasing scanning of chain, zero if not leased
+	scanLeaseTime time.Time
+
+	// scan chain by eth_getLogs
+	scanLogs     bool
+	maxLogsRange uint64
+	logsRange    uint64
 }
 
 func InitCrossChain() {
 	params.InitCrossChain()
 	config := params.GetScanTokensConfig()
+	chainScannerLock.Lock()
+	defer chainScannerLock.Unlock()
 	for chain, scantoken := range config {
-		scanner := buildChain(chain, scantoken)
+		scanner, err := buildChain(chain, scantoken)
+		if err != nil {
+			log.Fatal("init chain scanner failed", "chain", chain, "err", err)
+		}
 		chainScanner[chain] = scanner
+		go scanner.loopAdjustGatewayOrder()
 	}
 }
 
-func buildChain(chain string, scantoken *params.ScanTokensConfig) *ethSwapScanner {
+func buildChain(chain string, scantoken *params.ScanTokensConfig) (*ethSwapScanner, error) {
         scanner := &ethSwapScanner{
-                ctx:           context.Background(),
                 rpcInterval:   1 * time.Second,
                 rpcRetryCount: 3,
         }
-        scanner.gateway = params.GetChainRPC(chain)
+	scanner.ctx, scanner.cancel = context.WithCancel(context.Background())
+	scanner.gatewayURLs = params.GetChainRPCs(chain)
 	scanner.chain = chain
-	scanner.tokens = scantoken.Tokens
+	scanner.setTokens(scantoken.Tokens)
+	if scantoken.BlockChain != nil {
+		scanner.blockChain = *scantoken.BlockChain
+		scanner.confirmations = scantoken.BlockChain.Confirmations
+		scanner.enableScan = scantoken.BlockChain.EnableScan
+		scanner.syncNumber = scantoken.BlockChain.SyncNumber
+		scanner.scanLogs = scantoken.BlockChain.ScanLogs
+		scanner.maxLogsRange = scantoken.BlockChain.MaxLogsRange
+	}
+	if scanner.maxLogsRange == 0 {
+		scanner.maxLogsRange = defaultMaxLogsRange
+	}
+	scanner.logsRange = scanner.maxLogsRange
+	scanner.cachedBlocks = newCachedScannedBlocks(100)
 
         log.Info("get argument success",
 		"chain", chain,
-                "gateway", scanner.gateway,
+                "gateways", scanner.gatewayURLs,
         )
 
-        scanner.initClient()
-	return scanner
+        if err := scanner.initClient(); err != nil {
+		scanner.stop()
+		return nil, err
+	}
+	return scanner, nil
 }
 
-func (scanner *ethSwapScanner) initClient() {
-        ethcli, err := ethclient.Dial(scanner.gateway)
-        if err != nil {
-                log.Fatal("ethclient.Dail failed", "gateway", scanner.gateway, "err", err)
-        }
-        log.Info("ethclient.Dail gateway success", "gateway", scanner.gateway)
-        scanner.client = ethcli
-        scanner.chainID, err = ethcli.ChainID(scanner.ctx)
-        if err != nil {
-                log.Fatal("get chainID failed", "err", err)
-        }
-        log.Info("get chainID success", "chainID", scanner.chainID)
+func (scanner *ethSwapScanner) initClient() error {
+	for _, url := range scanner.gatewayURLs {
+		client, err := dialChainClient(url)
+		if err != nil {
+			log.Error("ethclient.Dail failed", "chain", scanner.chain, "gateway", metrics.GetHost(url), "err", err)
+			continue
+		}
+		log.Info("ethclient.Dail gateway success", "chain", scanner.chain, "gateway", metrics.GetHost(url))
+		scanner.gateways = append(scanner.gateways, &ethGateway{url: url, client: client})
+	}
+	if len(scanner.gateways) == 0 {
+		log.Error("no available gateway", "chain", scanner.chain, "gateways", len(scanner.gatewayURLs))
+		return fmt.Errorf("no available gateway of chain '%v'", scanner.chain)
+	}
+	scanner.adjustGatewayOrder()
+	err := scanner.withClient(func(ctx context.Context, client chainClient) (err error) {
+		scanner.chainID, err = client.ChainID(ctx)
+		return err
+	})
+	if err != nil {
+		log.Warn("get chainID failed", "chain", scanner.chain, "err", err)
+		return nil
+	}
+	log.Info("get chainID success", "chainID", scanner.chainID)
+	return nil
 }
 
 func GetChainScanner(chain string) *ethSwapScanner {
+	chainScannerLock.RLock()
+	defer chainScannerLock.RUnlock()
 	return chainScanner[chain]
 }
 
+// getChainScanners get scanners of all chains
+func getChainScanners() []*ethSwapScanner {
+	chainScannerLock.RLock()
+	defer chainScannerLock.RUnlock()
+	scanners := make([]*ethSwapScanner, 0, len(chainScanner))
+	for _, scanner := range chainScanner {
+		scanners = append(scanners, scanner)
+	}
+	return scanners
+}
+
+// getTokens get tokens config, which is replaced as a whole when reloaded
+func (scanner *ethSwapScanner) getTokens() []*params.TokenConfig {
+	scanner.tokensLock.RLock()
+	defer scanner.tokensLock.RUnlock()
+	return scanner.tokens
+}
+
+// setTokens set tokens config, disabled tokens are not scanned
+func (scanner *ethSwapScanner) setTokens(tokens []*params.TokenConfig) {
+	enabled := make([]*params.TokenConfig, 0, len(tokens))
+	for _, tokenCfg := range tokens {
+		if !tokenCfg.Disabled {
+			enabled = append(enabled, tokenCfg)
+		}
+	}
+	scanner.tokensLock.Lock()
+	defer scanner.tokensLock.Unlock()
+	scanner.tokens = enabled
+}
+
+// SetChainTokens replace tokens config of chain scanner
+func SetChainTokens(chain string, tokens []*params.TokenConfig) error {
+	scanner := GetChainScanner(strings.ToLower(chain))
+	if scanner == nil {
+		return fmt.Errorf("chain '%v' not found", chain)
+	}
+	scanner.setTokens(tokens)
+	log.Info("set chain tokens config", "chain", chain, "tokens", len(tokens))
+	return nil
+}
+
+// stop cancel rpc calls and loops of scanner
+func (scanner *ethSwapScanner) stop() {
+	scanner.cancel()
+}
+
+func (scanner *ethSwapScanner) isStopped() bool {
+	return scanner.ctx.Err() != nil
+}
+
 type swapPost struct {
 	// common
 	txid       string
@@ -139,7 +265,14 @@ type swapPost struct {
 
 func (scanner *ethSwapScanner) loopGetLatestBlockNumber() uint64 {
 	for { // retry until success
-		header, err := scanner.client.HeaderByNumber(scanner.ctx, nil)
+		if scanner.isStopped() {
+			return 0
+		}
+		var header *types.Header
+		err := scanner.withClient(func(ctx context.Context, client chainClient) (err error) {
+			header, err = client.HeaderByNumber(ctx, nil)
+			return err
+		})
 		if err == nil {
 			log.Info("get latest block number success", "height", header.Number)
 			return header.Number.Uint64()
@@ -149,9 +282,25 @@ func (scanner *ethSwapScanner) loopGetLatestBlockNumber() uint64 {
 	}
 }
 
+// getLatestBlockNumber get latest block number without retry
+func (scanner *ethSwapScanner) getLatestBlockNumber() (uint64, error) {
+	var header *types.Header
+	err := scanner.withClient(func(ctx context.Context, client chainClient) (err error) {
+		header, err = client.HeaderByNumber(ctx, nil)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return header.Number.Uint64(), nil
+}
+
 func (scanner *ethSwapScanner) loopGetTx(txHash common.Hash) (tx *types.Transaction, err error) {
 	for i := 0; i < 5; i++ { // with retry
-		tx, _, err = scanner.client.TransactionByHash(scanner.ctx, txHash)
+		err = scanner.withClient(func(ctx context.Context, client chainClient) (err error) {
+			tx, _, err = client.TransactionByHash(ctx, txHash)
+			return err
+		})
 		if err == nil {
 			log.Debug("loopGetTx found", "tx", tx)
 			return tx, nil
@@ -163,11 +312,14 @@ func (scanner *ethSwapScanner) loopGetTx(txHash common.Hash) (tx *types.Transact
 
 func (scanner *ethSwapScanner) loopGetTxReceipt(txHash common.Hash) (receipt *types.Receipt, err error) {
 	for i := 0; i < 5; i++ { // with retry
-		receipt, err = scanner.client.TransactionReceipt(scanner.ctx, txHash)
+		err = scanner.withClient(func(ctx context.Context, client chainClient) (err error) {
+			receipt, err = client.TransactionReceipt(ctx, txHash)
+			return err
+		})
 		if err == nil {
 			if receipt.Status != 1 {
 				log.Debug("tx with wrong receipt status", "txHash", txHash.Hex())
-				return nil, errors.New("tx with wrong receipt status")
+				return nil, errTxWithWrongReceiptStatus
 			}
 			return receipt, nil
 		}
@@ -179,7 +331,10 @@ func (scanner *ethSwapScanner) loopGetTxReceipt(txHash common.Hash) (receipt *ty
 func (scanner *ethSwapScanner) loopGetBlock(height uint64) (block *types.Block, err error) {
 	blockNumber := new(big.Int).SetUint64(height)
 	for i := 0; i < 5; i++ { // with retry
-		block, err = scanner.client.BlockByNumber(scanner.ctx, blockNumber)
+		err = scanner.withClient(func(ctx context.Context, client chainClient) (err error) {
+			block, err = client.BlockByNumber(ctx, blockNumber)
+			return err
+		})
 		if err == nil {
 			return block, nil
 		}
@@ -189,37 +344,101 @@ func (scanner *ethSwapScanner) loopGetBlock(height uint64) (block *types.Block,
 	return nil, err
 }
 
-func (scanner *ethSwapScanner) scanTransaction(txid string) error {
+// swapMatch a verified (token config, log index) pair of a tx
+type swapMatch struct {
+	tokenCfg *params.TokenConfig
+	logIndex int
+	event    *mongodb.MgoRouterSwapEvent // decoded router swap log
+}
+
+func (scanner *ethSwapScanner) scanTransaction(txid string) (err error) {
+	defer func() {
+		metrics.AddVerifyResult(scanner.chain, getVerifyResultLabel(err))
+	}()
 	tx, err := scanner.loopGetTx(common.HexToHash(txid))
 	if err != nil {
 		log.Info("tx not found", "txid", txid)
-		mongodb.UpdateSwapPendingNotFound(txid)
-		return errors.New("verify swap failed! tx not found")
+		err = errors.New("verify swap failed! tx not found")
+		_ = storage.DB().UpdateSwapPendingState(txid, mongodb.StateFailed, err.Error())
+		return err
 	}
 	if tx.To() == nil {
 		log.Info("tx to is null", "txid", txid)
-		mongodb.UpdateSwapPendingNotFound(txid)
-		return errors.New("verify swap failed! tx to is null")
+		err = errors.New("verify swap failed! tx to is null")
+		_ = storage.DB().UpdateSwapPendingState(txid, mongodb.StateFailed, err.Error())
+		return err
 	}
 
-	for _, tokenCfg := range scanner.tokens {
-		err = scanner.verifyTransaction(txid, tx, tokenCfg)
-		if err == nil {
-			mongodb.UpdateSwapPendingSuccess(txid)
-			return nil
+	matches, err := scanner.findSwapMatches(tx)
+	if errors.Is(err, tokens.ErrRPCQueryError) {
+		log.Warn("verify swap failed", "txHash", txid, "err", err)
+		_ = storage.DB().UpdateSwapPendingState(txid, mongodb.StateVerifying, err.Error()) // retry later
+		return err
+	}
+	if len(matches) == 0 {
+		log.Debug("verify swap failed", "txHash", txid, "err", err)
+		err = fmt.Errorf("verify swap failed! %v", err)
+		_ = storage.DB().UpdateSwapPendingState(txid, mongodb.StateFailed, err.Error())
+		return err
+	}
+	err = scanner.registerSwapMatches(txid, matches)
+	if err != nil {
+		_ = storage.DB().UpdateSwapPendingState(txid, mongodb.StateVerifying, err.Error()) // retry later
+		return err
+	}
+	_ = storage.DB().UpdateSwapPendingState(txid, mongodb.StateVerified, "")
+	return nil
+}
+
+// findSwapMatches verify tx with all token configs, return every match.
+// rpc query error is returned in preference to others, as the tx may be a swap.
+func (scanner *ethSwapScanner) findSwapMatches(tx *types.Transaction) (matches []*swapMatch, err error) {
+	for _, tokenCfg := range scanner.getTokens() {
+		tokenMatches, verifyErr := scanner.verifyTransaction(tx, tokenCfg)
+		if verifyErr != nil {
+			if !errors.Is(err, tokens.ErrRPCQueryError) {
+				err = verifyErr
+			}
+			continue
 		}
+		matches = append(matches, tokenMatches...)
 	}
-	mongodb.UpdateSwapPendingFailed(txid)
-	log.Debug("verify swap failed", "txHash", txid, "err", err)
-	ret := fmt.Sprintf("verify swap failed! %v", err)
-	return errors.New(ret)
+	return matches, err
 }
 
-func (scanner *ethSwapScanner) checkTxToAddress(tx *types.Transaction, tokenCfg *params.TokenConfig) (receipt *types.Receipt, isAcceptToAddr bool) {
+func (scanner *ethSwapScanner) registerSwapMatches(txid string, matches []*swapMatch) error {
+	// record tx block to wait confirmations and check reorg before posting
+	var receipt *types.Receipt
+	if scanner.confirmations > 0 {
+		var err error
+		receipt, err = scanner.loopGetTxReceipt(common.HexToHash(txid))
+		if err != nil {
+			log.Warn("get tx receipt failed", "txid", txid, "err", err)
+			return fmt.Errorf("verify swap failed! %w", tokens.ErrTxReceiptNotFound)
+		}
+	}
+	for _, match := range matches {
+		var err error
+		if match.tokenCfg.IsRouterSwap() {
+			err = scanner.addRegisgerRouter(txid, match, receipt)
+		} else {
+			err = scanner.addRegisterSwap(txid, match.logIndex, match.tokenCfg, receipt)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkTxToAddress check tx to address is accepted by tokenCfg,
+// and get tx receipt if logs are needed to verify the tx.
+func (scanner *ethSwapScanner) checkTxToAddress(tx *types.Transaction, tokenCfg *params.TokenConfig) (receipt *types.Receipt, err error) {
 	needReceipt := scanner.scanReceipt
 	txtoAddress := tx.To().String()
 
 	var cmpTxTo string
+	isAcceptToAddr := false
 	if tokenCfg.IsRouterSwap() {
 		cmpTxTo = tokenCfg.RouterContract
 		needReceipt = true
@@ -246,36 +465,35 @@ func (scanner *ethSwapScanner) checkTxToAddress(tx *types.Transaction, tokenCfg
 	}
 
 	if !isAcceptToAddr {
-		return nil, false
+		return nil, tokens.ErrTxWithWrongReceiver
 	}
 
 	if needReceipt {
-		r, err := scanner.loopGetTxReceipt(tx.Hash())
+		receipt, err = scanner.loopGetTxReceipt(tx.Hash())
 		if err != nil {
 			log.Warn("get tx receipt error", "txHash", tx.Hash().Hex(), "err", err)
-			return nil, false
+			if !errors.Is(err, errTxWithWrongReceiptStatus) {
+				err = fmt.Errorf("%w: %v", tokens.ErrRPCQueryError, err)
+			}
+			return nil, err
 		}
-		receipt = r
 	}
 
-	return receipt, true
+	return receipt, nil
 }
 
-func (scanner *ethSwapScanner) verifyTransaction(txid string, tx *types.Transaction, tokenCfg *params.TokenConfig) (verifyErr error) {
-	receipt, isAcceptToAddr := scanner.checkTxToAddress(tx, tokenCfg)
-	if !isAcceptToAddr {
-		return tokens.ErrTxWithWrongReceiver
+// verifyTransaction return all matching logs of tx for tokenCfg
+func (scanner *ethSwapScanner) verifyTransaction(tx *types.Transaction, tokenCfg *params.TokenConfig) (matches []*swapMatch, verifyErr error) {
+	receipt, err := scanner.checkTxToAddress(tx, tokenCfg)
+	if err != nil {
+		return nil, err
 	}
 
+	logIndex := 0
 	switch {
 	// router swap
 	case tokenCfg.IsRouterSwap():
-		index := 0
-		index, verifyErr = scanner.verifyAndPostRouterSwapTx(tx, receipt, tokenCfg)
-		if verifyErr == nil {
-			scanner.addRegisgerRouter(txid, index, tokenCfg)
-		}
-		return verifyErr
+		return scanner.verifyAndPostRouterSwapTx(tx, receipt, tokenCfg)
 
 	// bridge swapin
 	case tokenCfg.DepositAddress != "":
@@ -284,45 +502,62 @@ func (scanner *ethSwapScanner) verifyTransaction(txid string, tx *types.Transact
 			break
 		}
 
-		verifyErr = scanner.verifyErc20SwapinTx(tx, receipt, tokenCfg)
+		logIndex, verifyErr = scanner.verifyErc20SwapinTx(tx, receipt, tokenCfg)
 
 	// bridge swapout
 	default:
 		if scanner.scanReceipt {
-			verifyErr = scanner.parseSwapoutTxLogs(receipt.Logs, tokenCfg)
+			logIndex, verifyErr = scanner.parseSwapoutTxLogs(receipt.Logs, tokenCfg)
 		} else {
-			verifyErr = scanner.verifySwapoutTx(tx, receipt, tokenCfg)
+			logIndex, verifyErr = scanner.verifySwapoutTx(tx, receipt, tokenCfg)
 		}
 	}
 
-	if verifyErr == nil {
-		scanner.addRegisterSwap(txid, tokenCfg) // TODO
+	if verifyErr != nil {
+		return nil, verifyErr
 	}
-	return verifyErr
+	return []*swapMatch{{tokenCfg: tokenCfg, logIndex: logIndex}}, nil
 }
 
-func (scanner *ethSwapScanner) addRegisterSwap(txid string, tokenCfg *params.TokenConfig) {
-        pairID := tokenCfg.PairID
-        var subject, rpcMethod string
-        if tokenCfg.DepositAddress != "" {
-                subject = "add bridge swapin register"
-                rpcMethod = "swap.Swapin"
-        } else {
-                subject = "add bridge swapout register"
-                rpcMethod = "swap.Swapout"
-        }
-        log.Info(subject, "txid", txid, "pairID", pairID)
-	mongodb.AddRegisteredSwap(scanner.chain, rpcMethod, pairID, txid, "0", "0", tokenCfg.SwapServer)
-	mongodb.UpdateSwapPendingSuccess(txid)
+func (scanner *ethSwapScanner) addRegisterSwap(txid string, logIndex int, tokenCfg *params.TokenConfig, receipt *types.Receipt) error {
+	pairID := tokenCfg.PairID
+	var subject, rpcMethod string
+	if tokenCfg.DepositAddress != "" {
+		subject = "add bridge swapin register"
+		rpcMethod = "swap.Swapin"
+	} else {
+		subject = "add bridge swapout register"
+		rpcMethod = "swap.Swapout"
+	}
+	log.Info(subject, "txid", txid, "pairID", pairID, "logindex", logIndex, "swapServer", tokenCfg.SwapServer)
+	swap := mongodb.NewRegisteredSwap(scanner.chain, rpcMethod, pairID, txid, "0", fmt.Sprintf("%v", logIndex), tokenCfg.SwapServer)
+	return scanner.addRegisteredSwapItem(swap, receipt)
 }
 
-func (scanner *ethSwapScanner) addRegisgerRouter(txid string, logIndex int, tokenCfg *params.TokenConfig) {
-        chainID := tokenCfg.ChainID
+func (scanner *ethSwapScanner) addRegisgerRouter(txid string, match *swapMatch, receipt *types.Receipt) error {
+	tokenCfg := match.tokenCfg
+	chainID := tokenCfg.ChainID
 
-        subject := "add swap router register"
-        rpcMethod := "swap.RegisterRouterSwap"
-        log.Info(subject, "chainid", chainID, "txid", txid, "logindex", logIndex)
-	mongodb.AddRegisteredSwap(scanner.chain, rpcMethod, "", txid, chainID, fmt.Sprintf("%v", logIndex), tokenCfg.SwapServer)
+	subject := "add swap router register"
+	rpcMethod := "swap.RegisterRouterSwap"
+	log.Info(subject, "chainid", chainID, "txid", txid, "logindex", match.logIndex, "swapServer", tokenCfg.SwapServer)
+	swap := mongodb.NewRegisteredSwap(scanner.chain, rpcMethod, "", txid, chainID, fmt.Sprintf("%v", match.logIndex), tokenCfg.SwapServer)
+	swap.Event = match.event
+	return scanner.addRegisteredSwapItem(swap, receipt)
+}
+
+// addRegisteredSwapItem add registered swap, already registered swap is not an error
+func (scanner *ethSwapScanner) addRegisteredSwapItem(swap *mongodb.MgoRegisteredSwap, receipt *types.Receipt) error {
+	if receipt != nil {
+		swap.BlockHeight = receipt.BlockNumber.Uint64()
+		swap.BlockHash = receipt.BlockHash.Hex()
+		swap.Status = mongodb.StateVerifying
+	}
+	err := storage.DB().AddRegisteredSwapItem(swap)
+	if errors.Is(err, mongodb.ErrItemIsDup) {
+		return nil
+	}
+	return err
 }
 
 func (scanner *ethSwapScanner) getSwapoutFuncHashByTxType(txType string) []byte {
@@ -351,27 +586,28 @@ func (scanner *ethSwapScanner) getLogTopicByTxType(txType string) (topTopic comm
 	}
 }
 
-func (scanner *ethSwapScanner) verifyErc20SwapinTx(tx *types.Transaction, receipt *types.Receipt, tokenCfg *params.TokenConfig) (err error) {
+func (scanner *ethSwapScanner) verifyErc20SwapinTx(tx *types.Transaction, receipt *types.Receipt, tokenCfg *params.TokenConfig) (logIndex int, err error) {
 	if receipt == nil {
 		err = scanner.parseErc20SwapinTxInput(tx.Data(), tokenCfg.DepositAddress)
 	} else {
-		err = scanner.parseErc20SwapinTxLogs(receipt.Logs, tokenCfg)
+		logIndex, err = scanner.parseErc20SwapinTxLogs(receipt.Logs, tokenCfg)
 	}
-	return err
+	return logIndex, err
 }
 
-func (scanner *ethSwapScanner) verifySwapoutTx(tx *types.Transaction, receipt *types.Receipt, tokenCfg *params.TokenConfig) (err error) {
+func (scanner *ethSwapScanner) verifySwapoutTx(tx *types.Transaction, receipt *types.Receipt, tokenCfg *params.TokenConfig) (logIndex int, err error) {
 	if receipt == nil {
 		err = scanner.parseSwapoutTxInput(tx.Data(), tokenCfg.TxType)
 	} else {
-		err = scanner.parseSwapoutTxLogs(receipt.Logs, tokenCfg)
+		logIndex, err = scanner.parseSwapoutTxLogs(receipt.Logs, tokenCfg)
 	}
-	return err
+	return logIndex, err
 }
 
-func (scanner *ethSwapScanner) verifyAndPostRouterSwapTx(tx *types.Transaction, receipt *types.Receipt, tokenCfg *params.TokenConfig) (int, error) {
+// verifyAndPostRouterSwapTx return all matching router logs with their decoded events
+func (scanner *ethSwapScanner) verifyAndPostRouterSwapTx(tx *types.Transaction, receipt *types.Receipt, tokenCfg *params.TokenConfig) (matches []*swapMatch, err error) {
 	if receipt == nil {
-		return 0, tokens.ErrTxReceiptNotFound
+		return nil, tokens.ErrTxReceiptNotFound
 	}
 	for i := 0; i < len(receipt.Logs); i++ {
 		rlog := receipt.Logs[i]
@@ -407,9 +643,17 @@ func (scanner *ethSwapScanner) verifyAndPostRouterSwapTx(tx *types.Transaction,
 				continue
 			}
 		}
-		return i, nil
+		// the swap server verifies the log again, so it is registered even if not decodable
+		event, errd := decodeRouterSwapEvent(rlog)
+		if errd != nil {
+			log.Warn("decode router swap event failed", "txHash", tx.Hash().Hex(), "logIndex", i, "err", errd)
+		}
+		matches = append(matches, &swapMatch{tokenCfg: tokenCfg, logIndex: i, event: event})
+	}
+	if len(matches) == 0 {
+		return nil, tokens.ErrRouterLogNotFound
 	}
-	return 0, tokens.ErrRouterLogNotFound
+	return matches, nil
 }
 
 func (scanner *ethSwapScanner) parseErc20SwapinTxInput(input []byte, depositAddress string) error {
@@ -432,13 +676,13 @@ func (scanner *ethSwapScanner) parseErc20SwapinTxInput(input []byte, depositAddr
 	return nil
 }
 
-func (scanner *ethSwapScanner) parseErc20SwapinTxLogs(logs []*types.Log, tokenCfg *params.TokenConfig) (err error) {
+func (scanner *ethSwapScanner) parseErc20SwapinTxLogs(logs []*types.Log, tokenCfg *params.TokenConfig) (logIndex int, err error) {
 	targetContract := tokenCfg.TokenAddress
 	depositAddress := tokenCfg.DepositAddress
 	cmpLogTopic, topicsLen := scanner.getLogTopicByTxType(tokenCfg.TxType)
 
 	transferLogExist := false
-	for _, rlog := range logs {
+	for i, rlog := range logs {
 		if rlog.Removed {
 			continue
 		}
@@ -454,15 +698,13 @@ func (scanner *ethSwapScanner) parseErc20SwapinTxLogs(logs []*types.Log, tokenCf
 		transferLogExist = true
 		receiver := common.BytesToAddress(rlog.Topics[2][:]).Hex()
 		if strings.EqualFold(receiver, depositAddress) {
-			return nil
+			return i, nil
 		}
 	}
 	if transferLogExist {
-		fmt.Printf("parseErc20SwapinTxLogs, transferLogExist: %v\n", transferLogExist)
-		return tokens.ErrTxWithWrongReceiver
+		return 0, tokens.ErrTxWithWrongReceiver
 	}
-	fmt.Printf("parseErc20SwapinTxLogs, tokens.ErrDepositLogNotFound\n")
-	return tokens.ErrDepositLogNotFound
+	return 0, tokens.ErrDepositLogNotFound
 }
 
 func (scanner *ethSwapScanner) parseSwapoutTxInput(input []byte, txType string) error {
@@ -476,11 +718,11 @@ func (scanner *ethSwapScanner) parseSwapoutTxInput(input []byte, txType string)
 	return tokens.ErrTxFuncHashMismatch
 }
 
-func (scanner *ethSwapScanner) parseSwapoutTxLogs(logs []*types.Log, tokenCfg *params.TokenConfig) (err error) {
+func (scanner *ethSwapScanner) parseSwapoutTxLogs(logs []*types.Log, tokenCfg *params.TokenConfig) (logIndex int, err error) {
 	targetContract := tokenCfg.TokenAddress
 	cmpLogTopic, topicsLen := scanner.getLogTopicByTxType(tokenCfg.TxType)
 
-	for _, rlog := range logs {
+	for i, rlog := range logs {
 		if rlog.Removed {
 			continue
 		}
@@ -491,10 +733,10 @@ func (scanner *ethSwapScanner) parseSwapoutTxLogs(logs []*types.Log, tokenCfg *p
 			continue
 		}
 		if rlog.Topics[0] == cmpLogTopic {
-			return nil
+			return i, nil
 		}
 	}
-	return tokens.ErrSwapoutLogNotFound
+	return 0, tokens.ErrSwapoutLogNotFound
 }
 
 type cachedSacnnedBlocks struct {
@@ -503,10 +745,12 @@ type cachedSacnnedBlocks struct {
 	hashes    []string
 }
 
-var cachedBlocks = &cachedSacnnedBlocks{
-	capacity:  100,
-	nextIndex: 0,
-	hashes:    make([]string, 100),
+func newCachedScannedBlocks(capacity int) *cachedSacnnedBlocks {
+	return &cachedSacnnedBlocks{
+		capacity:  capacity,
+		nextIndex: 0,
+		hashes:    make([]string, capacity),
+	}
 }
 
 func (cache *cachedSacnnedBlocks) addBlock(blockHash string) {
@@ -523,27 +767,190 @@ func (cache *cachedSacnnedBlocks) isScanned(blockHash string) bool {
 	return false
 }
 
+// StartScanChainJob start scan chain job of chains enabled scan
+func StartScanChainJob() {
+	for _, scanner := range getChainScanners() {
+		scanner.startScanJob()
+	}
+}
+
+func (scanner *ethSwapScanner) startScanJob() {
+	if !scanner.enableScan {
+		return
+	}
+	go scanner.loopScanChain()
+}
+
+func (scanner *ethSwapScanner) getStartHeight() uint64 {
+	scanInfo, err := storage.DB().FindChainScanInfo(scanner.chain)
+	if err == nil && scanInfo.BlockHeight != 0 {
+		return scanInfo.BlockHeight + 1
+	}
+	latest := scanner.loopGetLatestBlockNumber()
+	if latest > scanner.syncNumber {
+		return latest - scanner.syncNumber
+	}
+	return 0
+}
+
+// holdScanLease lease scanning of chain, renew the lease if a third of it passed,
+// return false if the lease is held by another instance
+func (scanner *ethSwapScanner) holdScanLease() bool {
+	if !scanner.scanLeaseTime.IsZero() && time.Since(scanner.scanLeaseTime) < chainScanLeaseDuration/3 {
+		return true
+	}
+	if err := storage.DB().LeaseChainScan(scanner.chain, chainScanLeaseDuration); err != nil {
+		if !scanner.scanLeaseTime.IsZero() {
+			log.Warn("[scanchain] lost lease of scanning chain", "chain", scanner.chain, "err", err)
+		}
+		scanner.scanLeaseTime = time.Time{}
+		return false
+	}
+	scanner.scanLeaseTime = time.Now()
+	return true
+}
+
+// updateScanInfo update scanned height of chain, return false if the lease is lost
+func (scanner *ethSwapScanner) updateScanInfo(height uint64) bool {
+	err := storage.DB().UpdateChainScanInfo(scanner.chain, height)
+	if errors.Is(err, mongodb.ErrItemIsLeased) {
+		log.Warn("[scanchain] lost lease of scanning chain", "chain", scanner.chain, "height", height)
+		scanner.scanLeaseTime = time.Time{}
+		return false
+	}
+	return true
+}
+
+func (scanner *ethSwapScanner) loopScanChain() {
+	chain := scanner.chain
+	log.Info("[scanchain] start scan chain loop", "chain", chain, "scanLogs", scanner.scanLogs)
+
+	var next uint64
+	for {
+		if utils.IsCleanuping() || scanner.isStopped() {
+			if !scanner.scanLeaseTime.IsZero() {
+				_ = storage.DB().ReleaseChainScan(chain)
+			}
+			log.Info("[scanchain] stop scan chain loop", "chain", chain)
+			return
+		}
+		isLeased := !scanner.scanLeaseTime.IsZero()
+		if !scanner.holdScanLease() {
+			time.Sleep(restIntervalInScanJob)
+			continue
+		}
+		if !isLeased {
+			// scan info may be updated by the previous lease holder
+			next = scanner.getStartHeight()
+			log.Info("[scanchain] leased scanning chain", "chain", chain, "start", next)
+		}
+		latest := scanner.loopGetLatestBlockNumber()
+		if scanner.scanLogs {
+			next = scanner.scanRangeByLogs(next, latest)
+		} else {
+			next = scanner.scanRangeByBlocks(next, latest)
+		}
+		time.Sleep(restIntervalInScanJob)
+	}
+}
+
+// scanRangeByBlocks scan blocks in range [start, end], return next height to scan
+func (scanner *ethSwapScanner) scanRangeByBlocks(start, end uint64) (next uint64) {
+	chain := scanner.chain
+	next = start
+	for h := start; h <= end; h++ {
+		if !scanner.holdScanLease() {
+			break
+		}
+		block, err := scanner.loopGetBlock(h)
+		if err != nil {
+			break // retry in next loop
+		}
+		blockHash := block.Hash().Hex()
+		if !scanner.cachedBlocks.isScanned(blockHash) {
+			if err = scanner.scanBlockTransactions(block); err != nil {
+				log.Warn("[scanchain] scan block failed", "chain", chain, "height", h, "blockHash", blockHash, "err", err)
+				break // retry in next loop, registered swaps of the block are ignored as duplicate
+			}
+			scanner.cachedBlocks.addBlock(blockHash)
+			log.Info("[scanchain] scanned block", "chain", chain, "height", h, "blockHash", blockHash, "txs", len(block.Transactions()))
+		}
+		if !scanner.updateScanInfo(h) {
+			break
+		}
+		next = h + 1
+	}
+	return next
+}
+
+// scanBlockTransactions scan all txs of block, stop at the first failed tx
+func (scanner *ethSwapScanner) scanBlockTransactions(block *types.Block) error {
+	for _, tx := range block.Transactions() {
+		if err := scanner.scanBlockTransaction(tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanBlockTransaction register swaps in tx,
+// return error if tx may be a swap but can not be verified or registered now.
+func (scanner *ethSwapScanner) scanBlockTransaction(tx *types.Transaction) error {
+	if tx.To() == nil {
+		return nil
+	}
+	txid := tx.Hash().Hex()
+	matches, err := scanner.findSwapMatches(tx)
+	if errors.Is(err, tokens.ErrRPCQueryError) {
+		log.Warn("[scanchain] verify tx failed", "chain", scanner.chain, "txid", txid, "err", err)
+		return err
+	}
+	if len(matches) == 0 {
+		return nil
+	}
+	log.Info("[scanchain] found swap tx", "chain", scanner.chain, "txid", txid, "matches", len(matches))
+	metrics.AddRegistrationReceived(scanner.chain, "scan")
+	return scanner.registerSwapMatches(strings.ToLower(txid), matches)
+}
+
+// FindSwapPendingAndRegister verify a batch of swap pending, and register swaps in them
 func FindSwapPendingAndRegister() {
-        pending, err := mongodb.FindSwapPending("", 0, 10)
-        if err != nil || len(pending) == 0 {
-               return
+	pending, err := storage.DB().FindSwapPending("", pendingCursor, maxPendingVerifyLimit)
+	if err != nil || len(pending) < maxPendingVerifyLimit {
+		pendingCursor = nil // start from the oldest next time
+	} else {
+		pendingCursor = pending[len(pending)-1].Cursor()
 	}
-        wg := new(sync.WaitGroup)
-        wg.Add(len(pending))
+	if err != nil || len(pending) == 0 {
+		return
+	}
+	wg := new(sync.WaitGroup)
+	wg.Add(len(pending))
+	workers := make(chan struct{}, maxPendingVerifyWorkers)
 
-	for i, _ := range pending {
+	for i := range pending {
+		workers <- struct{}{}
 		go func(p *mongodb.MgoRegisteredSwapPending) {
+			defer func() { <-workers }()
 			defer wg.Done()
 			chain := p.Chain
 			txid := p.Key
-			ParseTx(chain, txid)
 			scanner := GetChainScanner(chain)
 			if scanner == nil {
 				log.Info("FindSwapPendingAndRegister", "txid", txid, "(not set rpc)chain", chain)
 				return
 			}
+			// several instances may share the database, only the lease holder verifies the tx
+			if err := storage.DB().LeaseSwapPending(txid, pendingLeaseDuration); err != nil {
+				log.Info("FindSwapPendingAndRegister skipped", "txid", txid, "chain", chain, "err", err)
+				return
+			}
+			defer func() { _ = storage.DB().ReleaseSwapPending(txid) }()
+			if p.Status == mongodb.StateSubmitted {
+				_ = storage.DB().UpdateSwapPendingState(txid, mongodb.StateVerifying, "")
+			}
 			log.Info("FindSwapPendingAndRegister", "txid", txid, "chain", chain)
-			scanner.scanTransaction(txid)
+			_ = scanner.scanTransaction(txid)
 		}(pending[i])
 	}
 	wg.Wait()
@@ -558,3 +965,39 @@ func ParseTx(chain, txid string) error {
 	log.Info("ParseTx", "txid", txid, "chain", chain)
 	return scanner.scanTransaction(txid)
 }
+
+// verify errors counted in metrics, other errors are counted as 'other'
+var verifyResultErrors = []error{
+	errTxWithWrongReceiptStatus,
+	tokens.ErrTxNotFound,
+	tokens.ErrTxNotStable,
+	tokens.ErrTxWithWrongReceiver,
+	tokens.ErrTxWithWrongContract,
+	tokens.ErrTxWithWrongInput,
+	tokens.ErrTxWithWrongLogData,
+	tokens.ErrTxFuncHashMismatch,
+	tokens.ErrDepositLogNotFound,
+	tokens.ErrSwapoutLogNotFound,
+	tokens.ErrRouterLogNotFound,
+	tokens.ErrRPCQueryError,
+	tokens.ErrWrongSwapValue,
+	tokens.ErrTxWithWrongReceipt,
+	tokens.ErrTxReceiptNotFound,
+	tokens.ErrTxWithWrongValue,
+	tokens.ErrTxWithWrongSender,
+	tokens.ErrTxSenderNotRegistered,
+	tokens.ErrBindAddrIsContract,
+}
+
+func getVerifyResultLabel(err error) string {
+	if err == nil {
+		return "success"
+	}
+	for _, verifyErr := range verifyResultErrors {
+		// some errors are formatted into message without wrapping
+		if errors.Is(err, verifyErr) || strings.Contains(err.Error(), verifyErr.Error()) {
+			return verifyErr.Error()
+		}
+	}
+	return "other"
+}
@@ -0,0 +1,517 @@
+package eth
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jowenshaw/gethclient/common"
+	"github.com/jowenshaw/gethclient/types"
+
+	"github.com/weijun-sh/gethscan-server/mongodb"
+	"github.com/weijun-sh/gethscan-server/params"
+	"github.com/weijun-sh/gethscan-server/storage"
+	"github.com/weijun-sh/gethscan-server/tokens"
+)
+
+const (
+	testTokenAddr      = "0x1111111111111111111111111111111111111111"
+	testDepositAddr    = "0x2222222222222222222222222222222222222222"
+	testRouterAddr     = "0x3333333333333333333333333333333333333333"
+	testCallByContract = "0x4444444444444444444444444444444444444444"
+	testWhitelistAddr  = "0x5555555555555555555555555555555555555555"
+	testOtherAddr      = "0x6666666666666666666666666666666666666666"
+	testSenderAddr     = "0x7777777777777777777777777777777777777777"
+	testSwapServer     = "http://127.0.0.1:11556/rpc"
+)
+
+func addressToHash(address string) common.Hash {
+	return common.BytesToHash(common.HexToAddress(address).Bytes())
+}
+
+func concatBytes(items ...[]byte) (result []byte) {
+	for _, item := range items {
+		result = append(result, item...)
+	}
+	return result
+}
+
+func transferInput(to string) []byte {
+	return concatBytes(transferFuncHash, addressToHash(to).Bytes(), common.BigToHash(common.Big1).Bytes())
+}
+
+func transferFromInput(from, to string) []byte {
+	return concatBytes(transferFromFuncHash, addressToHash(from).Bytes(), addressToHash(to).Bytes(), common.BigToHash(common.Big1).Bytes())
+}
+
+func swapoutInput(funcHash []byte) []byte {
+	return concatBytes(funcHash, common.BigToHash(common.Big1).Bytes())
+}
+
+func transferLog(token, to string) *types.Log {
+	return newFakeLog(token, transferLogTopic, addressToHash(testSenderAddr), addressToHash(to))
+}
+
+func removedLog(rlog *types.Log) *types.Log {
+	rlog.Removed = true
+	return rlog
+}
+
+func routerLog(router string, topic []byte) *types.Log {
+	return newFakeLog(router, common.BytesToHash(topic), addressToHash(testSenderAddr))
+}
+
+func swapinToken() *params.TokenConfig {
+	return &params.TokenConfig{TxType: params.TxSwapin, PairID: "usdt", TokenAddress: testTokenAddr, DepositAddress: testDepositAddr, SwapServer: testSwapServer}
+}
+
+func swapoutToken(txType string) *params.TokenConfig {
+	return &params.TokenConfig{TxType: txType, PairID: "usdt", TokenAddress: testTokenAddr, SwapServer: testSwapServer}
+}
+
+func routerToken(txType string) *params.TokenConfig {
+	return &params.TokenConfig{TxType: txType, ChainID: "56", RouterContract: testRouterAddr, SwapServer: testSwapServer}
+}
+
+func withCallByContract(tokenCfg *params.TokenConfig) *params.TokenConfig {
+	tokenCfg.CallByContract = testCallByContract
+	return tokenCfg
+}
+
+func withWhitelist(tokenCfg *params.TokenConfig) *params.TokenConfig {
+	tokenCfg.Whitelist = []string{testWhitelistAddr}
+	return tokenCfg
+}
+
+func withNative(tokenCfg *params.TokenConfig) *params.TokenConfig {
+	tokenCfg.TokenAddress = "native"
+	return tokenCfg
+}
+
+type verifyTxTestCase struct {
+	name   string
+	token  *params.TokenConfig
+	to     string
+	input  []byte
+	status uint64 // receipt status, 1 is success and 0 is failed
+	logs   []*types.Log
+
+	wantLogIndexes []int
+	wantErr        error
+}
+
+var verifyTxTestCases = []*verifyTxTestCase{
+	// swapin
+	{name: "swapin transfer", token: swapinToken(), to: testTokenAddr, input: transferInput(testDepositAddr), status: 1, wantLogIndexes: []int{0}},
+	{name: "swapin transferFrom", token: swapinToken(), to: testTokenAddr, input: transferFromInput(testSenderAddr, testDepositAddr), status: 1, wantLogIndexes: []int{0}},
+	{name: "swapin transfer to other receiver", token: swapinToken(), to: testTokenAddr, input: transferInput(testOtherAddr), status: 1, wantErr: tokens.ErrTxWithWrongReceiver},
+	{name: "swapin with wrong func hash", token: swapinToken(), to: testTokenAddr, input: swapoutInput(addressSwapoutFuncHash), status: 1, wantErr: tokens.ErrTxFuncHashMismatch},
+	{name: "swapin with short input", token: swapinToken(), to: testTokenAddr, input: []byte{0xa9, 0x05}, status: 1, wantErr: tokens.ErrTxWithWrongInput},
+	{name: "swapin to other contract", token: swapinToken(), to: testOtherAddr, input: transferInput(testDepositAddr), status: 1, wantErr: tokens.ErrTxWithWrongReceiver},
+	{name: "swapin call by contract", token: withCallByContract(swapinToken()), to: testCallByContract, status: 1,
+		logs:           []*types.Log{transferLog(testOtherAddr, testDepositAddr), transferLog(testTokenAddr, testDepositAddr)},
+		wantLogIndexes: []int{1}},
+	{name: "swapin call by contract to other receiver", token: withCallByContract(swapinToken()), to: testCallByContract, status: 1,
+		logs: []*types.Log{transferLog(testTokenAddr, testOtherAddr)}, wantErr: tokens.ErrTxWithWrongReceiver},
+	{name: "swapin call by contract without transfer log", token: withCallByContract(swapinToken()), to: testCallByContract, status: 1,
+		logs: []*types.Log{routerLog(testTokenAddr, routerAnySwapOutTopic)}, wantErr: tokens.ErrDepositLogNotFound},
+	{name: "swapin call by contract with removed log", token: withCallByContract(swapinToken()), to: testCallByContract, status: 1,
+		logs: []*types.Log{removedLog(transferLog(testTokenAddr, testDepositAddr))}, wantErr: tokens.ErrDepositLogNotFound},
+	{name: "swapin call by contract with failed receipt", token: withCallByContract(swapinToken()), to: testCallByContract, status: 0,
+		logs: []*types.Log{transferLog(testTokenAddr, testDepositAddr)}, wantErr: errTxWithWrongReceiptStatus},
+	{name: "swapin call by whitelist", token: withWhitelist(swapinToken()), to: testWhitelistAddr, status: 1,
+		logs: []*types.Log{transferLog(testTokenAddr, testDepositAddr)}, wantLogIndexes: []int{0}},
+
+	// native swapin
+	{name: "native swapin", token: withNative(swapinToken()), to: testDepositAddr, status: 1, wantLogIndexes: []int{0}},
+	{name: "native swapin to other receiver", token: withNative(swapinToken()), to: testOtherAddr, status: 1, wantErr: tokens.ErrTxWithWrongReceiver},
+	{name: "native swapin ignores whitelist", token: withWhitelist(withNative(swapinToken())), to: testWhitelistAddr, status: 1, wantErr: tokens.ErrTxWithWrongReceiver},
+
+	// swapout
+	{name: "swapout", token: swapoutToken(params.TxSwapout), to: testTokenAddr, input: swapoutInput(addressSwapoutFuncHash), status: 1, wantLogIndexes: []int{0}},
+	{name: "swapout with wrong func hash", token: swapoutToken(params.TxSwapout), to: testTokenAddr, input: swapoutInput(stringSwapoutFuncHash), status: 1, wantErr: tokens.ErrTxFuncHashMismatch},
+	{name: "swapout to other contract", token: swapoutToken(params.TxSwapout), to: testOtherAddr, input: swapoutInput(addressSwapoutFuncHash), status: 1, wantErr: tokens.ErrTxWithWrongReceiver},
+	{name: "swapout call by contract", token: withCallByContract(swapoutToken(params.TxSwapout)), to: testCallByContract, status: 1,
+		logs:           []*types.Log{transferLog(testTokenAddr, testOtherAddr), newFakeLog(testTokenAddr, addressSwapoutLogTopic, addressToHash(testSenderAddr), addressToHash(testOtherAddr))},
+		wantLogIndexes: []int{1}},
+	{name: "swapout call by contract without swapout log", token: withCallByContract(swapoutToken(params.TxSwapout)), to: testCallByContract, status: 1,
+		logs: []*types.Log{transferLog(testTokenAddr, testOtherAddr)}, wantErr: tokens.ErrSwapoutLogNotFound},
+
+	// swapout2
+	{name: "swapout2", token: swapoutToken(params.TxSwapout2), to: testTokenAddr, input: swapoutInput(stringSwapoutFuncHash), status: 1, wantLogIndexes: []int{0}},
+	{name: "swapout2 with wrong func hash", token: swapoutToken(params.TxSwapout2), to: testTokenAddr, input: swapoutInput(addressSwapoutFuncHash), status: 1, wantErr: tokens.ErrTxFuncHashMismatch},
+	{name: "swapout2 call by contract", token: withCallByContract(swapoutToken(params.TxSwapout2)), to: testCallByContract, status: 1,
+		logs: []*types.Log{newFakeLog(testTokenAddr, stringSwapoutLogTopic, addressToHash(testSenderAddr))}, wantLogIndexes: []int{0}},
+	{name: "swapout2 call by contract with wrong topics length", token: withCallByContract(swapoutToken(params.TxSwapout2)), to: testCallByContract, status: 1,
+		logs: []*types.Log{newFakeLog(testTokenAddr, stringSwapoutLogTopic, addressToHash(testSenderAddr), addressToHash(testOtherAddr))}, wantErr: tokens.ErrSwapoutLogNotFound},
+
+	// routerswap
+	{name: "routerswap", token: routerToken(params.TxRouterERC20Swap), to: testRouterAddr, status: 1,
+		logs:           []*types.Log{routerLog(testRouterAddr, routerAnySwapOutTopic), routerLog(testOtherAddr, routerAnySwapOutTopic), routerLog(testRouterAddr, routerAnySwapTradeTokensForNativeTopic)},
+		wantLogIndexes: []int{0, 2}},
+	{name: "routerswap trade tokens for tokens", token: routerToken(params.TxRouterERC20Swap), to: testRouterAddr, status: 1,
+		logs: []*types.Log{transferLog(testTokenAddr, testRouterAddr), routerLog(testRouterAddr, routerAnySwapTradeTokensForTokensTopic)}, wantLogIndexes: []int{1}},
+	{name: "routerswap without router log", token: routerToken(params.TxRouterERC20Swap), to: testRouterAddr, status: 1,
+		logs: []*types.Log{routerLog(testRouterAddr, logNFT721SwapOutTopic)}, wantErr: tokens.ErrRouterLogNotFound},
+	{name: "routerswap with removed log", token: routerToken(params.TxRouterERC20Swap), to: testRouterAddr, status: 1,
+		logs: []*types.Log{removedLog(routerLog(testRouterAddr, routerAnySwapOutTopic))}, wantErr: tokens.ErrRouterLogNotFound},
+	{name: "routerswap with failed receipt", token: routerToken(params.TxRouterERC20Swap), to: testRouterAddr, status: 0,
+		logs: []*types.Log{routerLog(testRouterAddr, routerAnySwapOutTopic)}, wantErr: errTxWithWrongReceiptStatus},
+	{name: "routerswap to other contract", token: routerToken(params.TxRouterERC20Swap), to: testOtherAddr, status: 1,
+		logs: []*types.Log{routerLog(testRouterAddr, routerAnySwapOutTopic)}, wantErr: tokens.ErrTxWithWrongReceiver},
+	{name: "routerswap call by whitelist", token: withWhitelist(routerToken(params.TxRouterERC20Swap)), to: testWhitelistAddr, status: 1,
+		logs: []*types.Log{routerLog(testRouterAddr, routerAnySwapOutTopic)}, wantLogIndexes: []int{0}},
+
+	// nftswap
+	{name: "nftswap 721", token: routerToken(params.TxRouterNFTSwap), to: testRouterAddr, status: 1,
+		logs: []*types.Log{routerLog(testRouterAddr, logNFT721SwapOutTopic)}, wantLogIndexes: []int{0}},
+	{name: "nftswap 1155 batch", token: routerToken(params.TxRouterNFTSwap), to: testRouterAddr, status: 1,
+		logs: []*types.Log{routerLog(testRouterAddr, logNFT1155SwapOutTopic), routerLog(testRouterAddr, logNFT1155SwapOutBatchTopic)}, wantLogIndexes: []int{0, 1}},
+	{name: "nftswap without nft log", token: routerToken(params.TxRouterNFTSwap), to: testRouterAddr, status: 1,
+		logs: []*types.Log{routerLog(testRouterAddr, routerAnySwapOutTopic)}, wantErr: tokens.ErrRouterLogNotFound},
+
+	// anycallswap
+	{name: "anycallswap", token: routerToken(params.TxRouterAnycallSwap), to: testRouterAddr, status: 1,
+		logs: []*types.Log{routerLog(testRouterAddr, logAnycallSwapOutTopic)}, wantLogIndexes: []int{0}},
+	{name: "anycallswap transfer", token: routerToken(params.TxRouterAnycallSwap), to: testRouterAddr, status: 1,
+		logs: []*types.Log{routerLog(testRouterAddr, logAnycallTransferSwapOutTopic)}, wantLogIndexes: []int{0}},
+	{name: "anycallswap without anycall log", token: routerToken(params.TxRouterAnycallSwap), to: testRouterAddr, status: 1,
+		logs: []*types.Log{routerLog(testRouterAddr, routerAnySwapOutTopic)}, wantErr: tokens.ErrRouterLogNotFound},
+}
+
+func TestVerifyTransaction(t *testing.T) {
+	for i, tc := range verifyTxTestCases {
+		chain := newFakeChain()
+		tx := newFakeTx(uint64(i), tc.to, tc.input)
+		chain.addTx(tx, tc.status, tc.logs...)
+		scanner := newFakeScanner(chain, tc.token)
+
+		matches, err := scanner.verifyTransaction(tx, tc.token)
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("%v: verify tx error mismatch, have %v, want %v", tc.name, err, tc.wantErr)
+			continue
+		}
+		if logIndexes := getMatchLogIndexes(matches); !reflect.DeepEqual(logIndexes, tc.wantLogIndexes) {
+			t.Errorf("%v: verify tx log indexes mismatch, have %v, want %v", tc.name, logIndexes, tc.wantLogIndexes)
+		}
+	}
+}
+
+func getMatchLogIndexes(matches []*swapMatch) (logIndexes []int) {
+	for _, match := range matches {
+		logIndexes = append(logIndexes, match.logIndex)
+	}
+	return logIndexes
+}
+
+func TestFindSwapMatches(t *testing.T) {
+	chain := newFakeChain()
+	tx := newFakeTx(0, testRouterAddr, nil)
+	chain.addTx(tx, 1, routerLog(testRouterAddr, routerAnySwapOutTopic), routerLog(testRouterAddr, logAnycallSwapOutTopic))
+	scanner := newFakeScanner(chain, swapinToken(), routerToken(params.TxRouterERC20Swap), routerToken(params.TxRouterAnycallSwap))
+
+	matches, err := scanner.findSwapMatches(tx)
+	if len(matches) != 2 {
+		t.Fatalf("find swap matches, have %v matches, want 2, err %v", len(matches), err)
+	}
+	if !matches[0].tokenCfg.IsRouterERC20Swap() || matches[0].logIndex != 0 {
+		t.Errorf("first match mismatch, have %v log %v", matches[0].tokenCfg.TxType, matches[0].logIndex)
+	}
+	if !matches[1].tokenCfg.IsRouterAnycallSwap() || matches[1].logIndex != 1 {
+		t.Errorf("second match mismatch, have %v log %v", matches[1].tokenCfg.TxType, matches[1].logIndex)
+	}
+}
+
+func setupTestStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scanserver-eth")
+	if err != nil {
+		t.Fatalf("create temp dir failed: %v", err)
+	}
+	s, err := storage.NewLevelDBStorage(dir, 16, 16)
+	if err != nil {
+		t.Fatalf("open leveldb storage failed: %v", err)
+	}
+	oldStorage := storage.DB()
+	storage.SetStorage(s)
+	t.Cleanup(func() {
+		storage.SetStorage(oldStorage)
+		_ = s.Close()
+		_ = os.RemoveAll(dir)
+	})
+}
+
+func addTestSwapPending(t *testing.T, txid string) {
+	if err := storage.DB().AddRegisteredSwapPending("fake", txid); err != nil {
+		t.Fatalf("add swap pending failed: %v", err)
+	}
+	if err := storage.DB().UpdateSwapPendingState(txid, mongodb.StateVerifying, ""); err != nil {
+		t.Fatalf("update swap pending to verifying failed: %v", err)
+	}
+}
+
+func checkSwapPendingState(t *testing.T, txid string, want mongodb.SwapState) {
+	pending, err := storage.DB().FindSwapPendingStatus(txid)
+	if err != nil {
+		t.Fatalf("find swap pending failed: %v", err)
+	}
+	if pending.Status != want {
+		t.Errorf("swap pending state mismatch, have %v, want %v (%v)", pending.Status, want, pending.LastError)
+	}
+}
+
+func TestScanTransaction(t *testing.T) {
+	setupTestStorage(t)
+
+	chain := newFakeChain()
+	tx := newFakeTx(0, testRouterAddr, nil)
+	chain.addTx(tx, 1, routerLog(testOtherAddr, routerAnySwapOutTopic), anySwapOutLog(testRouterAddr, 1000, 56, 1))
+	scanner := newFakeScanner(chain, routerToken(params.TxRouterERC20Swap))
+	txid := strings.ToLower(tx.Hash().Hex())
+
+	addTestSwapPending(t, txid)
+	if err := scanner.scanTransaction(txid); err != nil {
+		t.Fatalf("scan transaction failed: %v", err)
+	}
+	checkSwapPendingState(t, txid, mongodb.StateVerified)
+
+	swaps, err := storage.DB().FindRegisterdSwapTxid(txid)
+	if err != nil || len(swaps) != 1 {
+		t.Fatalf("find registered swap failed: %v", err)
+	}
+	swap := swaps[0]
+	if swap.Method != "swap.RegisterRouterSwap" || swap.ChainID != 56 || swap.LogIndex != 1 || swap.Status != mongodb.StateVerified {
+		t.Errorf("registered swap mismatch: %+v", swap)
+	}
+	if swap.Event == nil || swap.Event.Name != eventAnySwapOut || swap.Event.To != testOtherAddr || swap.Event.Amount != "1000" {
+		t.Errorf("registered swap event mismatch: %+v", swap.Event)
+	}
+	swaps, err = storage.DB().FindRegisteredSwapByAddress(testOtherAddr, 0, 10)
+	if err != nil || len(swaps) != 1 || swaps[0].Key != swap.Key {
+		t.Errorf("find registered swap by recipient, have %v records, want 1, err %v", len(swaps), err)
+	}
+}
+
+func TestScanTransactionFailed(t *testing.T) {
+	setupTestStorage(t)
+
+	chain := newFakeChain()
+	tx := newFakeTx(0, testOtherAddr, transferInput(testDepositAddr))
+	chain.addTx(tx, 1)
+	scanner := newFakeScanner(chain, swapinToken())
+
+	notExistTxid := strings.ToLower(newFakeTx(1, testTokenAddr, nil).Hash().Hex())
+	addTestSwapPending(t, notExistTxid)
+	if err := scanner.scanTransaction(notExistTxid); err == nil {
+		t.Errorf("scan not exist transaction, have no error")
+	}
+	checkSwapPendingState(t, notExistTxid, mongodb.StateFailed)
+
+	txid := strings.ToLower(tx.Hash().Hex())
+	addTestSwapPending(t, txid)
+	if err := scanner.scanTransaction(txid); err == nil || !strings.Contains(err.Error(), tokens.ErrTxWithWrongReceiver.Error()) {
+		t.Errorf("scan transaction to other contract, have error %v, want %v", err, tokens.ErrTxWithWrongReceiver)
+	}
+	checkSwapPendingState(t, txid, mongodb.StateFailed)
+}
+
+func TestCheckSwapConfirmations(t *testing.T) {
+	setupTestStorage(t)
+
+	chain := newFakeChain()
+	tx := newFakeTx(0, testTokenAddr, transferInput(testDepositAddr))
+	chain.addTx(tx, 1)
+	scanner := newFakeScanner(chain, swapinToken())
+	scanner.confirmations = 2
+	txid := strings.ToLower(tx.Hash().Hex())
+
+	addTestSwapPending(t, txid)
+	if err := scanner.scanTransaction(txid); err != nil {
+		t.Fatalf("scan transaction failed: %v", err)
+	}
+	swaps, err := storage.DB().FindRegisterdSwapTxid(txid)
+	if err != nil || len(swaps) != 1 {
+		t.Fatalf("find registered swap failed: %v", err)
+	}
+	swap := swaps[0]
+	if swap.Status != mongodb.StateVerifying || swap.BlockHeight != 1 || swap.BlockHash == "" {
+		t.Fatalf("registered swap waiting confirmations mismatch: %+v", swap)
+	}
+
+	if err := scanner.checkSwapBlock(swap, 2); !errors.Is(err, errWaitConfirmations) {
+		t.Errorf("check swap block without enough confirmations, have error %v, want %v", err, errWaitConfirmations)
+	}
+	if err := scanner.checkSwapBlock(swap, 3); err != nil {
+		t.Errorf("check swap block with enough confirmations failed: %v", err)
+	}
+	swaps, _ = storage.DB().FindRegisterdSwapTxid(txid)
+	if swaps[0].Status != mongodb.StateVerified {
+		t.Errorf("registered swap state mismatch, have %v, want %v", swaps[0].Status, mongodb.StateVerified)
+	}
+
+	// reorged tx waits to be packed in another block
+	chain.reorg(tx.Hash())
+	if err := scanner.checkSwapBlock(swaps[0], 3); !errors.Is(err, errSwapTxMissing) {
+		t.Errorf("check reorged swap block, have error %v, want %v", err, errSwapTxMissing)
+	}
+	swaps, _ = storage.DB().FindRegisterdSwapTxid(txid)
+	if swaps[0].Status != mongodb.StateVerifying || swaps[0].BlockHash != "" || swaps[0].MissingChecks != 1 {
+		t.Errorf("reorged swap mismatch, have state %v block %v missing checks %v", swaps[0].Status, swaps[0].BlockHash, swaps[0].MissingChecks)
+	}
+
+	chain.addTx(tx, 1)
+	if err := scanner.checkSwapBlock(swaps[0], 3); !errors.Is(err, errWaitConfirmations) {
+		t.Errorf("check swap packed again, have error %v, want %v", err, errWaitConfirmations)
+	}
+	if err := scanner.checkSwapBlock(swaps[0], 4); err != nil {
+		t.Errorf("check swap packed again with enough confirmations failed: %v", err)
+	}
+	swaps, _ = storage.DB().FindRegisterdSwapTxid(txid)
+	if swaps[0].Status != mongodb.StateVerified || swaps[0].BlockHeight != 2 || swaps[0].MissingChecks != 0 {
+		t.Errorf("swap packed again mismatch, have state %v block %v missing checks %v", swaps[0].Status, swaps[0].BlockHeight, swaps[0].MissingChecks)
+	}
+
+	// verified swap is packed in another confirmed block before it is posted
+	chain.reorg(tx.Hash())
+	chain.addTx(tx, 1)
+	if err := scanner.checkSwapBlock(swaps[0], 5); err != nil {
+		t.Errorf("check verified swap packed in another block failed: %v", err)
+	}
+	swaps, _ = storage.DB().FindRegisterdSwapTxid(txid)
+	if swaps[0].Status != mongodb.StateVerified || swaps[0].BlockHeight != 3 {
+		t.Errorf("verified swap packed in another block mismatch, have state %v block %v", swaps[0].Status, swaps[0].BlockHeight)
+	}
+}
+
+func TestCheckSwapConfirmationsTxMissing(t *testing.T) {
+	setupTestStorage(t)
+	oldChecks, oldTimeout := maxSwapTxMissingChecks, swapTxMissingTimeout
+	maxSwapTxMissingChecks, swapTxMissingTimeout = 2, 0
+	defer func() { maxSwapTxMissingChecks, swapTxMissingTimeout = oldChecks, oldTimeout }()
+
+	chain := newFakeChain()
+	tx := newFakeTx(0, testTokenAddr, transferInput(testDepositAddr))
+	chain.addTx(tx, 1)
+	scanner := newFakeScanner(chain, swapinToken())
+	scanner.confirmations = 2
+	txid := strings.ToLower(tx.Hash().Hex())
+
+	addTestSwapPending(t, txid)
+	if err := scanner.scanTransaction(txid); err != nil {
+		t.Fatalf("scan transaction failed: %v", err)
+	}
+	chain.reorg(tx.Hash())
+
+	wantErrs := []error{errSwapTxMissing, errSwapReorged}
+	wantStates := []mongodb.SwapState{mongodb.StateVerifying, mongodb.StateFailed}
+	for i, wantErr := range wantErrs {
+		swaps, _ := storage.DB().FindRegisterdSwapTxid(txid)
+		if err := scanner.checkSwapBlock(swaps[0], 3); !errors.Is(err, wantErr) {
+			t.Errorf("check missing swap tx %v times, have error %v, want %v", i+1, err, wantErr)
+		}
+		swaps, _ = storage.DB().FindRegisterdSwapTxid(txid)
+		if swaps[0].Status != wantStates[i] {
+			t.Errorf("check missing swap tx %v times, have state %v, want %v", i+1, swaps[0].Status, wantStates[i])
+		}
+	}
+}
+
+func TestScanRangeByBlocksRetryFailedBlock(t *testing.T) {
+	setupTestStorage(t)
+
+	chain := newFakeChain()
+	tx := newFakeTx(0, testRouterAddr, nil)
+	chain.addTx(tx, 1, anySwapOutLog(testRouterAddr, 1000, 56, 0))
+	scanner := newFakeScanner(chain, routerToken(params.TxRouterERC20Swap))
+	txid := strings.ToLower(tx.Hash().Hex())
+
+	chain.setReceiptErr(errors.New("connection reset"))
+	if next := scanner.scanRangeByBlocks(1, 1); next != 1 {
+		t.Errorf("scan block with failed tx, have next %v, want 1", next)
+	}
+	if scanInfo, err := storage.DB().FindChainScanInfo("fake"); err == nil && scanInfo.BlockHeight != 0 {
+		t.Errorf("scan info is updated past the failed block")
+	}
+	if swaps, _ := storage.DB().FindRegisterdSwapTxid(txid); len(swaps) != 0 {
+		t.Errorf("swap of failed tx is registered")
+	}
+
+	chain.setReceiptErr(nil)
+	if next := scanner.scanRangeByBlocks(1, 1); next != 2 {
+		t.Errorf("rescan block, have next %v, want 2", next)
+	}
+	if scanInfo, err := storage.DB().FindChainScanInfo("fake"); err != nil || scanInfo.BlockHeight != 1 {
+		t.Errorf("scan info mismatch, have %+v, err %v", scanInfo, err)
+	}
+	if swaps, err := storage.DB().FindRegisterdSwapTxid(txid); err != nil || len(swaps) != 1 {
+		t.Errorf("find registered swap of rescanned block, have %v records, err %v", len(swaps), err)
+	}
+}
+
+func TestScanRangeByLogsRetryFailedRange(t *testing.T) {
+	setupTestStorage(t)
+
+	chain := newFakeChain()
+	tx := newFakeTx(0, testRouterAddr, nil)
+	chain.addTx(tx, 1, anySwapOutLog(testRouterAddr, 1000, 56, 0))
+	scanner := newFakeScanner(chain, routerToken(params.TxRouterERC20Swap))
+	scanner.scanLogs = true
+	scanner.maxLogsRange = defaultMaxLogsRange
+	scanner.logsRange = defaultMaxLogsRange
+	txid := strings.ToLower(tx.Hash().Hex())
+
+	chain.setReceiptErr(errors.New("connection reset"))
+	if next := scanner.scanRangeByLogs(1, 1); next != 1 {
+		t.Errorf("scan logs range with failed tx, have next %v, want 1", next)
+	}
+	if scanInfo, err := storage.DB().FindChainScanInfo("fake"); err == nil && scanInfo.BlockHeight != 0 {
+		t.Errorf("scan info is updated past the failed range")
+	}
+
+	chain.setReceiptErr(nil)
+	if next := scanner.scanRangeByLogs(1, 1); next != 2 {
+		t.Errorf("rescan logs range, have next %v, want 2", next)
+	}
+	if swaps, err := storage.DB().FindRegisterdSwapTxid(txid); err != nil || len(swaps) != 1 {
+		t.Errorf("find registered swap of rescanned range, have %v records, err %v", len(swaps), err)
+	}
+}
+
+func TestScanRangeByBlocksLeasedByOthers(t *testing.T) {
+	setupTestStorage(t)
+
+	chain := newFakeChain()
+	tx := newFakeTx(0, testRouterAddr, nil)
+	chain.addTx(tx, 1, anySwapOutLog(testRouterAddr, 1000, 56, 0))
+	scanner := newFakeScanner(chain, routerToken(params.TxRouterERC20Swap))
+
+	oldID := mongodb.GetInstanceID()
+	defer mongodb.SetInstanceID(oldID)
+
+	mongodb.SetInstanceID("instance-1")
+	if err := storage.DB().LeaseChainScan("fake", time.Minute); err != nil {
+		t.Fatalf("lease chain scan failed: %v", err)
+	}
+	mongodb.SetInstanceID("instance-2")
+	if next := scanner.scanRangeByBlocks(1, 1); next != 1 {
+		t.Errorf("scan chain leased by others, have next %v, want 1", next)
+	}
+	if !scanner.scanLeaseTime.IsZero() {
+		t.Errorf("scanner holds lease of chain leased by others")
+	}
+
+	mongodb.SetInstanceID("instance-1")
+	if err := storage.DB().ReleaseChainScan("fake"); err != nil {
+		t.Fatalf("release chain scan failed: %v", err)
+	}
+	mongodb.SetInstanceID("instance-2")
+	if next := scanner.scanRangeByBlocks(1, 1); next != 2 {
+		t.Errorf("scan released chain, have next %v, want 2", next)
+	}
+	if scanInfo, err := storage.DB().FindChainScanInfo("fake"); err != nil || scanInfo.BlockHeight != 1 || scanInfo.LockedBy != "instance-2" {
+		t.Errorf("scan info mismatch, have %+v, err %v", scanInfo, err)
+	}
+}
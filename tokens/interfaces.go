@@ -31,6 +31,7 @@ var (
 	ErrTxWithWrongContract  = errors.New("tx with wrong contract")
 	ErrTxWithWrongInput     = errors.New("tx with wrong input data")
 	ErrTxWithWrongLogData   = errors.New("tx with wrong log data")
+	ErrTxWithWrongTopics    = errors.New("tx with wrong log topics")
 	ErrTxIsAggregateTx      = errors.New("tx is aggregate tx")
 	ErrWrongP2shBindAddress = errors.New("wrong p2sh bind address")
 	ErrTxFuncHashMismatch   = errors.New("tx func hash mismatch")
@@ -0,0 +1,196 @@
+package worker
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/weijun-sh/gethscan-server/cmd/utils"
+	"github.com/weijun-sh/gethscan-server/log"
+	"github.com/weijun-sh/gethscan-server/mongodb"
+	"github.com/weijun-sh/gethscan-server/params"
+	"github.com/weijun-sh/gethscan-server/storage"
+)
+
+// StartArchiveJob archive finished swap records periodically if retention is configed
+func StartArchiveJob() {
+	config := params.GetRetentionConfig()
+	mongodb.SetWebhookEventTTL(time.Duration(config.WebhookEventTTLDays) * 24 * time.Hour)
+	if config.ArchiveAfterDays <= 0 {
+		return
+	}
+	go loopArchiveSwaps(config)
+}
+
+func loopArchiveSwaps(config *params.RetentionConfig) {
+	log.Info("start archive swaps loop job", "archiveAfterDays", config.ArchiveAfterDays, "archiveTo", config.ArchiveTo)
+	for {
+		before := time.Now().AddDate(0, 0, -config.ArchiveAfterDays).Unix()
+		count, err := ArchiveSwaps(config, before)
+		if err != nil {
+			log.Warn("archive swaps failed", "before", before, "archived", count, "err", err)
+		} else if count > 0 {
+			log.Info("archive swaps success", "before", before, "archived", count)
+		}
+		for i := int64(0); i < config.ArchiveInterval; i++ {
+			if utils.IsCleanuping() {
+				return
+			}
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+// ArchiveSwaps move finished records registered before 'before' (unix seconds)
+// to archive table or export files in batches, return count of archived records.
+func ArchiveSwaps(config *params.RetentionConfig, before int64) (count int, err error) {
+	var swaps []*mongodb.MgoArchivedSwap
+	for !utils.IsCleanuping() {
+		swaps, err = storage.DB().FindSwapsToArchive(before, config.ArchiveBatchSize)
+		if err != nil || len(swaps) == 0 {
+			return count, err
+		}
+		if config.ArchiveTo == params.ArchiveToFile {
+			_, err = exportArchivedSwaps(config.GetArchiveDir(), swaps)
+		} else {
+			err = storage.DB().AddArchivedSwaps(swaps)
+		}
+		if err != nil {
+			return count, err
+		}
+		if err = storage.DB().RemoveArchivedSources(swaps); err != nil {
+			return count, err
+		}
+		count += len(swaps)
+		if len(swaps) < config.ArchiveBatchSize {
+			break
+		}
+	}
+	return count, nil
+}
+
+// exportArchivedSwaps write records to a new gzip compressed json lines file in dir
+func exportArchivedSwaps(dir string, swaps []*mongodb.MgoArchivedSwap) (fileName string, err error) {
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	fileName = filepath.Join(dir, fmt.Sprintf("swaps-%v.jsonl.gz", time.Now().UTC().Format("20060102-150405.000000000")))
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = os.Remove(fileName)
+		}
+	}()
+	zw := gzip.NewWriter(file)
+	encoder := json.NewEncoder(zw)
+	for _, a := range swaps {
+		if err = encoder.Encode(a); err != nil {
+			return "", err
+		}
+	}
+	if err = zw.Close(); err != nil {
+		return "", err
+	}
+	log.Info("export archived swaps", "file", fileName, "count", len(swaps))
+	return fileName, nil
+}
+
+// getArchiveFilePath get path of export file in archive dir, only base file name
+// is accepted, so admin calls can not read files out of archive dir.
+func getArchiveFilePath(dir, fileName string) (string, error) {
+	if fileName == "" || fileName == "." || fileName == ".." ||
+		fileName != filepath.Base(fileName) || filepath.IsAbs(fileName) {
+		return "", fmt.Errorf("invalid export file name '%v', must be a file name in archive dir", fileName)
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	filePath := filepath.Join(dir, fileName)
+	// the file may be a symbolic link to other places
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	realPath, err := filepath.EvalSymlinks(filePath)
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(realDir, realPath); err != nil || rel != filepath.Base(rel) || rel == ".." {
+		return "", fmt.Errorf("export file '%v' is out of archive dir", fileName)
+	}
+	return filePath, nil
+}
+
+// readArchiveFile read records matching filter from export file
+func readArchiveFile(fileName string, filter *mongodb.ArchiveFilter) ([]*mongodb.MgoArchivedSwap, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	zr, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	var result []*mongodb.MgoArchivedSwap
+	decoder := json.NewDecoder(zr)
+	for {
+		var a mongodb.MgoArchivedSwap
+		err = decoder.Decode(&a)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read archive file '%v' failed, %w", fileName, err)
+		}
+		if filter.IsMatch(&a) {
+			result = append(result, &a)
+		}
+	}
+	return result, nil
+}
+
+// RestoreArchivedSwaps restore archived records matching filter to their tables,
+// from export file in archive dir if 'fileName' is not empty (the file is kept unchanged),
+// otherwise from archive table. return count of restored records.
+func RestoreArchivedSwaps(filter *mongodb.ArchiveFilter, fileName string) (int, error) {
+	var swaps []*mongodb.MgoArchivedSwap
+	var err error
+	if fileName != "" {
+		var filePath string
+		filePath, err = getArchiveFilePath(params.GetRetentionConfig().GetArchiveDir(), fileName)
+		if err != nil {
+			return 0, err
+		}
+		swaps, err = readArchiveFile(filePath, filter)
+	} else {
+		swaps, err = storage.DB().FindArchivedSwaps(filter, 0)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err = storage.DB().RestoreArchivedSwaps(swaps); err != nil {
+		return 0, err
+	}
+	log.Info("restore archived swaps success", "filter", filter, "file", fileName, "count", len(swaps))
+	return len(swaps), nil
+}
+
+// PurgeArchivedSwaps remove archived records matching filter from archive table permanently,
+// export files are managed by operators.
+func PurgeArchivedSwaps(filter *mongodb.ArchiveFilter) (int, error) {
+	return storage.DB().PurgeArchivedSwaps(filter)
+}
@@ -0,0 +1,61 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetArchiveFilePath(t *testing.T) {
+	root, err := ioutil.TempDir("", "scanserver-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	dir := filepath.Join(root, "archive")
+	if err = os.MkdirAll(filepath.Join(dir, "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	exportFile := "swaps-20220101-000000.000000000.jsonl.gz"
+	if err = ioutil.WriteFile(filepath.Join(dir, exportFile), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(root, "secret")
+	if err = ioutil.WriteFile(outside, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink(outside, filepath.Join(dir, "link.jsonl.gz")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		fileName string
+		wantErr  bool
+	}{
+		{fileName: exportFile},
+		{fileName: "", wantErr: true},
+		{fileName: ".", wantErr: true},
+		{fileName: "..", wantErr: true},
+		{fileName: "../secret", wantErr: true},
+		{fileName: "sub/../" + exportFile, wantErr: true},
+		{fileName: outside, wantErr: true},
+		{fileName: "link.jsonl.gz", wantErr: true},
+		{fileName: "notexist.jsonl.gz", wantErr: true},
+	}
+	for _, test := range tests {
+		filePath, err := getArchiveFilePath(dir, test.fileName)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("get archive file path of '%v', have %v, want error", test.fileName, filePath)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("get archive file path of '%v' failed: %v", test.fileName, err)
+		} else if want := filepath.Join(dir, test.fileName); filePath != want {
+			t.Errorf("get archive file path of '%v', have %v, want %v", test.fileName, filePath, want)
+		}
+	}
+}
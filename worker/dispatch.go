@@ -0,0 +1,159 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weijun-sh/gethscan-server/log"
+	"github.com/weijun-sh/gethscan-server/metrics"
+	"github.com/weijun-sh/gethscan-server/mongodb"
+	"github.com/weijun-sh/gethscan-server/params"
+	"golang.org/x/time/rate"
+)
+
+var errSwapServerUnavailable = errors.New("swap server is unavailable (circuit breaker is open)")
+
+var (
+	// every swap server has its own queue, workers, rate limiter and circuit breaker,
+	// so a slow or rate limiting swap server does not block posting to others.
+	serverPosters     = make(map[string]*serverPoster)
+	serverPostersLock sync.Mutex
+
+	// swaps queued or being posted, avoid dispatching a swap twice
+	dispatchedSwaps     = make(map[string]struct{})
+	dispatchedSwapsLock sync.Mutex
+)
+
+type serverPoster struct {
+	server  string
+	queue   chan *mongodb.MgoRegisteredSwap
+	limiter *rate.Limiter
+	breaker *circuitBreaker
+}
+
+// getServerPoster get poster of swap server, start its workers if not exist
+func getServerPoster(server string) *serverPoster {
+	key := strings.ToLower(server)
+	serverPostersLock.Lock()
+	defer serverPostersLock.Unlock()
+	if poster, exist := serverPosters[key]; exist {
+		return poster
+	}
+	config := params.GetPostDispatchConfig(server)
+	poster := &serverPoster{
+		server:  server,
+		queue:   make(chan *mongodb.MgoRegisteredSwap, config.QueueSize),
+		limiter: rate.NewLimiter(rate.Limit(config.RateLimit), config.RateBurst),
+		breaker: &circuitBreaker{
+			threshold: config.BreakerThreshold,
+			cooldown:  time.Duration(config.BreakerCooldown) * time.Second,
+		},
+	}
+	for i := 0; i < config.Workers; i++ {
+		go poster.loopPost()
+	}
+	serverPosters[key] = poster
+	log.Info("start swap server poster", "server", metrics.GetHost(server), "workers", config.Workers,
+		"queue", config.QueueSize, "rate", config.RateLimit, "burst", config.RateBurst,
+		"breakerThreshold", config.BreakerThreshold, "breakerCooldown", config.BreakerCooldown)
+	return poster
+}
+
+// dispatchSwapPost queue swap to the workers of its swap server,
+// return false if swap is not queued (eg. queue is full or circuit breaker is open),
+// swaps not queued are found and dispatched again later.
+func dispatchSwapPost(p *mongodb.MgoRegisteredSwap) bool {
+	poster := getServerPoster(p.SwapServer)
+	if poster.breaker.isOpen() {
+		return false
+	}
+	dispatchedSwapsLock.Lock()
+	defer dispatchedSwapsLock.Unlock()
+	if _, exist := dispatchedSwaps[p.Key]; exist {
+		return false
+	}
+	select {
+	case poster.queue <- p:
+		dispatchedSwaps[p.Key] = struct{}{}
+		return true
+	default:
+		return false
+	}
+}
+
+func (poster *serverPoster) loopPost() {
+	for p := range poster.queue {
+		_, _ = PostBridgeSwap(p)
+		dispatchedSwapsLock.Lock()
+		delete(dispatchedSwaps, p.Key)
+		dispatchedSwapsLock.Unlock()
+	}
+}
+
+// post wait for rate limiter, and record result in circuit breaker.
+// the only post allowed by a half open circuit breaker is taken right before posting.
+func (poster *serverPoster) post(swap *swapPost) *postResult {
+	_ = poster.limiter.Wait(context.Background())
+	if !poster.breaker.allow() {
+		return &postResult{result: mongodb.PostResultTransient, err: errSwapServerUnavailable, response: errSwapServerUnavailable.Error()}
+	}
+	start := time.Now()
+	res := rpcPost(swap)
+	metrics.ObservePost(swap.swapServer, res.result, start)
+	// any response other than transient failure means swap server is working
+	if poster.breaker.record(res.result != mongodb.PostResultTransient) {
+		log.Warn("swap server circuit breaker is open", "server", metrics.GetHost(poster.server), "failures", poster.breaker.threshold, "cooldown", poster.breaker.cooldown, "err", res.err)
+	}
+	metrics.SetPostBreakerOpen(poster.server, poster.breaker.isOpen())
+	return res
+}
+
+// circuitBreaker opens after so many consecutive failures,
+// after cooldown it lets one post through, and closes if that post succeeds.
+type circuitBreaker struct {
+	lock      sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+}
+
+// allow is post allowed, at most one post is allowed every cooldown when open
+func (cb *circuitBreaker) allow() bool {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if cb.failures < cb.threshold {
+		return true
+	}
+	now := time.Now()
+	if now.Before(cb.openUntil) {
+		return false
+	}
+	cb.openUntil = now.Add(cb.cooldown)
+	return true
+}
+
+func (cb *circuitBreaker) isOpen() bool {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	return cb.failures >= cb.threshold && time.Now().Before(cb.openUntil)
+}
+
+// record result of post, return true if circuit breaker becomes open
+func (cb *circuitBreaker) record(success bool) (opened bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if success {
+		cb.failures = 0
+		return false
+	}
+	cb.failures++
+	if cb.failures < cb.threshold {
+		return false
+	}
+	cb.openUntil = time.Now().Add(cb.cooldown)
+	return cb.failures == cb.threshold
+}
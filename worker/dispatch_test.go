@@ -0,0 +1,137 @@
+package worker
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weijun-sh/gethscan-server/mongodb"
+	"golang.org/x/time/rate"
+)
+
+const testBreakerCooldown = 50 * time.Millisecond
+
+func checkBreaker(t *testing.T, step string, cb *circuitBreaker, wantOpen bool) {
+	if isOpen := cb.isOpen(); isOpen != wantOpen {
+		t.Errorf("%v: circuit breaker is open %v, want %v", step, isOpen, wantOpen)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	cb := &circuitBreaker{threshold: 2, cooldown: testBreakerCooldown}
+
+	if !cb.allow() || cb.record(false) {
+		t.Errorf("first failure: circuit breaker is not allowed or opened")
+	}
+	checkBreaker(t, "first failure", cb, false)
+	if !cb.allow() || !cb.record(false) {
+		t.Errorf("failures reach threshold: circuit breaker is not allowed or not opened")
+	}
+	checkBreaker(t, "failures reach threshold", cb, true)
+	if cb.allow() {
+		t.Errorf("open circuit breaker allows post before cooldown")
+	}
+
+	// half open after cooldown, only one probe is allowed
+	time.Sleep(testBreakerCooldown)
+	checkBreaker(t, "after cooldown", cb, false)
+	if !cb.allow() {
+		t.Errorf("half open circuit breaker does not allow probe")
+	}
+	if cb.allow() {
+		t.Errorf("half open circuit breaker allows a second probe")
+	}
+	if cb.record(false) {
+		t.Errorf("failed probe reports circuit breaker opened again")
+	}
+	checkBreaker(t, "failed probe", cb, true)
+
+	// closed by successful probe
+	time.Sleep(testBreakerCooldown)
+	if !cb.allow() || cb.record(true) {
+		t.Errorf("successful probe: circuit breaker is not allowed or opened")
+	}
+	checkBreaker(t, "successful probe", cb, false)
+	for i := 0; i < 3; i++ {
+		if !cb.allow() {
+			t.Errorf("closed circuit breaker does not allow post %v", i)
+		}
+	}
+}
+
+// newTestServerPoster add poster of server without workers, so queued swaps stay in queue
+func newTestServerPoster(t *testing.T, server string, queueSize int) *serverPoster {
+	poster := &serverPoster{
+		server:  server,
+		queue:   make(chan *mongodb.MgoRegisteredSwap, queueSize),
+		limiter: rate.NewLimiter(rate.Inf, 1),
+		breaker: &circuitBreaker{threshold: 1, cooldown: time.Minute},
+	}
+	key := strings.ToLower(server)
+	serverPostersLock.Lock()
+	serverPosters[key] = poster
+	serverPostersLock.Unlock()
+	t.Cleanup(func() {
+		serverPostersLock.Lock()
+		delete(serverPosters, key)
+		serverPostersLock.Unlock()
+		dispatchedSwapsLock.Lock()
+		dispatchedSwaps = make(map[string]struct{})
+		dispatchedSwapsLock.Unlock()
+	})
+	return poster
+}
+
+func TestDispatchSwapPost(t *testing.T) {
+	server := "http://127.0.0.1:1/rpc"
+	poster := newTestServerPoster(t, server, 2)
+	swap1 := &mongodb.MgoRegisteredSwap{Key: "swap1", SwapServer: server}
+	swap2 := &mongodb.MgoRegisteredSwap{Key: "swap2", SwapServer: server}
+	swap3 := &mongodb.MgoRegisteredSwap{Key: "swap3", SwapServer: server}
+
+	if !dispatchSwapPost(swap1) {
+		t.Errorf("dispatch swap failed")
+	}
+	if dispatchSwapPost(swap1) {
+		t.Errorf("dispatch swap twice, have queued again")
+	}
+	if !dispatchSwapPost(swap2) {
+		t.Errorf("dispatch another swap failed")
+	}
+	if dispatchSwapPost(swap3) {
+		t.Errorf("dispatch swap to full queue, have queued")
+	}
+	if len(poster.queue) != 2 {
+		t.Errorf("queue length mismatch, have %v, want 2", len(poster.queue))
+	}
+
+	// swap can be dispatched again after posted by worker
+	<-poster.queue
+	dispatchedSwapsLock.Lock()
+	delete(dispatchedSwaps, swap1.Key)
+	dispatchedSwapsLock.Unlock()
+	if !dispatchSwapPost(swap1) {
+		t.Errorf("dispatch posted swap again failed")
+	}
+
+	<-poster.queue
+	poster.breaker.record(false)
+	if dispatchSwapPost(swap3) {
+		t.Errorf("dispatch swap when circuit breaker is open, have queued")
+	}
+}
+
+func TestServerPosterRefusedByBreaker(t *testing.T) {
+	server := "http://127.0.0.1:1/rpc"
+	poster := newTestServerPoster(t, server, 1)
+	poster.breaker.record(false)
+
+	res := poster.post(&swapPost{txid: "0x1234", pairID: "usdc", rpcMethod: "swap.Swapin", swapServer: server})
+	if res.result != mongodb.PostResultTransient || !errors.Is(res.err, errSwapServerUnavailable) {
+		t.Errorf("post with open circuit breaker, have result %v err %v, want %v", res.result, res.err, errSwapServerUnavailable)
+	}
+	if poster.breaker.failures != 1 {
+		t.Errorf("refused post is recorded in circuit breaker, failures %v", poster.breaker.failures)
+	}
+}
@@ -0,0 +1,43 @@
+package worker
+
+import (
+	"time"
+
+	"github.com/weijun-sh/gethscan-server/cmd/utils"
+	"github.com/weijun-sh/gethscan-server/log"
+	"github.com/weijun-sh/gethscan-server/metrics"
+	"github.com/weijun-sh/gethscan-server/mongodb"
+	"github.com/weijun-sh/gethscan-server/storage"
+)
+
+var updateQueueMetricsInterval = 30 * time.Second
+
+// StartMetricsJob update metrics which are not counted along the pipeline
+func StartMetricsJob() {
+	go loopUpdateQueueMetrics()
+}
+
+func loopUpdateQueueMetrics() {
+	for {
+		if utils.IsCleanuping() {
+			return
+		}
+		updateQueueMetrics()
+		time.Sleep(updateQueueMetricsInterval)
+	}
+}
+
+func updateQueueMetrics() {
+	pending, registered, err := storage.DB().CountSwapStates()
+	if err != nil {
+		log.Warn("count swap states failed", "err", err)
+		return
+	}
+	for _, state := range mongodb.AllSwapStates {
+		if state.IsFinal() {
+			continue
+		}
+		metrics.SetQueueDepth("swapPending", string(state), pending[state])
+		metrics.SetQueueDepth("swapRegistered", string(state), registered[state])
+	}
+}
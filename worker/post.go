@@ -1,93 +1,167 @@
 package worker
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
-	"strings"
 	"time"
 
+	"github.com/weijun-sh/gethscan-server/cmd/utils"
 	"github.com/weijun-sh/gethscan-server/log"
 	"github.com/weijun-sh/gethscan-server/mongodb"
 	"github.com/weijun-sh/gethscan-server/rpc/client"
 	"github.com/weijun-sh/gethscan-server/params"
-	"github.com/weijun-sh/gethscan-server/tokens"
+	"github.com/weijun-sh/gethscan-server/storage"
+	"github.com/weijun-sh/gethscan-server/tokens/eth"
 )
 
 var (
 	rpcRetryCount   = 3
 	rpcInterval     = 1 * time.Second
 	postInterval    = 1 * time.Second
-	cachedSwapPosts *Ring
-)
 
-const (
-	postSwapSuccessResult          = "success"
-	bridgeSwapExistKeywords        = "mgoError: Item is duplicate"
-	routerSwapExistResult          = "already registered"
-	routerSwapExistResultTmp       = "alreday registered"
-	httpTimeoutKeywords            = "Client.Timeout exceeded while awaiting headers"
-	errConnectionRefused           = "connect: connection refused"
-	errMaximumRequestLimit         = "You have reached maximum request limit"
-	rpcQueryErrKeywords            = "rpc query error"
-	errDepositLogNotFountorRemoved = "return error: json-rpc error -32099, verify swap failed! deposit log not found or removed"
-	swapIsClosedResult             = "swap is closed"
-	swapTradeNotSupport            = "swap trade not support"
-	txWithWrongContract            = "tx with wrong contract"
+	retryPostInterval = 5 * time.Second
+	maxRetryPostLimit = 100
+
+	// posting swap is retried if not finished in this time (eg. program crashed)
+	postingTimeout = int64(20 * 60) // seconds
+	// lease of swap being posted, expires after posting timeout
+	postLeaseDuration = time.Duration(postingTimeout) * time.Second
+
+	checkConfirmationsInterval = 3 * time.Second
 )
 
+
 // StartAggregateJob aggregate job
 func StartPostJob() {
 	mongodb.MgoWaitGroup.Add(1)
-	cachedSwapPosts = NewRing(100)
+	initPostResultRules()
 	go loopSwapRegister()
+	go loopRetrySwapPost()
 }
 
 func loopSwapRegister() {
-	log.Info("start SwapRegister loop job")
-	offset := 0
+	var cursor *mongodb.SwapCursor
 	MaxParseRegisteredLimit := params.GetMaxParseRegisteredLimit()
 	if MaxParseRegisteredLimit < 10 {
 		MaxParseRegisteredLimit = 10
 	}
-	fmt.Printf("MaxParseRegisteredLimit : %v\n", MaxParseRegisteredLimit)
+	log.Info("start SwapRegister loop job", "MaxParseRegisteredLimit", MaxParseRegisteredLimit)
 	for {
-		sp, err := mongodb.FindRegisterdSwap("", offset, MaxParseRegisteredLimit)
+		sp, err := storage.FindRegisterdSwap("", cursor, MaxParseRegisteredLimit)
 		lenPending := len(sp)
 		if err != nil || lenPending == 0 {
-			offset = 0
+			cursor = nil
 			time.Sleep(2 * time.Second)
 			continue
 		}
 		log.Info("loopSwapRegister", "swap", sp, "len", lenPending)
 		for _, p := range sp {
-			PostBridgeSwap(p)
+			dispatchSwapPost(p)
+		}
+		cursor = sp[lenPending-1].Cursor()
+		if lenPending < MaxParseRegisteredLimit {
+			cursor = nil
+			time.Sleep(postInterval)
 		}
-                offset += MaxParseRegisteredLimit
-                if lenPending < MaxParseRegisteredLimit {
-                        offset = 0
-                        time.Sleep(1 * time.Second)
-                }
-                time.Sleep(1 * time.Second)
 	}
 }
 
+// PostBridgeSwap post registered swap to swap server, return (retryErr, resultErr),
+// retryErr is not nil if post failed for transient reasons and is scheduled to retry.
 func PostBridgeSwap(p *mongodb.MgoRegisteredSwap) (error, error) {
-	ok, err := postBridgeSwap(p)
-	if ok == nil {
-		if err == nil {
-			log.Info("post Swap success", "Key", p.Key, "chainID", p.ChainID, "pairID", p.PairID, "method", p.Method, "rpc", p.SwapServer)
-			mongodb.UpdateRegisteredSwapStatusSuccess(p.Key)
-		} else {
-			log.Info("post Swap finished", "Key", p.Key, "chainID", p.ChainID, "pairID", p.PairID, "method", p.Method, "rpc", p.SwapServer, "err", err)
-			mongodb.UpdateRegisteredSwapStatus(p.Key, err.Error())
+	poster := getServerPoster(p.SwapServer)
+	if poster.breaker.isOpen() {
+		// swap is not changed, and is posted after the circuit breaker closes
+		return errSwapServerUnavailable, nil
+	}
+	// several instances may share the database, only the lease holder posts the swap.
+	// p may be read before another instance posted it, post the leased swap instead.
+	leased, err := storage.DB().LeaseRegisteredSwap(p.Key, p.Status, postLeaseDuration)
+	if err != nil {
+		log.Info("post Swap skipped", "Key", p.Key, "status", p.Status, "err", err)
+		return nil, err
+	}
+	p = leased
+	defer func() { _ = storage.DB().ReleaseRegisteredSwap(p.Key) }()
+	if err := eth.RecheckSwapBlock(p); err != nil {
+		log.Info("post Swap skipped", "Key", p.Key, "chain", p.Chain, "blockHash", p.BlockHash, "err", err)
+		return nil, err
+	}
+	if err := storage.DB().UpdateRegisteredSwapPosting(p.Key, time.Now().Unix()+postingTimeout); err != nil {
+		log.Info("post Swap skipped", "Key", p.Key, "status", p.Status, "err", err)
+		return nil, err
+	}
+	if p.Status == mongodb.StateVerified {
+		notifyWebhooks(newWebhookPayload(params.WebhookEventVerified, p))
+	}
+	res := postBridgeSwap(p)
+	switch res.result {
+	case mongodb.PostResultSuccess:
+		log.Info("post Swap success", "Key", p.Key, "chainID", p.ChainID, "pairID", p.PairID, "logIndex", p.LogIndex, "method", p.Method, "rpc", p.SwapServer)
+		if storage.DB().UpdateRegisteredSwapPostResult(p.Key, mongodb.StatePosted, res.result, res.code, res.response) == nil {
+			notifyPostResult(p, mongodb.StatePosted, res)
 		}
-		return ok, err
-	} else {
-		//mongodb.UpdateRegisteredSwapStatusFailed(p.Key)
-		log.Warn("post Swap fail", "Key", p.Key, "chainID", p.ChainID, "pairID", p.PairID, "method", p.Method, "rpc", p.SwapServer, "err", ok)
+		return nil, nil
+	case mongodb.PostResultTransient:
+		if errors.Is(res.err, errSwapServerUnavailable) {
+			// not posted as circuit breaker is open, post after cooldown without counting an attempt
+			log.Info("post Swap skipped", "Key", p.Key, "rpc", p.SwapServer, "err", res.err)
+			_ = storage.DB().UpdateRegisteredSwapPosting(p.Key, time.Now().Unix()+int64(poster.breaker.cooldown/time.Second))
+			return res.err, nil
+		}
+		log.Warn("post Swap fail", "Key", p.Key, "chainID", p.ChainID, "pairID", p.PairID, "method", p.Method, "rpc", p.SwapServer, "err", res.err)
+		scheduleSwapPostRetry(p, res.err)
+		return res.err, nil
+	default:
+		log.Info("post Swap finished", "Key", p.Key, "chainID", p.ChainID, "pairID", p.PairID, "method", p.Method, "rpc", p.SwapServer, "result", res.result, "code", res.code, "err", res.err)
+		state := mongodb.StateRejected
+		if res.result == mongodb.PostResultDuplicate {
+			state = mongodb.StateDuplicate
+		}
+		if storage.DB().UpdateRegisteredSwapPostResult(p.Key, state, res.result, res.code, res.response) == nil {
+			notifyPostResult(p, state, res)
+		}
+		return nil, res.err
+	}
+}
+
+// scheduleSwapPostRetry retry with exponential backoff, fail if exhausted
+func scheduleSwapPostRetry(p *mongodb.MgoRegisteredSwap, postErr error) {
+	retryCfg := params.GetPostRetryConfig()
+	attempts := p.Attempts + 1
+	if attempts >= retryCfg.MaxAttempts {
+		log.Warn("post Swap retry exhausted", "Key", p.Key, "attempts", attempts, "err", postErr)
+		if storage.DB().UpdateRegisteredSwapRetry(p.Key, attempts, postErr.Error(), 0, mongodb.StateFailed) == nil {
+			payload := newWebhookPayload(params.WebhookEventFailed, p)
+			payload.Status = mongodb.StateFailed
+			payload.PostResult = mongodb.PostResultTransient
+			payload.Error = postErr.Error()
+			payload.Attempts = attempts
+			notifyWebhooks(payload)
+		}
+		return
+	}
+	interval := retryCfg.GetRetryInterval(attempts)
+	nextAttempt := time.Now().Unix() + interval
+	log.Info("post Swap retry later", "Key", p.Key, "attempts", attempts, "interval", interval, "err", postErr)
+	_ = storage.DB().UpdateRegisteredSwapRetry(p.Key, attempts, postErr.Error(), nextAttempt, mongodb.StatePosting)
+}
+
+func loopRetrySwapPost() {
+	log.Info("start retry swap post loop job")
+	for {
+		if utils.IsCleanuping() {
+			return
+		}
+		sp, err := storage.DB().FindRegisteredSwapToRetry(maxRetryPostLimit)
+		if err == nil && len(sp) > 0 {
+			log.Info("loopRetrySwapPost", "len", len(sp))
+			for _, p := range sp {
+				dispatchSwapPost(p)
+			}
+		}
+		time.Sleep(retryPostInterval)
 	}
-	return err, nil
 }
 
 type swapPost struct {
@@ -104,9 +178,16 @@ type swapPost struct {
 	logIndex string
 }
 
-func postBridgeSwap(post *mongodb.MgoRegisteredSwap) (error, error) {
+func postBridgeSwap(post *mongodb.MgoRegisteredSwap) *postResult {
+	if !params.IsAllowedSwapServer(post.SwapServer, post.Method) {
+		return newRejectedPostResult(fmt.Errorf("swap server '%v' with method '%v' is not allowed", post.SwapServer, post.Method))
+	}
+	txid := post.TxID
+	if txid == "" {
+		txid = post.Key // old records are keyed by txid
+	}
 	swap := &swapPost{
-		txid:       post.Key,
+		txid:       txid,
 		pairID:     post.PairID,
 		rpcMethod:  post.Method,
 		chainID:    fmt.Sprintf("%v", post.ChainID),
@@ -116,33 +197,21 @@ func postBridgeSwap(post *mongodb.MgoRegisteredSwap) (error, error) {
 	return postSwapPost(swap)
 }
 
-func postSwapPost(swap *swapPost) (error, error) {
-	var needCached bool
-	var errPending error = errors.New("Post err")
+// postSwapPost post swap, retry at once a few times if failed for transient reasons
+func postSwapPost(swap *swapPost) (res *postResult) {
+	poster := getServerPoster(swap.swapServer)
 	for i := 0; i < rpcRetryCount; i++ {
-		ok, err := rpcPost(swap)
-		if ok == nil {
-			return nil, err
-		}
-		log.Warn("postSwapPost", "err", err)
-		if errors.Is(err, tokens.ErrTxNotFound) ||
-			strings.Contains(err.Error(), httpTimeoutKeywords) ||
-			strings.Contains(err.Error(), errConnectionRefused) ||
-			strings.Contains(err.Error(), errMaximumRequestLimit) {
-			needCached = true
-		} else {
-			errPending = nil
+		res = poster.post(swap)
+		if res.result != mongodb.PostResultTransient || poster.breaker.isOpen() {
+			return res
 		}
+		log.Warn("postSwapPost", "swap", swap, "err", res.err)
 		time.Sleep(rpcInterval)
 	}
-	if needCached {
-		log.Warn("cache swap", "swap", swap)
-		cachedSwapPosts.Add(swap)
-	}
-	return errPending, nil
+	return res
 }
 
-func rpcPost(swap *swapPost) (error, error) {
+func rpcPost(swap *swapPost) *postResult {
 	var isRouterSwap bool
 	var args interface{}
 	if swap.pairID != "" {
@@ -158,97 +227,41 @@ func rpcPost(swap *swapPost) (error, error) {
 			"logindex": swap.logIndex,
 		}
 	} else {
-		return nil, errors.New("wrong swap post item")
+		return newRejectedPostResult(errors.New("wrong swap post item"))
 	}
 
 	timeout := 300
 	reqID := 666
 	var result interface{}
 	err := client.RPCPostWithTimeoutAndID(&result, timeout, reqID, swap.swapServer, swap.rpcMethod, args)
-
 	if err != nil {
-		errmsg := fmt.Sprintf("%v", err)
-		if strings.Contains(errmsg, ", ") {
-			slice := strings.Split(errmsg, ", ")
-			errmsg = slice[1]
-		}
-		err = errors.New(errmsg)
-		ok, err1 := checkSwapPostError(err, args)
-		if ok == nil {
-			return nil, err1
-		}
-		if isRouterSwap {
-			log.Warn("post router swap failed", "swap", args, "server", swap.swapServer, "err", err)
-			return err, nil
-		}
-		if strings.Contains(err.Error(), bridgeSwapExistKeywords) {
-			log.Info("post bridge swap already exist", "swap", args)
-			return nil, err
-		} else {
-			log.Warn("post bridge swap failed", "swap", args, "server", swap.swapServer, "err", err)
-			return err, nil
-		}
+		res := classifyPostError(err)
+		log.Info("post swap failed", "swap", args, "server", swap.swapServer, "result", res.result, "code", res.code, "err", res.err)
+		return res
 	}
 
 	if !isRouterSwap {
 		log.Info("post bridge swap success", "swap", args)
-		return nil, nil
+		return &postResult{result: mongodb.PostResultSuccess, response: fmt.Sprintf("%v", result)}
 	}
 
+	// router swap server returns status of log index
 	var status string
 	if res, ok := result.(map[string]interface{}); ok {
 		status, _ = res[swap.logIndex].(string)
 	}
 	if status == "" {
-		err = errors.New("post router swap unmarshal result failed")
-		log.Error(err.Error(), "swap", args, "server", swap.swapServer, "result", result)
-		var resultMap map[string]interface{}
-		b, _ := json.Marshal(&result)
-		json.Unmarshal(b, &resultMap)
-		for _, value := range resultMap {
-			if strings.Contains(value.(string), routerSwapExistResult) ||
-				strings.Contains(value.(string), routerSwapExistResultTmp) {
-				log.Info("post router swap already exist", "swap", args)
-				return nil, nil
+		log.Error("post router swap unmarshal result failed", "swap", args, "server", swap.swapServer, "result", result)
+		if res, ok := result.(map[string]interface{}); ok {
+			for _, value := range res {
+				if str, ok := value.(string); ok && classifyRouterStatus(str).result == mongodb.PostResultDuplicate {
+					return classifyRouterStatus(str)
+				}
 			}
 		}
-		return err, nil
-	}
-	return checkRouterStatus(status, args)
-}
-
-func checkSwapPostError(err error, args interface{}) (error, error) {
-	if strings.Contains(err.Error(), routerSwapExistResult) ||
-		strings.Contains(err.Error(), routerSwapExistResultTmp) {
-		log.Info("post swap already exist", "swap", args)
-		return nil, err
-	}
-	if strings.Contains(err.Error(), swapIsClosedResult) {
-		log.Info("post router swap failed, swap is closed", "swap", args)
-		return nil, err
-	}
-	if strings.Contains(err.Error(), swapTradeNotSupport) {
-		log.Info("post router swap failed, swap trade not support", "swap", args)
-		return nil, err
-	}
-	return err, nil
-}
-
-func checkRouterStatus(status string, args interface{}) (error, error) {
-	if strings.Contains(status, postSwapSuccessResult) {
-		log.Info("post router swap success", "swap", args)
-		return nil, nil
-	}
-	if strings.Contains(status, routerSwapExistResult) ||
-		strings.Contains(status, routerSwapExistResultTmp) {
-		log.Info("post router swap already exist", "swap", args)
-		return nil, errors.New(routerSwapExistResult)
-	}
-	if strings.Contains(status, txWithWrongContract) {
-		log.Info("post router swap failed, tx with wrong contract", "swap", args)
-		return nil, errors.New(txWithWrongContract)
+		return newRejectedPostResult(errors.New("post router swap unmarshal result failed"))
 	}
-	err := errors.New(status)
-	log.Info("post router swap failed", "swap", args, "err", err)
-	return err, nil
+	res := classifyRouterStatus(status)
+	log.Info("post router swap finished", "swap", args, "server", swap.swapServer, "status", status, "result", res.result)
+	return res
 }
@@ -0,0 +1,104 @@
+package worker
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/weijun-sh/gethscan-server/log"
+	"github.com/weijun-sh/gethscan-server/mongodb"
+	"github.com/weijun-sh/gethscan-server/params"
+	"github.com/weijun-sh/gethscan-server/rpc/client"
+)
+
+// postResult classified result of posting swap to swap server
+type postResult struct {
+	result string // one of mongodb.PostResult*
+	code   int    // json-rpc error code if any
+	err    error  // error message or router swap status
+
+	response string // response of swap server
+}
+
+func newRejectedPostResult(err error) *postResult {
+	return &postResult{result: mongodb.PostResultRejected, err: err, response: err.Error()}
+}
+
+// builtin rules, matched after the configed rules
+var builtinPostResultRules = []*params.PostResultRule{
+	{Keyword: "already registered", Result: mongodb.PostResultDuplicate},
+	{Keyword: "alreday registered", Result: mongodb.PostResultDuplicate},
+	{Keyword: "mgoError: Item is duplicate", Result: mongodb.PostResultDuplicate},
+	{Keyword: "swap is closed", Result: mongodb.PostResultRejected},
+	{Keyword: "swap trade not support", Result: mongodb.PostResultRejected},
+	{Keyword: "tx with wrong contract", Result: mongodb.PostResultRejected},
+	{Keyword: "tx not found", Result: mongodb.PostResultTransient},
+	{Keyword: "rpc query error", Result: mongodb.PostResultTransient},
+	{Keyword: "You have reached maximum request limit", Result: mongodb.PostResultTransient},
+	{Keyword: "success", Result: mongodb.PostResultSuccess}, // router swap status
+}
+
+var postResultRules = builtinPostResultRules
+
+// initPostResultRules put configed rules before the builtin rules
+func initPostResultRules() {
+	configed := params.GetPostResultRules()
+	rules := make([]*params.PostResultRule, 0, len(configed)+len(builtinPostResultRules))
+	for _, rule := range configed {
+		if !mongodb.IsValidPostResult(rule.Result) {
+			log.Fatal("invalid post result rule", "code", rule.Code, "keyword", rule.Keyword, "result", rule.Result)
+		}
+		rules = append(rules, rule)
+	}
+	postResultRules = append(rules, builtinPostResultRules...)
+	log.Info("init post result rules success", "configed", len(configed), "total", len(postResultRules))
+}
+
+// matchPostResultRules return empty string if no rule matches
+func matchPostResultRules(code int, message string) string {
+	for _, rule := range postResultRules {
+		if rule.Code != 0 && rule.Code != code {
+			continue
+		}
+		if rule.Keyword != "" && !strings.Contains(message, rule.Keyword) {
+			continue
+		}
+		return rule.Result
+	}
+	return ""
+}
+
+// classifyPostError classify error of posting swap.
+// unmatched json-rpc errors are rejected by swap server,
+// other errors (eg. network or http errors) are transient.
+func classifyPostError(err error) *postResult {
+	code, message, isJSONRPCError := client.GetJSONRPCError(err)
+	if !isJSONRPCError {
+		message = err.Error()
+	}
+	res := &postResult{code: code, err: errors.New(message), response: message}
+	res.result = matchPostResultRules(code, message)
+	switch {
+	case res.result == mongodb.PostResultSuccess:
+		// an error is never success
+		res.result = mongodb.PostResultRejected
+	case res.result != "":
+	case isJSONRPCError:
+		res.result = mongodb.PostResultRejected
+	default:
+		res.result = mongodb.PostResultTransient
+	}
+	return res
+}
+
+// classifyRouterStatus classify status of log index returned by router swap server,
+// unmatched status is rejected.
+func classifyRouterStatus(status string) *postResult {
+	res := &postResult{result: matchPostResultRules(0, status), response: status}
+	if res.result == "" {
+		res.result = mongodb.PostResultRejected
+	}
+	if res.result != mongodb.PostResultSuccess {
+		res.err = errors.New(status)
+	}
+	return res
+}
@@ -0,0 +1,127 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/weijun-sh/gethscan-server/mongodb"
+	"github.com/weijun-sh/gethscan-server/params"
+	"github.com/weijun-sh/gethscan-server/rpc/client"
+)
+
+// newJSONRPCError get json-rpc error by posting to a server responding it
+func newJSONRPCError(t *testing.T, code int, message string) error {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"error":{"code":%d,"message":%q}}`, code, message)
+	}))
+	defer server.Close()
+	var result interface{}
+	err := client.RPCPost(&result, server.URL, "swap.Swapin")
+	if _, _, ok := client.GetJSONRPCError(err); !ok {
+		t.Fatalf("post to json-rpc server, have error %v, want json-rpc error", err)
+	}
+	return err
+}
+
+// setPostResultRules init post result rules with configed rules
+func setPostResultRules(t *testing.T, rules ...*params.PostResultRule) {
+	oldConfig := params.GetConfig()
+	params.SetConfig(&params.BridgeConfig{Server: &params.ServerConfig{PostResultRules: rules}})
+	initPostResultRules()
+	t.Cleanup(func() {
+		params.SetConfig(oldConfig)
+		postResultRules = builtinPostResultRules
+	})
+}
+
+type classifyPostErrorTestCase struct {
+	name       string
+	code       int // json-rpc error if not 0
+	message    string
+	wantResult string
+}
+
+var builtinClassifyPostErrorTestCases = []*classifyPostErrorTestCase{
+	{name: "bridge swap duplicate", code: -32003, message: "mgoError: Item is duplicate", wantResult: mongodb.PostResultDuplicate},
+	{name: "router swap already registered", code: -32099, message: "swap already registered", wantResult: mongodb.PostResultDuplicate},
+	{name: "router swap alreday registered", code: -32099, message: "swap alreday registered", wantResult: mongodb.PostResultDuplicate},
+	{name: "swap is closed", code: -32099, message: "verify swap failed! swap is closed", wantResult: mongodb.PostResultRejected},
+	{name: "tx with wrong contract", code: -32099, message: "tx with wrong contract", wantResult: mongodb.PostResultRejected},
+	{name: "tx not found", code: -32099, message: "verify swap failed! tx not found", wantResult: mongodb.PostResultTransient},
+	{name: "rpc query error", code: -32099, message: "rpc query error", wantResult: mongodb.PostResultTransient},
+	{name: "request limit", code: -32000, message: "You have reached maximum request limit", wantResult: mongodb.PostResultTransient},
+	{name: "unmatched json-rpc error", code: -32099, message: "unknown error", wantResult: mongodb.PostResultRejected},
+	{name: "json-rpc error is never success", code: -32099, message: "success", wantResult: mongodb.PostResultRejected},
+	{name: "connection refused", message: "dial tcp 127.0.0.1:11556: connect: connection refused", wantResult: mongodb.PostResultTransient},
+	{name: "http timeout", message: "Client.Timeout exceeded while awaiting headers", wantResult: mongodb.PostResultTransient},
+	{name: "network error with duplicate keyword", message: "proxy: already registered", wantResult: mongodb.PostResultDuplicate},
+}
+
+var configedClassifyPostErrorTestCases = []*classifyPostErrorTestCase{
+	{name: "configed keyword overrides builtin", code: -32099, message: "verify swap failed! swap is closed", wantResult: mongodb.PostResultTransient},
+	{name: "configed code matches any message", code: -32098, message: "unknown error", wantResult: mongodb.PostResultTransient},
+	{name: "configed code and keyword", code: -32097, message: "tx not stable", wantResult: mongodb.PostResultTransient},
+	{name: "configed code and keyword with other code", code: -32096, message: "tx not stable", wantResult: mongodb.PostResultRejected},
+	{name: "configed rule on network error", message: "connect: connection refused", wantResult: mongodb.PostResultRejected},
+	{name: "builtin rule without configed match", code: -32003, message: "mgoError: Item is duplicate", wantResult: mongodb.PostResultDuplicate},
+}
+
+var testPostResultRules = []*params.PostResultRule{
+	{Keyword: "swap is closed", Result: mongodb.PostResultTransient},
+	{Code: -32098, Result: mongodb.PostResultTransient},
+	{Code: -32097, Keyword: "not stable", Result: mongodb.PostResultTransient},
+	{Keyword: "connection refused", Result: mongodb.PostResultRejected},
+}
+
+func testClassifyPostError(t *testing.T, testCases []*classifyPostErrorTestCase) {
+	for _, tc := range testCases {
+		err := errors.New(tc.message)
+		if tc.code != 0 {
+			err = newJSONRPCError(t, tc.code, tc.message)
+		}
+		res := classifyPostError(err)
+		if res.result != tc.wantResult {
+			t.Errorf("%v: classify post error result mismatch, have %v, want %v", tc.name, res.result, tc.wantResult)
+		}
+		if res.code != tc.code || res.response != tc.message || res.err == nil || res.err.Error() != tc.message {
+			t.Errorf("%v: classify post error mismatch, have code %v response %v err %v", tc.name, res.code, res.response, res.err)
+		}
+	}
+}
+
+func TestClassifyPostError(t *testing.T) {
+	setPostResultRules(t)
+	testClassifyPostError(t, builtinClassifyPostErrorTestCases)
+}
+
+func TestClassifyPostErrorWithConfigedRules(t *testing.T) {
+	setPostResultRules(t, testPostResultRules...)
+	testClassifyPostError(t, configedClassifyPostErrorTestCases)
+}
+
+func TestClassifyRouterStatus(t *testing.T) {
+	setPostResultRules(t, &params.PostResultRule{Keyword: "swap is closed", Result: mongodb.PostResultTransient})
+
+	testCases := []struct {
+		status     string
+		wantResult string
+	}{
+		{status: "success", wantResult: mongodb.PostResultSuccess},
+		{status: "already registered", wantResult: mongodb.PostResultDuplicate},
+		{status: "swap trade not support", wantResult: mongodb.PostResultRejected},
+		{status: "swap is closed", wantResult: mongodb.PostResultTransient},
+		{status: "unknown status", wantResult: mongodb.PostResultRejected},
+	}
+	for _, tc := range testCases {
+		res := classifyRouterStatus(tc.status)
+		if res.result != tc.wantResult {
+			t.Errorf("classify router status '%v', have %v, want %v", tc.status, res.result, tc.wantResult)
+		}
+		if (res.err == nil) != (tc.wantResult == mongodb.PostResultSuccess) || res.response != tc.status {
+			t.Errorf("classify router status '%v', have err %v response %v", tc.status, res.err, res.response)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package worker
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/weijun-sh/gethscan-server/cmd/utils"
+	"github.com/weijun-sh/gethscan-server/log"
+	"github.com/weijun-sh/gethscan-server/params"
+	"github.com/weijun-sh/gethscan-server/tokens/eth"
+)
+
+// wait for no more changes before reloading, editors may write a file several times
+var reloadTokensDelay = 2 * time.Second
+
+// WatchScanTokensConfig reload scan tokens config when token pairs dir changed
+func WatchScanTokensConfig() {
+	pairsDir := params.GetTokenPairsDir()
+	if pairsDir == "" {
+		log.Warn("token pairs dir is empty")
+		return
+	}
+
+	watch, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Error("fsnotify.NewWatcher failed", "err", err)
+		return
+	}
+
+	err = watch.Add(pairsDir)
+	if err != nil {
+		log.Error("watch.Add token pairs dir failed", "err", err)
+		_ = watch.Close()
+		return
+	}
+
+	utils.TopWaitGroup.Add(1)
+	go startTokensConfigWatcher(watch)
+}
+
+func startTokensConfigWatcher(watch *fsnotify.Watcher) {
+	log.Info("start watch scan tokens config")
+	defer func() {
+		log.Info("stop watch scan tokens config")
+		_ = watch.Close()
+		utils.TopWaitGroup.Done()
+	}()
+
+	reloadTimer := time.NewTimer(reloadTokensDelay)
+	reloadTimer.Stop()
+
+	for {
+		select {
+		case <-utils.CleanupChan:
+			reloadTimer.Stop()
+			return
+		case ev, ok := <-watch.Events:
+			if !ok {
+				continue
+			}
+			log.Trace("fsnotify watch event", "event", ev)
+			if !params.IsScanTokensConfigFile(filepath.Base(ev.Name)) || ev.Op == fsnotify.Chmod {
+				continue
+			}
+			reloadTimer.Reset(reloadTokensDelay)
+		case <-reloadTimer.C:
+			if err := eth.ReloadCrossChain(); err != nil {
+				log.Error("reload scan tokens config failed", "err", err)
+			}
+		case werr, ok := <-watch.Errors:
+			if !ok {
+				continue
+			}
+			log.Warn("fsnotify watch error", "err", werr)
+		}
+	}
+}
@@ -23,7 +23,10 @@ func StartScanJob(isServer bool) {
 
 func StartParseChainTx() {
 	eth.InitCrossChain()
+	eth.StartScanChainJob()
+	WatchScanTokensConfig()
 	go loopParseChainTx()
+	go loopCheckConfirmations()
 }
 
 func loopParseChainTx() {
@@ -36,3 +39,13 @@ func loopParseChainTx() {
 	}
 }
 
+
+func loopCheckConfirmations() {
+	for {
+		if utils.IsCleanuping() {
+			return
+		}
+		eth.CheckSwapConfirmations()
+		time.Sleep(checkConfirmationsInterval)
+	}
+}
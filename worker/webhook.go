@@ -0,0 +1,196 @@
+package worker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/weijun-sh/gethscan-server/cmd/utils"
+	"github.com/weijun-sh/gethscan-server/log"
+	"github.com/weijun-sh/gethscan-server/mongodb"
+	"github.com/weijun-sh/gethscan-server/params"
+	"github.com/weijun-sh/gethscan-server/rpc/client"
+	"github.com/weijun-sh/gethscan-server/storage"
+)
+
+var (
+	deliverWebhookInterval = 3 * time.Second
+	maxDeliverWebhookLimit = 100
+	webhookTimeout         = 10 // seconds
+
+	deliverWebhookLeaseDuration = 2 * time.Duration(webhookTimeout) * time.Second
+)
+
+// webhookPayload json body posted to webhooks
+type webhookPayload struct {
+	ID         string // same for all webhooks, receivers can use it to dedup
+	Event      string
+	Timestamp  int64
+	Chain      string
+	TxID       string
+	PairID     string `json:",omitempty"`
+	ChainID    uint64 `json:",omitempty"`
+	LogIndex   uint64
+	RpcMethod  string
+	Status     mongodb.SwapState
+	PostResult string `json:",omitempty"`
+	Error      string `json:",omitempty"`
+	Attempts   int    `json:",omitempty"`
+}
+
+// StartWebhookJob deliver webhook notifications if any webhook is configed
+func StartWebhookJob() {
+	if len(params.GetWebhooks()) == 0 {
+		return
+	}
+	if err := params.CheckWebhooksConfig(); err != nil {
+		log.Fatal("check webhooks config failed", "err", err)
+	}
+	go loopDeliverWebhooks()
+}
+
+func newWebhookPayload(event string, p *mongodb.MgoRegisteredSwap) *webhookPayload {
+	txid := p.TxID
+	if txid == "" {
+		txid = p.Key // old records are keyed by txid
+	}
+	return &webhookPayload{
+		ID:         strings.ToLower(p.Key + ":" + event),
+		Event:      event,
+		Timestamp:  time.Now().Unix(),
+		Chain:      p.Chain,
+		TxID:       txid,
+		PairID:     p.PairID,
+		ChainID:    p.ChainID,
+		LogIndex:   p.LogIndex,
+		RpcMethod:  p.Method,
+		Status:     p.Status,
+		PostResult: p.PostResult,
+		Error:      p.LastError,
+		Attempts:   p.Attempts,
+	}
+}
+
+// notifyPostResult notify final post result, duplicate swap is regarded as posted
+func notifyPostResult(p *mongodb.MgoRegisteredSwap, state mongodb.SwapState, res *postResult) {
+	event := params.WebhookEventRejected
+	if state == mongodb.StatePosted || state == mongodb.StateDuplicate {
+		event = params.WebhookEventPosted
+	}
+	payload := newWebhookPayload(event, p)
+	payload.Status = state
+	payload.PostResult = res.result
+	payload.Error = ""
+	if res.err != nil {
+		payload.Error = res.err.Error()
+	}
+	notifyWebhooks(payload)
+}
+
+// notifyWebhooks queue notifications to webhooks subscribed the event
+func notifyWebhooks(payload *webhookPayload) {
+	var chainID string
+	if payload.PairID == "" {
+		chainID = fmt.Sprintf("%v", payload.ChainID)
+	}
+	var data []byte
+	for _, webhook := range params.GetWebhooks() {
+		if !webhook.IsSubscribed(payload.Event, payload.Chain, payload.PairID, chainID) {
+			continue
+		}
+		if data == nil {
+			var err error
+			if data, err = json.Marshal(payload); err != nil {
+				log.Warn("marshal webhook payload failed", "id", payload.ID, "err", err)
+				return
+			}
+		}
+		_ = storage.DB().AddWebhookEvent(&mongodb.MgoWebhookEvent{
+			Key:         strings.ToLower(payload.ID + ":" + webhook.Name),
+			Webhook:     webhook.Name,
+			Event:       payload.Event,
+			Payload:     string(data),
+			Status:      mongodb.WebhookEventPending,
+			NextAttempt: payload.Timestamp,
+			Timestamp:   payload.Timestamp,
+		})
+	}
+}
+
+func loopDeliverWebhooks() {
+	log.Info("start deliver webhooks loop job")
+	for {
+		if utils.IsCleanuping() {
+			return
+		}
+		events, err := storage.DB().FindWebhookEventsToDeliver(maxDeliverWebhookLimit)
+		if err == nil {
+			for _, ev := range events {
+				deliverWebhookEvent(ev)
+			}
+		}
+		time.Sleep(deliverWebhookInterval)
+	}
+}
+
+// deliverWebhookEvent retry with exponential backoff, fail if exhausted
+func deliverWebhookEvent(ev *mongodb.MgoWebhookEvent) {
+	if storage.DB().LeaseWebhookEvent(ev.Key, deliverWebhookLeaseDuration) != nil {
+		return // delivered by another instance
+	}
+	defer func() { _ = storage.DB().ReleaseWebhookEvent(ev.Key) }()
+	attempts := ev.Attempts + 1
+	webhook := params.GetWebhook(ev.Webhook)
+	if webhook == nil {
+		log.Warn("deliver webhook event failed", "key", ev.Key, "err", "webhook is not configed")
+		_ = storage.DB().UpdateWebhookEventDelivery(ev.Key, mongodb.WebhookEventFailed, attempts, "webhook is not configed", 0)
+		return
+	}
+	err := postWebhook(webhook, ev)
+	if err == nil {
+		log.Info("deliver webhook event success", "key", ev.Key, "attempts", attempts)
+		_ = storage.DB().UpdateWebhookEventDelivery(ev.Key, mongodb.WebhookEventDelivered, attempts, "", 0)
+		return
+	}
+	retryCfg := params.GetWebhookRetryConfig()
+	if attempts >= retryCfg.MaxAttempts {
+		log.Warn("deliver webhook event retry exhausted", "key", ev.Key, "attempts", attempts, "err", err)
+		_ = storage.DB().UpdateWebhookEventDelivery(ev.Key, mongodb.WebhookEventFailed, attempts, err.Error(), 0)
+		return
+	}
+	interval := retryCfg.GetRetryInterval(attempts)
+	log.Info("deliver webhook event retry later", "key", ev.Key, "attempts", attempts, "interval", interval, "err", err)
+	_ = storage.DB().UpdateWebhookEventDelivery(ev.Key, mongodb.WebhookEventPending, attempts, err.Error(), time.Now().Unix()+interval)
+}
+
+// signWebhookPayload hmac-sha256 signature of payload, in format 'sha256=<hex>'
+func signWebhookPayload(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(payload))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// postWebhook post payload signed by hmac-sha256 with secret of webhook
+func postWebhook(webhook *params.WebhookConfig, ev *mongodb.MgoWebhookEvent) error {
+	headers := map[string]string{
+		"X-Webhook-ID":        ev.Key,
+		"X-Webhook-Event":     ev.Event,
+		"X-Webhook-Signature": signWebhookPayload(webhook.Secret, ev.Payload),
+	}
+	resp, err := client.HTTPPost(webhook.URL, json.RawMessage(ev.Payload), nil, headers, webhookTimeout)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responds status '%v'", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package worker
+
+import (
+	"crypto/hmac"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/weijun-sh/gethscan-server/mongodb"
+	"github.com/weijun-sh/gethscan-server/params"
+)
+
+func TestSignWebhookPayload(t *testing.T) {
+	// hmac-sha256 test vector
+	have := signWebhookPayload("key", "The quick brown fox jumps over the lazy dog")
+	want := "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+	if have != want {
+		t.Errorf("sign webhook payload mismatch, have %v, want %v", have, want)
+	}
+	if signWebhookPayload("other key", "The quick brown fox jumps over the lazy dog") == want {
+		t.Errorf("sign webhook payload with other secret, have same signature")
+	}
+}
+
+func TestPostWebhook(t *testing.T) {
+	const secret = "webhook secret"
+	ev := &mongodb.MgoWebhookEvent{
+		Key:     "0xabcd:0:http://127.0.0.1:11556/rpc:posted:receiver",
+		Event:   params.WebhookEventPosted,
+		Payload: `{"ID":"0xabcd:0:http://127.0.0.1:11556/rpc:posted","Event":"posted"}`,
+	}
+
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != ev.Payload {
+			t.Errorf("webhook body mismatch, have %s, want %s", body, ev.Payload)
+		}
+		signature := r.Header.Get("X-Webhook-Signature")
+		if !hmac.Equal([]byte(signature), []byte(signWebhookPayload(secret, string(body)))) {
+			t.Errorf("webhook signature mismatch, have %v", signature)
+		}
+		if r.Header.Get("X-Webhook-ID") != ev.Key || r.Header.Get("X-Webhook-Event") != ev.Event {
+			t.Errorf("webhook headers mismatch, have %v", r.Header)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	webhook := &params.WebhookConfig{Name: "receiver", URL: server.URL, Secret: secret}
+	if err := postWebhook(webhook, ev); err != nil {
+		t.Errorf("post webhook failed: %v", err)
+	}
+	status = http.StatusInternalServerError
+	if err := postWebhook(webhook, ev); err == nil {
+		t.Errorf("post webhook responding status %v, have no error", status)
+	}
+}
@@ -20,6 +20,9 @@ func StartWork(isServer bool) {
 	client.InitHTTPClient()
 	StartParseChainTx()
 	StartPostJob()
+	StartMetricsJob()
+	StartWebhookJob()
+	StartArchiveJob()
 	return
 	//bridge.InitCrossChainBridge(isServer)
 